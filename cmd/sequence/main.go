@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/dohernandez/horizon-blockchain-games/internal"
+	"github.com/dohernandez/horizon-blockchain-games/internal/compress"
 	"github.com/dohernandez/horizon-blockchain-games/internal/conversor"
 	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
 	"github.com/dohernandez/horizon-blockchain-games/internal/warehouse"
+	"github.com/dohernandez/horizon-blockchain-games/version"
 )
 
 var conversorTypes = []string{conversor.GoinGeckoType, conversor.HardcodedType}
@@ -41,7 +48,20 @@ func isValidConversorAPIKeyType(convAPIKeyType string) bool {
 	return false
 }
 
-var storageTypes = []string{storage.FileSystemType, storage.BucketType}
+var conversorModes = []string{conversor.SpotMode, conversor.HistoricalMode}
+
+// isValidConversorMode checks if the input is a valid conversorModes.
+func isValidConversorMode(convMode string) bool {
+	for _, v := range conversorModes {
+		if v == convMode {
+			return true
+		}
+	}
+
+	return false
+}
+
+var storageTypes = []string{storage.FileSystemType, storage.BucketType, storage.S3Type}
 
 // isValidStorage checks if the input is a valid storage.
 func isValidStorage(storageType string) bool {
@@ -67,7 +87,7 @@ func isValidEnvironment(env string) bool {
 	return false
 }
 
-var warehouseType = []string{warehouse.PrintType, warehouse.BigQueryType}
+var warehouseType = []string{warehouse.PrintType, warehouse.BigQueryType, warehouse.PostgresType, warehouse.SQLiteType, warehouse.FileType}
 
 // isValidWarehouse checks if the input is a valid warehouse.
 func isValidWarehouse(warehouse string) bool {
@@ -80,6 +100,97 @@ func isValidWarehouse(warehouse string) bool {
 	return false
 }
 
+var bigQueryInsertModes = []string{warehouse.StreamInsertMode, warehouse.LoadInsertMode}
+
+// isValidBigQueryInsertMode checks if the input is a valid BigQuery insert mode.
+func isValidBigQueryInsertMode(mode string) bool {
+	for _, v := range bigQueryInsertModes {
+		if v == mode {
+			return true
+		}
+	}
+
+	return false
+}
+
+var writeModes = []string{warehouse.AppendWriteMode, warehouse.UpsertWriteMode, warehouse.ReplacePartitionWriteMode}
+
+// isValidWriteMode checks if the input is a valid write mode.
+func isValidWriteMode(mode string) bool {
+	for _, v := range writeModes {
+		if v == mode {
+			return true
+		}
+	}
+
+	return false
+}
+
+var warehouseFormats = []string{warehouse.CSVFormat, warehouse.JSONLFormat, warehouse.ParquetFormat}
+
+// isValidWarehouseFormat checks if the input is a valid file warehouse format.
+func isValidWarehouseFormat(format string) bool {
+	for _, v := range warehouseFormats {
+		if v == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+var printFormats = []string{warehouse.TablePrintFormat, warehouse.JSONPrintFormat, warehouse.CSVPrintFormat}
+
+// isValidPrintFormat checks if the input is a valid print warehouse format.
+func isValidPrintFormat(format string) bool {
+	for _, v := range printFormats {
+		if v == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+var errorPolicies = []string{
+	internal.FailFastPolicy.String(),
+	internal.SkipPolicy.String(),
+	internal.DeadLetterPolicy.String(),
+}
+
+// isValidErrorPolicy checks if the input is a valid error policy.
+func isValidErrorPolicy(policy string) bool {
+	for _, v := range errorPolicies {
+		if v == policy {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isValidStepFormat checks if the input is a valid step format.
+func isValidStepFormat(format string) bool {
+	for _, v := range internal.StepFormats() {
+		if v == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isValidStepCompression checks if the input is a valid step compression codec.
+func isValidStepCompression(codec string) bool {
+	for _, v := range compress.Codecs() {
+		if v == codec {
+			return true
+		}
+	}
+
+	return false
+}
+
 var sequenceFlags = []cli.Flag{
 	&cli.StringFlag{
 		Name:        "env",
@@ -146,11 +257,119 @@ var sequenceFlags = []cli.Flag{
 	&cli.StringFlag{
 		Name:        "file",
 		Required:    false,
-		Usage:       "file to read the data from",
+		Usage:       "file to read the data from, or a glob pattern (events/2024-04-15/*.csv) or prefix (events/2024-04-15/) to read several files sharing the same header",
 		DefaultText: "transactions.csv",
 		Value:       "transactions.csv",
 		EnvVars:     []string{"FILE", "DATA_FILE"},
 	},
+	&cli.StringFlag{
+		Name:        "step-format",
+		Required:    false,
+		Usage:       fmt.Sprintf("format of the intermediate step data %s, the step data is loaded regardless of the format it was saved with", internal.StepFormats()),
+		DefaultText: internal.CSVStepFormat,
+		Value:       internal.CSVStepFormat,
+		Action: func(_ *cli.Context, s string) error {
+			if !isValidStepFormat(s) {
+				return fmt.Errorf("invalid step format %s", s)
+			}
+
+			return nil
+		},
+		EnvVars: []string{"STEP_FORMAT"},
+	},
+	&cli.StringFlag{
+		Name:        "step-compression",
+		Required:    false,
+		Usage:       fmt.Sprintf("codec to compress the intermediate step data %s, the step data is loaded regardless of the codec it was saved with", compress.Codecs()),
+		DefaultText: compress.None,
+		Value:       compress.None,
+		Action: func(_ *cli.Context, s string) error {
+			if !isValidStepCompression(s) {
+				return fmt.Errorf("invalid step compression %s", s)
+			}
+
+			return nil
+		},
+		EnvVars: []string{"STEP_COMPRESSION"},
+	},
+	&cli.UintFlag{
+		Name:        "extract-concurrency",
+		Required:    false,
+		Usage:       "number of files read concurrently in the extractor step when --file matches several files",
+		DefaultText: "4",
+		Value:       4,
+		EnvVars:     []string{"EXTRACT_CONCURRENCY"},
+	},
+	&cli.UintFlag{
+		Name:        "checkpoint-rows",
+		Required:    false,
+		Usage:       "number of rows read between the checkpoints of the extractor step data, when it is saved, 0 disables the checkpoints, the checkpointed extractor step reads the files one at a time regardless of --extract-concurrency",
+		DefaultText: "0",
+		Value:       0,
+		EnvVars:     []string{"CHECKPOINT_ROWS"},
+	},
+	&cli.StringFlag{
+		Name:     "from-run",
+		Required: false,
+		Usage:    "id of the run to load the step data from, when the step producing it is not run, default the latest run of the step",
+		EnvVars:  []string{"FROM_RUN"},
+	},
+	&cli.DurationFlag{
+		Name:        "lock-ttl",
+		Required:    false,
+		Usage:       "time to live of the lock of the --dir folder or bucket held while running, renewed until the run is done",
+		DefaultText: "1m0s",
+		Value:       internal.DefaultLockTTL,
+		EnvVars:     []string{"LOCK_TTL"},
+		Action: func(_ *cli.Context, d time.Duration) error {
+			if d <= 0 {
+				return fmt.Errorf("invalid lock ttl %s, it must be positive", d)
+			}
+
+			return nil
+		},
+	},
+	&cli.BoolFlag{
+		Name:        "force-unlock",
+		Required:    false,
+		Usage:       "release the lock of the --dir folder or bucket held by another run before running, e.g. the lock of a crashed run",
+		DefaultText: "false",
+		EnvVars:     []string{"FORCE_UNLOCK"},
+	},
+	&cli.StringFlag{
+		Name:     "resume",
+		Required: false,
+		Usage:    "id of the run to resume from its last checkpoint, the step options must be the same of the run",
+		EnvVars:  []string{"RESUME"},
+	},
+	&cli.StringFlag{
+		Name:        "error-policy",
+		Required:    false,
+		Usage:       fmt.Sprintf("policy to apply to the malformed rows in the extractor step %s", errorPolicies),
+		DefaultText: internal.FailFastPolicy.String(),
+		Value:       internal.FailFastPolicy.String(),
+		Action: func(_ *cli.Context, s string) error {
+			if !isValidErrorPolicy(s) {
+				return fmt.Errorf("invalid error policy %s", s)
+			}
+
+			return nil
+		},
+		EnvVars: []string{"ERROR_POLICY"},
+	},
+	&cli.UintFlag{
+		Name:        "max-rejects",
+		Required:    false,
+		Usage:       "number of malformed rows rejected before failing the run when the error policy is skip or dead-letter, 0 means no limit",
+		DefaultText: "0",
+		EnvVars:     []string{"MAX_REJECTS"},
+	},
+	&cli.StringFlag{
+		Name:     "events",
+		Required: false,
+		Usage:    "JSON file mapping the events to the volume multiplier or to be ignored in the calculator step, by default BUY_ITEMS (1) and SELL_ITEMS (-1)",
+		EnvVars:  []string{"EVENTS_FILE"},
+	},
 	&cli.BoolFlag{
 		Name:        "test",
 		Required:    false,
@@ -192,6 +411,78 @@ var sequenceFlags = []cli.Flag{
 		Usage:    "API key to use with the coingecko conversor",
 		EnvVars:  []string{"CG_API_KEY"},
 	},
+	&cli.StringFlag{
+		Name:        "coingecko-mode",
+		Required:    false,
+		Usage:       fmt.Sprintf("mode to get the price with the coingecko conversor %s", conversorModes),
+		DefaultText: conversor.HistoricalMode,
+		Value:       conversor.HistoricalMode,
+		Action: func(_ *cli.Context, s string) error {
+			if !isValidConversorMode(s) {
+				return fmt.Errorf("invalid conversor mode %s", s)
+			}
+
+			return nil
+		},
+		EnvVars: []string{"CG_MODE"},
+	},
+	&cli.StringSliceFlag{
+		Name:     "coingecko-platforms",
+		Required: false,
+		Usage:    "chain id to CoinGecko asset platform mapping in the format <chain_id>=<platform>, added to the default mapping, to resolve the currencies by contract address",
+		Action: func(_ *cli.Context, ss []string) error {
+			for _, s := range ss {
+				if _, _, ok := strings.Cut(s, "="); !ok {
+					return fmt.Errorf("invalid coingecko platform %s", s)
+				}
+			}
+
+			return nil
+		},
+		EnvVars: []string{"CG_PLATFORMS"},
+	},
+	&cli.UintFlag{
+		Name:        "coingecko-retries",
+		Required:    false,
+		Usage:       "number of times a coingecko request is retried when it fails with a network error, a 429 or a 5xx status code",
+		DefaultText: "3",
+		Value:       3,
+		EnvVars:     []string{"CG_RETRIES"},
+	},
+	&cli.UintFlag{
+		Name:        "coingecko-rate-limit",
+		Required:    false,
+		Usage:       fmt.Sprintf("number of coingecko requests per minute, 0 means the quota of the API key type plan (%s: %d, %s: %d)", conversor.DemoKeyType, conversor.DemoRateLimit, conversor.ProKeyType, conversor.ProRateLimit),
+		DefaultText: "0",
+		EnvVars:     []string{"CG_RATE_LIMIT"},
+	},
+	&cli.StringFlag{
+		Name:     "price-cache",
+		Required: false,
+		Usage:    "file to persist the conversor rates to be shared across runs, stored in the --dir folder or bucket unless --price-cache-local is set",
+		EnvVars:  []string{"PRICE_CACHE"},
+	},
+	&cli.BoolFlag{
+		Name:        "price-cache-local",
+		Required:    false,
+		Usage:       "persist the price cache in the local file system instead of the --dir folder or bucket",
+		DefaultText: "false",
+		EnvVars:     []string{"PRICE_CACHE_LOCAL"},
+	},
+	&cli.DurationFlag{
+		Name:        "price-cache-ttl",
+		Required:    false,
+		Usage:       "time a cached rate is valid since it was fetched, 0 means the rates never expire",
+		DefaultText: "0",
+		EnvVars:     []string{"PRICE_CACHE_TTL"},
+	},
+	&cli.BoolFlag{
+		Name:        "offline",
+		Required:    false,
+		Usage:       "fail when a rate is not in the price cache instead of requesting it to the conversor, requires --price-cache",
+		DefaultText: "false",
+		EnvVars:     []string{"OFFLINE"},
+	},
 	&cli.StringFlag{
 		Name:        "storage-type",
 		Required:    false,
@@ -212,6 +503,25 @@ var sequenceFlags = []cli.Flag{
 		Hidden:   true,
 		EnvVars:  []string{"GCP_BUCKET_ENDPOINT"},
 	},
+	&cli.StringFlag{
+		Name:     "s3-endpoint",
+		Required: false,
+		Usage:    "endpoint of the S3-compatible service with the s3 storage type, such as http://localhost:9000 for MinIO",
+		EnvVars:  []string{"S3_ENDPOINT"},
+	},
+	&cli.StringFlag{
+		Name:     "s3-region",
+		Required: false,
+		Usage:    "region of the bucket with the s3 storage type, by default resolved from the AWS configuration",
+		EnvVars:  []string{"S3_REGION", "AWS_REGION"},
+	},
+	&cli.BoolFlag{
+		Name:        "s3-path-style",
+		Required:    false,
+		Usage:       "address the bucket in the URL path instead of the host with the s3 storage type, required by MinIO",
+		DefaultText: "false",
+		EnvVars:     []string{"S3_PATH_STYLE"},
+	},
 	&cli.BoolFlag{
 		Name:        "verbose",
 		Required:    false,
@@ -234,22 +544,199 @@ var sequenceFlags = []cli.Flag{
 			return nil
 		},
 	},
+	&cli.StringFlag{
+		Name:        "write-mode",
+		Required:    false,
+		Usage:       fmt.Sprintf("mode to write the rows into the warehouse table %s, upsert and replace-partition are keyed on date and project id", writeModes),
+		DefaultText: warehouse.AppendWriteMode,
+		Value:       warehouse.AppendWriteMode,
+		Action: func(_ *cli.Context, s string) error {
+			if !isValidWriteMode(s) {
+				return fmt.Errorf("invalid write mode %s", s)
+			}
+
+			return nil
+		},
+		EnvVars: []string{"WRITE_MODE"},
+	},
+	&cli.StringFlag{
+		Name:        "warehouse-format",
+		Required:    false,
+		Usage:       fmt.Sprintf("format of the files written by the file warehouse into date=<date>/part-0.<format> of the --dir folder or bucket %s", warehouseFormats),
+		DefaultText: warehouse.CSVFormat,
+		Value:       warehouse.CSVFormat,
+		Action: func(_ *cli.Context, s string) error {
+			if !isValidWarehouseFormat(s) {
+				return fmt.Errorf("invalid warehouse format %s", s)
+			}
+
+			return nil
+		},
+		EnvVars: []string{"WAREHOUSE_FORMAT"},
+	},
+	&cli.StringFlag{
+		Name:        "print-format",
+		Required:    false,
+		Usage:       fmt.Sprintf("format to print the rows sorted by date and project id with the print warehouse %s", printFormats),
+		DefaultText: warehouse.TablePrintFormat,
+		Value:       warehouse.TablePrintFormat,
+		Action: func(_ *cli.Context, s string) error {
+			if !isValidPrintFormat(s) {
+				return fmt.Errorf("invalid print format %s", s)
+			}
+
+			return nil
+		},
+		EnvVars: []string{"PRINT_FORMAT"},
+	},
+	&cli.StringFlag{
+		Name:     "print-output",
+		Required: false,
+		Usage:    "file to print the rows into with the print warehouse, by default the standard output",
+		EnvVars:  []string{"PRINT_OUTPUT"},
+	},
 	&cli.StringFlag{
 		Name:     "bigquery-dataset",
 		Required: false,
 		Usage:    "BigQuery dataset in the following format <project_id.dataset.table>",
+		Action:   validateBigQueryDataset,
+		EnvVars:  []string{"BIGQUERY_DATASET"},
+	},
+	&cli.BoolFlag{
+		Name:        "bigquery-auto-migrate",
+		Required:    false,
+		Usage:       "create the BigQuery table, or add the missing columns, before the first insert",
+		DefaultText: "true",
+		Value:       true,
+		EnvVars:     []string{"BIGQUERY_AUTO_MIGRATE"},
+	},
+	&cli.StringFlag{
+		Name:        "bigquery-insert-mode",
+		Required:    false,
+		Usage:       fmt.Sprintf("mode to insert the rows into BigQuery %s, load requires the bucket storage type", bigQueryInsertModes),
+		DefaultText: warehouse.StreamInsertMode,
+		Value:       warehouse.StreamInsertMode,
 		Action: func(_ *cli.Context, s string) error {
-			parts := strings.Split(s, ".")
-			if len(parts) != 3 {
-				return fmt.Errorf("invalid BigQuery dataset %s", s)
+			if !isValidBigQueryInsertMode(s) {
+				return fmt.Errorf("invalid BigQuery insert mode %s", s)
 			}
 
 			return nil
 		},
-		EnvVars: []string{"BIGQUERY_DATASET"},
+		EnvVars: []string{"BIGQUERY_INSERT_MODE"},
+	},
+	&cli.UintFlag{
+		Name:        "bigquery-batch-size",
+		Required:    false,
+		Usage:       "number of rows inserted per request into BigQuery in stream insert mode",
+		DefaultText: "500",
+		Value:       500,
+		EnvVars:     []string{"BIGQUERY_BATCH_SIZE"},
+	},
+	&cli.StringFlag{
+		Name:     "postgres-url",
+		Required: false,
+		Usage:    "Postgres connection string, overrides the other Postgres connection flags",
+		EnvVars:  []string{"POSTGRES_URL"},
+	},
+	&cli.StringFlag{
+		Name:        "postgres-host",
+		Required:    false,
+		Usage:       "Postgres host",
+		DefaultText: "localhost",
+		Value:       "localhost",
+		EnvVars:     []string{"POSTGRES_HOST"},
+	},
+	&cli.IntFlag{
+		Name:        "postgres-port",
+		Required:    false,
+		Usage:       "Postgres port",
+		DefaultText: "5432",
+		Value:       5432,
+		EnvVars:     []string{"POSTGRES_PORT"},
+	},
+	&cli.StringFlag{
+		Name:        "postgres-user",
+		Required:    false,
+		Usage:       "Postgres user",
+		DefaultText: "postgres",
+		Value:       "postgres",
+		EnvVars:     []string{"POSTGRES_USER"},
+	},
+	&cli.StringFlag{
+		Name:     "postgres-password",
+		Required: false,
+		Usage:    "Postgres password",
+		EnvVars:  []string{"POSTGRES_PASSWORD"},
+	},
+	&cli.StringFlag{
+		Name:        "postgres-database",
+		Required:    false,
+		Usage:       "Postgres database",
+		DefaultText: "postgres",
+		Value:       "postgres",
+		EnvVars:     []string{"POSTGRES_DATABASE"},
+	},
+	&cli.StringFlag{
+		Name:        "postgres-sslmode",
+		Required:    false,
+		Usage:       "Postgres SSL mode (disable, prefer, require, verify-ca, verify-full)",
+		DefaultText: "prefer",
+		Value:       "prefer",
+		EnvVars:     []string{"POSTGRES_SSLMODE"},
+	},
+	&cli.StringFlag{
+		Name:        "postgres-table",
+		Required:    false,
+		Usage:       "Postgres table, created if it does not exist",
+		DefaultText: "sample_data",
+		Value:       "sample_data",
+		EnvVars:     []string{"POSTGRES_TABLE"},
+	},
+	&cli.UintFlag{
+		Name:        "postgres-batch-size",
+		Required:    false,
+		Usage:       "number of rows copied per batch into Postgres",
+		DefaultText: "1000",
+		Value:       1000,
+		EnvVars:     []string{"POSTGRES_BATCH_SIZE"},
+	},
+	&cli.StringFlag{
+		Name:        "sqlite-path",
+		Required:    false,
+		Usage:       "SQLite database file, created if it does not exist",
+		DefaultText: "sequence.db",
+		Value:       "sequence.db",
+		EnvVars:     []string{"SQLITE_PATH"},
+	},
+}
+
+var migrateFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "bigquery-dataset",
+		Required: true,
+		Usage:    "BigQuery dataset in the following format <project_id.dataset.table>",
+		Action:   validateBigQueryDataset,
+		EnvVars:  []string{"BIGQUERY_DATASET"},
+	},
+	&cli.BoolFlag{
+		Name:        "dry-run",
+		Required:    false,
+		Usage:       "print the planned DDL statements without applying them",
+		DefaultText: "false",
 	},
 }
 
+// validateBigQueryDataset checks if the input is a BigQuery dataset in the format <project_id.dataset.table>.
+func validateBigQueryDataset(_ *cli.Context, s string) error {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid BigQuery dataset %s", s)
+	}
+
+	return nil
+}
+
 func main() {
 	app := &cli.App{
 		Name:  "sequence",
@@ -259,31 +746,115 @@ func main() {
 				Name:        "run",
 				Description: "Run pipeline, or a specific step depending on options",
 				Flags:       sequenceFlags,
-				Action: func(c *cli.Context) error {
+				Action: func(c *cli.Context) (err error) {
+					// Pipeline
+					// Configure pipeline
+					cfgPipeline, err := loadPipelineConfig(c)
+					if err != nil {
+						return err
+					}
+
+					// The run identifier is shared with the backend, which writes files of the run too.
+					if cfgPipeline.RunID == "" {
+						cfgPipeline.RunID = internal.NewRunID()
+					}
+
 					// Backend
-					// Configure backend
-					cfg, err := loadConfig(c)
+					// Configure backend, for the steps enabled in the pipeline
+					cfg, err := loadConfig(c, cfgPipeline)
 					if err != nil {
 						return err
 					}
 
+					if output := c.String("print-output"); output != "" {
+						f, err := os.Create(filepath.Clean(output))
+						if err != nil {
+							return fmt.Errorf("creating print output: %w", err)
+						}
+
+						defer f.Close() //nolint:errcheck
+
+						cfg.PrintOutput = f
+					}
+
 					b := internal.NewBackend(cfg)
 
-					// Pipeline
-					// Configure pipeline
-					cfgPipeline := loadPipelineConfig(c)
+					// The backend is closed even when the run fails, to persist what was done so far and close the warehouse.
+					defer func() {
+						err = errors.Join(err, b.Close(context.WithoutCancel(c.Context)))
+					}()
 
 					// Run pipeline
 					p := internal.NewPipeline(b, cfgPipeline)
 
+					log.Printf("run id %s", p.RunID())
+
 					err = p.Run(c.Context)
 					if err != nil {
 						return err
 					}
 
+					// Report
+					report := p.Report()
+
+					for _, file := range slices.Sorted(maps.Keys(report.FileRows)) {
+						log.Printf("read %d rows from %s", report.FileRows[file], file)
+					}
+
+					for _, event := range slices.Sorted(maps.Keys(report.UnmatchedEvents)) {
+						log.Printf("skipped %d transactions with unmatched event %s", report.UnmatchedEvents[event], event)
+					}
+
 					return nil
 				},
 			},
+			{
+				Name:        "warehouse",
+				Description: "Manage the warehouse table",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "migrate",
+						Description: "Create the BigQuery table, or add the columns missing in the table, inferred from the data structure",
+						Flags:       migrateFlags,
+						Action: func(c *cli.Context) error {
+							parts := strings.Split(c.String("bigquery-dataset"), ".")
+
+							b := warehouse.NewBigQuery(warehouse.BigQueryConfig{
+								ProjectID: parts[0],
+								Dataset:   parts[1],
+								Table:     parts[2],
+							})
+
+							defer b.Close() //nolint:errcheck
+
+							var (
+								ddl []string
+								err error
+							)
+
+							if c.Bool("dry-run") {
+								ddl, err = b.PlanMigration(c.Context)
+							} else {
+								ddl, err = b.Migrate(c.Context)
+							}
+
+							if err != nil {
+								return err
+							}
+
+							if len(ddl) == 0 {
+								log.Printf("table %s is up to date", c.String("bigquery-dataset"))
+							}
+
+							for _, stmt := range ddl {
+								fmt.Printf("%s;\n\n", stmt)
+							}
+
+							return nil
+						},
+					},
+				},
+			},
 		},
 	}
 
@@ -293,7 +864,7 @@ func main() {
 	}
 }
 
-func loadConfig(c *cli.Context) (internal.Config, error) {
+func loadConfig(c *cli.Context, cfgPipeline internal.PipelineConfig) (internal.Config, error) {
 	cfg := internal.Config{}
 
 	cfg.Environment = c.String("env")
@@ -301,6 +872,12 @@ func loadConfig(c *cli.Context) (internal.Config, error) {
 	cfg.File = c.String("file")
 	cfg.IsTest = c.Bool("test")
 	cfg.Logger = c.Bool("verbose")
+	cfg.RunID = cfgPipeline.RunID
+
+	// The print warehouse is used in test mode too.
+	cfg.Print = warehouse.PrintConfig{
+		Format: c.String("print-format"),
+	}
 
 	if cfg.IsTest {
 		return cfg, nil
@@ -317,31 +894,98 @@ func loadConfig(c *cli.Context) (internal.Config, error) {
 		geckoCfg := conversor.CoinGeckoConfig{
 			KeyType: c.String("coingecko-api-key-type"),
 			Key:     c.String("coingecko-api-key"),
+			Mode:    c.String("coingecko-mode"),
+
+			Retries:   c.Int("coingecko-retries"),
+			RateLimit: c.Int("coingecko-rate-limit"),
+		}
+
+		if platforms := c.StringSlice("coingecko-platforms"); len(platforms) > 0 {
+			geckoCfg.Platforms = maps.Clone(conversor.DefaultPlatforms)
+
+			for _, p := range platforms {
+				chainID, platform, _ := strings.Cut(p, "=")
+
+				geckoCfg.Platforms[chainID] = platform
+			}
 		}
 
 		cfg.CoinGecko = geckoCfg
 	}
 
+	if c.Bool("offline") && c.String("price-cache") == "" {
+		return cfg, fmt.Errorf("price cache is required in offline mode")
+	}
+
+	cfg.PriceCache = conversor.CacheConfig{
+		File:    c.String("price-cache"),
+		TTL:     c.Duration("price-cache-ttl"),
+		Offline: c.Bool("offline"),
+	}
+	cfg.PriceCacheLocal = c.Bool("price-cache-local")
+
 	cfg.StorageType = c.String("storage-type")
 	cfg.GCPBucketEndpoint = c.String("gcp-bucket-endpoint")
+	cfg.S3 = storage.S3Config{
+		Region:       c.String("s3-region"),
+		Endpoint:     c.String("s3-endpoint"),
+		UsePathStyle: c.Bool("s3-path-style"),
+	}
 
 	cfg.WarehouseType = c.String("warehouse")
 
-	// Config the BigQuery dataset if in the insertion step.
-	if c.Bool("insertion") && cfg.WarehouseType == warehouse.BigQueryType {
+	// Config the BigQuery dataset if in the insertion step, enabled alone or along with the other steps.
+	if cfgPipeline.InsertStepEnabled && cfg.WarehouseType == warehouse.BigQueryType {
 		parts := strings.Split(c.String("bigquery-dataset"), ".")
 
 		cfg.BigQuery = warehouse.BigQueryConfig{
-			ProjectID: parts[0],
-			Dataset:   parts[1],
-			Table:     parts[2],
+			ProjectID:  parts[0],
+			Dataset:    parts[1],
+			Table:      parts[2],
+			InsertMode: c.String("bigquery-insert-mode"),
+			BatchSize:  c.Int("bigquery-batch-size"),
+			WriteMode:  c.String("write-mode"),
+
+			AutoMigrate: c.Bool("bigquery-auto-migrate"),
+		}
+
+		if cfg.BigQuery.InsertMode == warehouse.LoadInsertMode && cfg.StorageType != storage.BucketType {
+			return cfg, fmt.Errorf("%s storage type is required in BigQuery %s insert mode", storage.BucketType, warehouse.LoadInsertMode)
+		}
+	}
+
+	if cfg.WarehouseType == warehouse.PostgresType {
+		cfg.Postgres = warehouse.PostgresConfig{
+			URL:       c.String("postgres-url"),
+			Host:      c.String("postgres-host"),
+			Port:      c.Int("postgres-port"),
+			User:      c.String("postgres-user"),
+			Password:  c.String("postgres-password"),
+			Database:  c.String("postgres-database"),
+			SSLMode:   c.String("postgres-sslmode"),
+			Table:     c.String("postgres-table"),
+			BatchSize: c.Int("postgres-batch-size"),
+			WriteMode: c.String("write-mode"),
+		}
+	}
+
+	if cfg.WarehouseType == warehouse.FileType {
+		cfg.FileWarehouse = warehouse.FileConfig{
+			Format: c.String("warehouse-format"),
+		}
+	}
+
+	if cfg.WarehouseType == warehouse.SQLiteType {
+		cfg.SQLite = warehouse.SQLiteConfig{
+			Path:      c.String("sqlite-path"),
+			WriteMode: c.String("write-mode"),
 		}
 	}
 
 	return cfg, nil
 }
 
-func loadPipelineConfig(c *cli.Context) internal.PipelineConfig {
+func loadPipelineConfig(c *cli.Context) (internal.PipelineConfig, error) {
 	cfgPipeline := internal.PipelineConfig{}
 
 	all := c.Bool("all")
@@ -372,5 +1016,36 @@ func loadPipelineConfig(c *cli.Context) internal.PipelineConfig {
 
 	cfgPipeline.Workers = c.Int("workers")
 
-	return cfgPipeline
+	cfgPipeline.ErrorPolicy = internal.ErrorPolicy(c.String("error-policy"))
+	cfgPipeline.MaxRejects = c.Int("max-rejects")
+	cfgPipeline.ExtractConcurrency = c.Int("extract-concurrency")
+	cfgPipeline.StepFormat = c.String("step-format")
+	cfgPipeline.StepCompression = c.String("step-compression")
+	cfgPipeline.ProducerVersion = version.Version()
+	cfgPipeline.SourceFile = c.String("file")
+	cfgPipeline.CheckpointRows = c.Int("checkpoint-rows")
+	cfgPipeline.FromRunID = c.String("from-run")
+	cfgPipeline.LockTTL = c.Duration("lock-ttl")
+	cfgPipeline.ForceUnlock = c.Bool("force-unlock")
+
+	if cfgPipeline.CheckpointRows > 0 && c.IsSet("extract-concurrency") && cfgPipeline.ExtractConcurrency > 1 {
+		log.Printf("--checkpoint-rows reads the files one at a time, --extract-concurrency %d is ignored when the run is checkpointed",
+			cfgPipeline.ExtractConcurrency)
+	}
+
+	if runID := c.String("resume"); runID != "" {
+		cfgPipeline.RunID = runID
+		cfgPipeline.Resume = true
+	}
+
+	if c.String("events") != "" {
+		events, err := internal.LoadEventRegistry(c.String("events"))
+		if err != nil {
+			return cfgPipeline, err
+		}
+
+		cfgPipeline.Events = events
+	}
+
+	return cfgPipeline, nil
 }
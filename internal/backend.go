@@ -2,6 +2,10 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
 
 	"github.com/bool64/ctxd"
 	"github.com/bool64/zapctxd"
@@ -21,6 +25,8 @@ type Config struct {
 	Dir string
 	// File is the file to load the data.
 	File string
+	// RunID is the identifier of the run, the files written by the providers for the run are stored into its folder.
+	RunID string
 	// IsTest is to run the application in test mode.
 	// When the application is in test mode, all dependencies are filesystem.
 	IsTest bool
@@ -31,6 +37,12 @@ type Config struct {
 	// CoinGecko holds the configuration for the CoinGecko conversor.
 	CoinGecko conversor.CoinGeckoConfig
 
+	// PriceCache holds the configuration for the cache of the conversor rates shared across runs.
+	// If the file is empty, the rates are not persisted.
+	PriceCache conversor.CacheConfig
+	// PriceCacheLocal is to persist the price cache in the local file system instead of the step storage.
+	PriceCacheLocal bool
+
 	// StorageType is the type of storage to use.
 	StorageType string
 
@@ -38,11 +50,26 @@ type Config struct {
 	// It is mainly used for testing purposes.
 	GCPBucketEndpoint string
 
+	// S3 holds the configuration of the S3 storage, the bucket and file are set from Dir and File.
+	S3 storage.S3Config
+
 	// WarehouseType is the type of warehouse to use.
 	WarehouseType string
 
+	Print warehouse.PrintConfig
+	// PrintOutput is the writer to print the rows with the print warehouse.
+	// If it is nil, the rows are printed into os.Stdout.
+	PrintOutput io.Writer
+
 	BigQuery warehouse.BigQueryConfig
 
+	Postgres warehouse.PostgresConfig
+
+	SQLite warehouse.SQLiteConfig
+
+	// FileWarehouse holds the configuration for the file warehouse, written into the step storage.
+	FileWarehouse warehouse.FileConfig
+
 	// Logger is to enable logger.
 	Logger bool
 }
@@ -55,6 +82,9 @@ type Backend struct {
 	conversor       Conversor
 	loadProvider    WarehouseProvider
 	stepProvider    StepProvider
+
+	// priceCache is the cache wrapping the conversor, when the rates are persisted.
+	priceCache *conversor.Cache
 }
 
 // NewBackend creates a new backend with the given configuration.
@@ -92,7 +122,13 @@ func NewBackend(cfg Config) *Backend {
 
 	logger.Debug(ctx, "initializing loadProvider with print target")
 
-	b.loadProvider = &warehouse.Print{}
+	var printOpts []warehouse.PrintOption
+
+	if cfg.PrintOutput != nil {
+		printOpts = append(printOpts, warehouse.WithOutput(cfg.PrintOutput))
+	}
+
+	b.loadProvider = warehouse.NewPrint(cfg.Print, printOpts...)
 
 	if cfg.IsTest {
 		return &b
@@ -119,6 +155,19 @@ func NewBackend(cfg Config) *Backend {
 		b.stepProvider = stgcp
 	}
 
+	if cfg.StorageType == storage.S3Type {
+		logger.Debug(ctx, "replacing extractProvider with S3 storage")
+
+		s3Cfg := cfg.S3
+		s3Cfg.Bucket = cfg.Dir
+		s3Cfg.File = cfg.File
+
+		sts3 := storage.NewS3(s3Cfg, storage.WithS3Logger(logger))
+
+		b.extractProvider = sts3
+		b.stepProvider = sts3
+	}
+
 	// Conversor.
 	if cfg.ConversorType == conversor.GoinGeckoType {
 		logger.Debug(ctx, "replacing conversor with CoinGecko")
@@ -129,16 +178,76 @@ func NewBackend(cfg Config) *Backend {
 			cfg.CoinGecko.URL = conversor.ProBaseURL
 		}
 
+		if cfg.CoinGecko.RateLimit == 0 {
+			cfg.CoinGecko.RateLimit = conversor.DemoRateLimit
+
+			if cfg.CoinGecko.KeyType == conversor.ProKeyType {
+				cfg.CoinGecko.RateLimit = conversor.ProRateLimit
+			}
+		}
+
 		logger.Debug(ctx, "CoinGecko conversor configuration", "config", cfg.CoinGecko)
 
 		b.conversor = conversor.NewCoinGecko(cfg.CoinGecko, conversor.WithLogger(logger))
 	}
 
+	// Price cache.
+	if cfg.PriceCache.File != "" {
+		logger.Debug(ctx, "wrapping conversor with price cache", "file", cfg.PriceCache.File)
+
+		cfg.PriceCache.Source = conversor.HardcodedType
+
+		if cfg.ConversorType == conversor.GoinGeckoType {
+			cfg.PriceCache.Source = conversor.CoinGeckoSource(cfg.CoinGecko)
+		}
+
+		var cacheStorage conversor.CacheStorage = b.stepProvider
+
+		if cfg.PriceCacheLocal {
+			cacheStorage = storage.NewFileSystem(filepath.Dir(cfg.PriceCache.File), "")
+			cfg.PriceCache.File = filepath.Base(cfg.PriceCache.File)
+		}
+
+		b.priceCache = conversor.NewCache(b.conversor, cacheStorage, cfg.PriceCache, conversor.WithCacheLogger(logger))
+		b.conversor = b.priceCache
+	}
+
 	// Warehouse.
 	if cfg.WarehouseType == warehouse.BigQueryType {
 		logger.Debug(ctx, "replacing warehouse with BigQuery")
 
-		b.loadProvider = warehouse.NewBigQuery(cfg.BigQuery)
+		var opts []warehouse.BigQueryOption
+
+		if cfg.BigQuery.InsertMode == warehouse.LoadInsertMode {
+			logger.Debug(ctx, "loading BigQuery rows from the bucket", "bucket", cfg.Dir)
+
+			// The rows are written into the run folder of the bucket of the step data to be loaded by the load job, so
+			// the runs sharing the bucket do not overwrite each other.
+			cfg.BigQuery.LoadBucket = cfg.Dir
+			cfg.BigQuery.LoadFile = runFile(cfg.RunID, "insertion.csv")
+
+			opts = append(opts, warehouse.WithLoadStorage(b.stepProvider))
+		}
+
+		b.loadProvider = warehouse.NewBigQuery(cfg.BigQuery, opts...)
+	}
+
+	if cfg.WarehouseType == warehouse.PostgresType {
+		logger.Debug(ctx, "replacing warehouse with Postgres")
+
+		b.loadProvider = warehouse.NewPostgres(cfg.Postgres)
+	}
+
+	if cfg.WarehouseType == warehouse.SQLiteType {
+		logger.Debug(ctx, "replacing warehouse with SQLite", "path", cfg.SQLite.Path)
+
+		b.loadProvider = warehouse.NewSQLite(cfg.SQLite)
+	}
+
+	if cfg.WarehouseType == warehouse.FileType {
+		logger.Debug(ctx, "replacing warehouse with files", "format", cfg.FileWarehouse.Format)
+
+		b.loadProvider = warehouse.NewFile(cfg.FileWarehouse, b.stepProvider)
 	}
 
 	return &b
@@ -163,3 +272,23 @@ func (b *Backend) WarehouseProvider() WarehouseProvider {
 func (b *Backend) StepProvider() StepProvider {
 	return b.stepProvider
 }
+
+// Close releases the providers dependencies once the run is done, persisting the price cache and closing the
+// warehouse connection.
+func (b *Backend) Close(ctx context.Context) error {
+	var errs []error
+
+	if b.priceCache != nil {
+		if err := b.priceCache.Flush(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("flushing price cache: %w", err))
+		}
+	}
+
+	if c, ok := b.loadProvider.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing warehouse: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -3,6 +3,8 @@ package internal
 import (
 	"context"
 	"fmt"
+	"math/big"
+	"time"
 
 	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
 )
@@ -12,13 +14,61 @@ import (
 // Conversor is the interface that provides the ability to convert the value in the given currency to USD.
 type Conversor interface {
 	// ConvertUSD converts the value in the given currency to USD.
-	ConvertUSD(ctx context.Context, valueDecimal float64, symbol string) (float64, error)
+	//
+	// The value is an exact decimal number, the callers must not modify the returned value.
+	//
+	// The timestamp is the time of the transaction, used by the implementations which convert the value based on the
+	// rate of the day the transaction happened.
+	ConvertUSD(ctx context.Context, value *big.Rat, currency entities.Currency, ts time.Time) (*big.Rat, error)
+}
+
+// CalculateOption is a convenience type which will be used to modify the calculation behavior.
+type CalculateOption func(o *calculateOptions)
+
+// calculateOptions holds the options of the calculation.
+type calculateOptions struct {
+	events      EventRegistry
+	onUnmatched func(event string)
+}
+
+// WithEventRegistry configures the event registry used to calculate the volume of the transactions.
+//
+// If it is not set, DefaultEventRegistry is used.
+func WithEventRegistry(events EventRegistry) CalculateOption {
+	return func(o *calculateOptions) {
+		if events == nil {
+			return
+		}
+
+		o.events = events
+	}
+}
+
+// WithUnmatchedEvents configures the calculation to skip the transactions whose event is not in the event registry
+// instead of failing. The function is called with the event of each skipped transaction.
+func WithUnmatchedEvents(onUnmatched func(event string)) CalculateOption {
+	return func(o *calculateOptions) {
+		o.onUnmatched = onUnmatched
+	}
 }
 
 // Calculate calculates the total volume of the transactions in USD.
 //
 // It receives a channel with the transactions and sends the flatten entities to the output channel.
-func Calculate(ctx context.Context, conversor Conversor, input <-chan entities.Transaction, output chan<- entities.Flatten) error {
+// The volume of each transaction is multiplied by the multiplier of its event in the event registry, and the
+// transactions of the ignored events are skipped.
+//
+// By default, the calculation fails on the first transaction with an unknown event.
+// Use WithUnmatchedEvents to skip them instead.
+func Calculate(ctx context.Context, conversor Conversor, input <-chan entities.Transaction, output chan<- entities.Flatten, opts ...CalculateOption) error {
+	o := calculateOptions{
+		events: DefaultEventRegistry(),
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	for {
 		var (
 			transaction entities.Transaction
@@ -34,25 +84,37 @@ func Calculate(ctx context.Context, conversor Conversor, input <-chan entities.T
 			}
 		}
 
+		rule, ok := o.events[transaction.Event]
+		if !ok {
+			if o.onUnmatched == nil {
+				return fmt.Errorf("unknown event: %s", transaction.Event)
+			}
+
+			o.onUnmatched(transaction.Event)
+
+			continue
+		}
+
+		if rule.Ignore {
+			continue
+		}
+
 		date := transaction.TS.Format("2006-01-02")
 
-		valueUSD, err := conversor.ConvertUSD(ctx, transaction.CurrencyValueDecimal, transaction.CurrencySymbol)
+		valueUSD, err := conversor.ConvertUSD(ctx, transaction.CurrencyValue(), transaction.Currency(), transaction.TS)
 		if err != nil {
 			return err
 		}
 
-		if transaction.Event != "BUY_ITEMS" && transaction.Event != "SELL_ITEMS" {
-			return fmt.Errorf("unknown event: %s", transaction.Event)
-		}
-
-		if transaction.Event == "SELL_ITEMS" {
-			valueUSD *= -1
+		multiplier, ok := rule.rat()
+		if !ok {
+			return fmt.Errorf("invalid multiplier %q of event %s", rule.Multiplier, transaction.Event)
 		}
 
 		output <- entities.Flatten{
 			ProjectID:   transaction.ProjectID,
 			Date:        date,
-			TotalVolume: valueUSD,
+			TotalVolume: new(big.Rat).Mul(valueUSD, multiplier),
 		}
 	}
 }
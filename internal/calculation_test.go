@@ -2,6 +2,7 @@ package internal_test
 
 import (
 	"context"
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/mock"
@@ -28,7 +29,7 @@ func TestCalculate(t *testing.T) {
 		transaction, err := entities.TransactionNormalize(record)
 		require.NoError(t, err)
 
-		conversor.EXPECT().ConvertUSD(mock.Anything, transaction.CurrencyValueDecimal, transaction.CurrencySymbol).Return(1.0, nil)
+		conversor.EXPECT().ConvertUSD(mock.Anything, transaction.CurrencyValue(), transaction.Currency(), transaction.TS).Return(big.NewRat(1, 1), nil)
 
 		transactions = append(transactions, transaction)
 	}
@@ -56,12 +57,89 @@ func TestCalculate(t *testing.T) {
 		require.Equal(t, "2024-04-15", out.Date)
 		require.Equal(t, "4974", out.ProjectID)
 
-		if out.TotalVolume < 0 {
-			require.InEpsilon(t, -1.0, out.TotalVolume, 0)
+		if out.TotalVolume.Sign() < 0 {
+			require.Zero(t, out.TotalVolume.Cmp(big.NewRat(-1, 1)))
 
 			return
 		}
 
-		require.InEpsilon(t, 1.0, out.TotalVolume, 0)
+		require.Zero(t, out.TotalVolume.Cmp(big.NewRat(1, 1)))
 	}
 }
+
+func TestCalculate_event_registry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dataSample, err := internal.LoadSampleData(1, 0)
+	require.NoError(t, err)
+
+	transaction, err := entities.TransactionNormalize(dataSample[0])
+	require.NoError(t, err)
+
+	conversor := mocks.NewConversor(t)
+	conversor.EXPECT().ConvertUSD(mock.Anything, transaction.CurrencyValue(), transaction.Currency(), transaction.TS).Return(big.NewRat(1, 1), nil)
+
+	events := internal.EventRegistry{
+		"BUY_ITEMS":  {Multiplier: "2"},
+		"LIST_ITEMS": {Ignore: true},
+	}
+
+	input := make(chan entities.Transaction, 20)
+
+	for _, event := range []string{"BUY_ITEMS", "LIST_ITEMS", "TRANSFER_ITEMS", "BUY_ITEMS", "TRANSFER_ITEMS"} {
+		tx := transaction
+		tx.Event = event
+
+		input <- tx
+	}
+
+	close(input)
+
+	output := make(chan entities.Flatten, 20)
+
+	unmatched := make(map[string]int)
+
+	err = internal.Calculate(ctx, conversor, input, output,
+		internal.WithEventRegistry(events),
+		internal.WithUnmatchedEvents(func(event string) {
+			unmatched[event]++
+		}),
+	)
+	require.NoError(t, err)
+
+	close(output)
+
+	require.Len(t, output, 2)
+
+	for out := range output {
+		require.Zero(t, out.TotalVolume.Cmp(big.NewRat(2, 1)))
+	}
+
+	require.Equal(t, map[string]int{"TRANSFER_ITEMS": 2}, unmatched)
+}
+
+func TestCalculate_unknown_event(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dataSample, err := internal.LoadSampleData(1, 0)
+	require.NoError(t, err)
+
+	transaction, err := entities.TransactionNormalize(dataSample[0])
+	require.NoError(t, err)
+
+	transaction.Event = "TRANSFER_ITEMS"
+
+	input := make(chan entities.Transaction, 1)
+	input <- transaction
+
+	close(input)
+
+	output := make(chan entities.Flatten, 1)
+
+	err = internal.Calculate(ctx, mocks.NewConversor(t), input, output)
+	require.EqualError(t, err, "unknown event: TRANSFER_ITEMS")
+}
@@ -0,0 +1,352 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+)
+
+// Checkpoint is the state of a checkpointed run, saved into <run-id>/checkpoint.json at each checkpoint of the
+// extraction.
+//
+// The extraction step data of a checkpointed run is saved into parts, one per checkpoint, so the run is resumed from
+// the position of the last checkpoint keeping the parts saved up to it.
+type Checkpoint struct {
+	// RunID is the identifier of the run.
+	RunID string `json:"run_id"`
+	// Step is the step of the pipeline checkpointed.
+	Step string `json:"step"`
+	// Format is the format of the parts of the step data. The resumed run keeps it regardless of the configured format.
+	Format string `json:"format"`
+	// Compression is the codec the parts of the step data are compressed with. The resumed run keeps it regardless of
+	// the configured codec.
+	Compression string `json:"compression"`
+	// Position is the position of the extraction in the input at the last checkpoint.
+	Position ExtractPosition `json:"position"`
+	// Parts is the parts of the step data saved up to the last checkpoint.
+	Parts []StepPart `json:"parts"`
+	// Done is true when the run is completed, and the manifest of the step data is saved.
+	Done bool `json:"done"`
+	// UpdatedAt is the time the checkpoint was saved.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// checkpointFile is the file of the checkpoint of the run, stored along with the step data of the run.
+const checkpointFile = "checkpoint.json"
+
+// stepPartFile returns the file of the part of the step data in the format compressed with the codec.
+func stepPartFile(step Step, part int, format, codec string) string {
+	return stepFile(Step(fmt.Sprintf("%s.part-%05d", step, part)), format, codec)
+}
+
+// checkpointEnabled returns whether the run is checkpointed.
+//
+// Only the extraction step data is checkpointed, so the run is checkpointed when the extraction step data is saved.
+func (p *Pipeline) checkpointEnabled() bool {
+	return p.cfg.CheckpointRows > 0 && p.cfg.ExtractStepEnabled && !p.cfg.CalculateStepEnabled
+}
+
+// startCheckpoint returns the checkpoint the run starts from, nil when the run is not checkpointed.
+//
+// The checkpoint of the resumed run is loaded, otherwise the checkpoint of the new run is saved, so the run can be
+// resumed even when it stops before the first checkpoint.
+func (p *Pipeline) startCheckpoint(ctx context.Context) (*Checkpoint, error) {
+	if !p.checkpointEnabled() {
+		if p.cfg.Resume {
+			return nil, fmt.Errorf("resuming run %s: only the checkpointed extraction step data can be resumed", p.cfg.RunID)
+		}
+
+		return nil, nil //nolint:nilnil
+	}
+
+	if p.cfg.Resume {
+		cp, err := p.loadCheckpoint(ctx, p.cfg.RunID)
+		if err != nil {
+			return nil, err
+		}
+
+		if cp.Done {
+			return nil, fmt.Errorf("run %s is already completed", cp.RunID)
+		}
+
+		return &cp, nil
+	}
+
+	cp := Checkpoint{
+		RunID:       p.cfg.RunID,
+		Step:        extractionStep.String(),
+		Format:      transactionSchema().format(p.cfg.StepFormat),
+		Compression: p.cfg.StepCompression,
+	}
+
+	if err := p.saveCheckpoint(ctx, &cp); err != nil {
+		return nil, err
+	}
+
+	return &cp, nil
+}
+
+// saveCheckpoint saves the checkpoint of the run.
+func (p *Pipeline) saveCheckpoint(ctx context.Context, cp *Checkpoint) error {
+	cp.UpdatedAt = time.Now().UTC()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := p.b.StepProvider().CreateStep(ctx, runFile(cp.RunID, checkpointFile))
+	if err != nil {
+		return fmt.Errorf("creating run %s checkpoint: %w", cp.RunID, err)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err = enc.Encode(cp); err != nil {
+		// Cancel the context to discard the partially written checkpoint.
+		cancel()
+
+		_ = w.Close() //nolint:errcheck
+
+		return fmt.Errorf("encoding run %s checkpoint: %w", cp.RunID, err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("saving run %s checkpoint: %w", cp.RunID, err)
+	}
+
+	return nil
+}
+
+// loadCheckpoint loads the checkpoint of the run.
+func (p *Pipeline) loadCheckpoint(ctx context.Context, runID string) (Checkpoint, error) {
+	r, err := p.openStepFile(ctx, runFile(runID, checkpointFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Checkpoint{}, fmt.Errorf("run %s has no checkpoint: %w", runID, err)
+	}
+
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("opening run %s checkpoint: %w", runID, err)
+	}
+
+	defer r.Close() //nolint:errcheck
+
+	var cp Checkpoint
+
+	if err = json.NewDecoder(r).Decode(&cp); err != nil {
+		return Checkpoint{}, fmt.Errorf("decoding run %s checkpoint: %w", runID, err)
+	}
+
+	return cp, nil
+}
+
+// checkpointRequest is the request to save the checkpoint at the position of the extraction.
+type checkpointRequest struct {
+	pos  ExtractPosition
+	done chan error
+}
+
+// checkpointFunc returns the function, passed to the extraction, which requests the checkpoint at the position of the
+// extraction, and waits until it is saved.
+func checkpointFunc(requests chan<- checkpointRequest) CheckpointFunc {
+	return func(ctx context.Context, pos ExtractPosition) error {
+		req := checkpointRequest{pos: pos, done: make(chan error, 1)}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case requests <- req:
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-req.done:
+			return err
+		}
+	}
+}
+
+// saveCheckpointedStepData saves the extraction step data of the checkpointed run.
+//
+// The transactions are written into a new part of the step data at each checkpoint. When the checkpoint is requested,
+// the transactions sent by the extraction up to the position are written, the part is persisted and the checkpoint is
+// saved along with it. Once the extraction is done, the manifest of the step data is saved listing all the parts, and
+// the run is checkpointed as completed.
+func (p *Pipeline) saveCheckpointedStepData(
+	ctx context.Context,
+	g *errgroup.Group,
+	transactions <-chan entities.Transaction,
+	requests <-chan checkpointRequest,
+	cp *Checkpoint,
+) {
+	g.Go(func() error {
+		s := &checkpointSaver{p: p, cp: cp, schema: transactionSchema()}
+
+		defer s.abort()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case req := <-requests:
+				err := s.checkpoint(ctx, transactions, req.pos)
+
+				req.done <- err
+
+				if err != nil {
+					return err
+				}
+			case t, ok := <-transactions:
+				if !ok {
+					return s.finish(ctx)
+				}
+
+				if err := s.write(ctx, t); err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
+
+// checkpointSaver writes the parts of the step data of the checkpointed run.
+type checkpointSaver struct {
+	p      *Pipeline
+	cp     *Checkpoint
+	schema stepSchema
+
+	// w is the writer of the current part, nil until the first transaction of the part is written.
+	w *stepFileWriter
+	// written is the number of transactions written by the run, the transactions written before resuming excluded.
+	written int
+}
+
+// write writes the transaction into the current part, creating it when needed.
+func (s *checkpointSaver) write(ctx context.Context, t entities.Transaction) error {
+	if s.w == nil {
+		file := stepPartFile(extractionStep, len(s.cp.Parts), s.cp.Format, s.cp.Compression)
+
+		w, err := s.p.createStepFile(ctx, file, s.cp.Format, s.cp.Compression, s.schema)
+		if err != nil {
+			return err
+		}
+
+		s.w = w
+	}
+
+	if err := s.w.Write(t); err != nil {
+		return err
+	}
+
+	s.written++
+
+	return nil
+}
+
+// checkpoint writes the transactions sent up to the position, persists the current part and saves the checkpoint.
+func (s *checkpointSaver) checkpoint(ctx context.Context, transactions <-chan entities.Transaction, pos ExtractPosition) error {
+	for s.written < pos.Transactions {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case t, ok := <-transactions:
+			if !ok {
+				return fmt.Errorf("run %s checkpoint: %d transactions written, %d sent", s.cp.RunID, s.written, pos.Transactions)
+			}
+
+			if err := s.write(ctx, t); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.closePart(); err != nil {
+		return err
+	}
+
+	s.cp.Position = pos
+
+	return s.p.saveCheckpoint(ctx, s.cp)
+}
+
+// closePart persists the current part, if any, and adds it to the parts of the checkpoint.
+func (s *checkpointSaver) closePart() error {
+	if s.w == nil {
+		return nil
+	}
+
+	part, err := s.w.Close()
+
+	s.w = nil
+
+	if err != nil {
+		return err
+	}
+
+	s.cp.Parts = append(s.cp.Parts, part)
+
+	return nil
+}
+
+// finish persists the last part, saves the manifest of the step data and checkpoints the run as completed.
+func (s *checkpointSaver) finish(ctx context.Context) error {
+	if err := s.closePart(); err != nil {
+		return err
+	}
+
+	m := StepManifest{
+		SchemaVersion:   StepSchemaVersion,
+		Step:            s.cp.Step,
+		Format:          s.cp.Format,
+		Compression:     s.cp.Compression,
+		Header:          s.schema.header,
+		Parts:           s.cp.Parts,
+		ProducerVersion: s.p.cfg.ProducerVersion,
+		SourceFile:      s.p.cfg.SourceFile,
+		CreatedAt:       time.Now().UTC(),
+	}
+
+	if len(m.Parts) == 0 {
+		// The step data has no transactions, it is saved as an empty part, so it is loaded as the step data of any
+		// other run.
+		w, err := s.p.createStepFile(ctx, stepPartFile(extractionStep, 0, m.Format, m.Compression),
+			m.Format, m.Compression, s.schema)
+		if err != nil {
+			return err
+		}
+
+		s.w = w
+
+		if err := s.closePart(); err != nil {
+			return err
+		}
+
+		m.Parts = s.cp.Parts
+	}
+
+	for _, part := range m.Parts {
+		m.Rows += part.Rows
+	}
+
+	if err := s.p.saveStepManifest(ctx, m); err != nil {
+		return err
+	}
+
+	s.cp.Done = true
+
+	return s.p.saveCheckpoint(ctx, s.cp)
+}
+
+// abort discards the current part, if any.
+func (s *checkpointSaver) abort() {
+	if s.w != nil {
+		s.w.Abort()
+	}
+}
@@ -0,0 +1,213 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal"
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+	"github.com/dohernandez/horizon-blockchain-games/internal/mocks"
+	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
+)
+
+// extractAll extracts the transactions of the data with the options.
+func extractAll(t *testing.T, data []byte, opts ...internal.ExtractOption) ([]entities.Transaction, error) {
+	t.Helper()
+
+	provider := mocks.NewExtractProvider(t)
+	provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(data)), nil)
+
+	output := make(chan entities.Transaction, 20)
+
+	err := internal.Extract(context.Background(), provider, output, opts...)
+
+	close(output)
+
+	var transactions []entities.Transaction
+
+	for tx := range output {
+		transactions = append(transactions, tx)
+	}
+
+	return transactions, err
+}
+
+func TestExtract_checkpoint(t *testing.T) {
+	t.Parallel()
+
+	// Load sample data with limit 8 to load 7 data lines since offset is -1 which means load the header line.
+	dataSample, err := internal.LoadSampleData(8, -1)
+	require.NoError(t, err)
+
+	data := encodeToBytes(t, dataSample, func(_ *testing.T, record []string) []string {
+		return record
+	})
+
+	var positions []internal.ExtractPosition
+
+	all, err := extractAll(t, data, internal.WithCheckpoint(3, func(_ context.Context, pos internal.ExtractPosition) error {
+		positions = append(positions, pos)
+
+		return nil
+	}))
+	require.NoError(t, err)
+	require.Len(t, all, 7)
+
+	require.Len(t, positions, 2)
+	require.Equal(t, 3, positions[0].Row)
+	require.Equal(t, 3, positions[0].Transactions)
+	require.Equal(t, 6, positions[1].Row)
+	require.Equal(t, 6, positions[1].Transactions)
+
+	// The offset is the end of the last row read.
+	require.Equal(t, int64(len(encodeToBytes(t, dataSample[:7], func(_ *testing.T, record []string) []string {
+		return record
+	}))), positions[1].Offset)
+
+	resumed, err := extractAll(t, data, internal.WithResume(positions[0]))
+	require.NoError(t, err)
+	require.Equal(t, all[3:], resumed)
+
+	// The input differs from the input read up to the checkpoint.
+	pos := positions[1]
+	pos.Offset++
+
+	_, err = extractAll(t, data, internal.WithResume(pos))
+	require.ErrorContains(t, err, "input differs from the input to resume from")
+
+	// The input is shorter than the input read up to the checkpoint.
+	pos = positions[1]
+	pos.Row = 10
+
+	_, err = extractAll(t, data, internal.WithResume(pos))
+	require.ErrorContains(t, err, "input has 7 rows, less than the 10 rows to resume from")
+}
+
+func TestPipeline_Run_resume(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Load sample data with limit 7 to load 6 data lines of the same date and project.
+	dataSample, err := internal.LoadSampleData(7, -1)
+	require.NoError(t, err)
+
+	encode := func(_ *testing.T, record []string) []string {
+		return record
+	}
+
+	data := encodeToBytes(t, dataSample, encode)
+
+	// The first run fails reading the 5th row, after the checkpoint of the 4th row.
+	cut := len(encodeToBytes(t, dataSample[:5], encode)) + 3
+
+	provider := mocks.NewExtractProvider(t)
+	provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(io.MultiReader(
+		bytes.NewReader(data[:cut]),
+		iotest.ErrReader(errors.New("connection reset")),
+	)), nil).Once()
+	provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(data)), nil).Once()
+
+	stepProvider := storage.NewFileSystem(t.TempDir(), "")
+
+	b := mocks.NewPipelineBackend(t)
+	b.EXPECT().ExtractProvider().Return(provider)
+	b.EXPECT().StepProvider().Return(stepProvider)
+
+	cfg := internal.PipelineConfig{
+		RunID:              "run",
+		CheckpointRows:     2,
+		ExtractStepEnabled: true,
+	}
+
+	err = internal.NewPipeline(b, cfg).Run(ctx)
+	require.ErrorContains(t, err, "connection reset")
+
+	// The run is resumed from the checkpoint of the 4th row.
+	cfg.Resume = true
+
+	err = internal.NewPipeline(b, cfg).Run(ctx)
+	require.NoError(t, err)
+
+	mData := readStep(t, stepProvider, "run/extraction.manifest.json")
+
+	var m internal.StepManifest
+
+	require.NoError(t, json.Unmarshal(mData, &m))
+	require.Equal(t, 6, m.Rows)
+	require.Equal(t, []string{"extraction.part-00000", "extraction.part-00001", "extraction.part-00002"},
+		func() []string {
+			var files []string
+
+			for _, part := range m.Parts {
+				files = append(files, part.File)
+			}
+
+			return files
+		}(),
+	)
+
+	// The completed run can not be resumed.
+	err = internal.NewPipeline(b, cfg).Run(ctx)
+	require.ErrorContains(t, err, "run run is already completed")
+
+	// The transactions are loaded from the parts without duplicates.
+	conversor := mocks.NewConversor(t)
+	conversor.EXPECT().ConvertUSD(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(big.NewRat(1, 1), nil).Times(6)
+
+	warehouse := mocks.NewWarehouseProvider(t)
+	warehouse.EXPECT().Save(mock.Anything, matchFlatten(entities.Flatten{
+		Date:        "2024-04-15",
+		ProjectID:   "4974",
+		NumTxs:      6,
+		TotalVolume: big.NewRat(6, 1),
+	})).Return(nil)
+	warehouse.EXPECT().Flush(mock.Anything).Return(nil)
+
+	b = mocks.NewPipelineBackend(t)
+	b.EXPECT().Conversor().Return(conversor)
+	b.EXPECT().WarehouseProvider().Return(warehouse)
+	b.EXPECT().StepProvider().Return(stepProvider)
+
+	err = internal.NewPipeline(b, internal.PipelineConfig{
+		CalculateStepEnabled: true,
+		InsertStepEnabled:    true,
+	}).Run(ctx)
+	require.NoError(t, err)
+}
+
+func TestPipeline_Run_resume_invalid(t *testing.T) {
+	t.Parallel()
+
+	stepProvider := storage.NewFileSystem(t.TempDir(), "")
+
+	b := mocks.NewPipelineBackend(t)
+	b.EXPECT().StepProvider().Return(stepProvider).Maybe()
+
+	err := internal.NewPipeline(b, internal.PipelineConfig{
+		RunID:              "unknown",
+		CheckpointRows:     2,
+		Resume:             true,
+		ExtractStepEnabled: true,
+	}).Run(context.Background())
+	require.ErrorContains(t, err, "run unknown has no checkpoint")
+
+	err = internal.NewPipeline(b, internal.PipelineConfig{
+		RunID:                "unknown",
+		CheckpointRows:       2,
+		Resume:               true,
+		ExtractStepEnabled:   true,
+		CalculateStepEnabled: true,
+	}).Run(context.Background())
+	require.ErrorContains(t, err, "only the checkpointed extraction step data can be resumed")
+}
@@ -0,0 +1,150 @@
+// Package compress provides the compression codecs of the input and step files, detected from their content.
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// None is the codec of the uncompressed files.
+	None = "none"
+	// Gzip is the gzip codec, with the .gz extension.
+	Gzip = "gzip"
+	// Zstd is the Zstandard codec, with the .zst extension.
+	Zstd = "zstd"
+)
+
+var (
+	// gzipMagic is the magic number at the start of the gzip files.
+	gzipMagic = []byte{0x1f, 0x8b}
+	// zstdMagic is the magic number at the start of the Zstandard frames.
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Codecs returns the supported codecs.
+func Codecs() []string {
+	return []string{None, Gzip, Zstd}
+}
+
+// Extension returns the file extension of the codec, including the dot, or an empty string for None.
+func Extension(codec string) string {
+	switch codec {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// Detect returns the codec of the data starting with the given header, None if it is not compressed.
+func Detect(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return Zstd
+	default:
+		return None
+	}
+}
+
+// NewReader returns a reader decompressing the data read from r, with the codec detected from its content.
+//
+// When the data is not compressed, it is read as is. Closing the returned reader closes r.
+func NewReader(r io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("detecting compression: %w", err)
+	}
+
+	switch Detect(header) {
+	case Gzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("creating gzip reader: %w", err)
+		}
+
+		return &reader{Reader: zr, closers: []io.Closer{zr, r}}, nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd reader: %w", err)
+		}
+
+		return &reader{Reader: zr, closers: []io.Closer{zstdCloser{zr}, r}}, nil
+	default:
+		return &reader{Reader: br, closers: []io.Closer{r}}, nil
+	}
+}
+
+// NewWriter returns a writer compressing the data written into w with the codec.
+//
+// Closing the returned writer flushes the compressed data, it does not close w.
+func NewWriter(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd writer: %w", err)
+		}
+
+		return zw, nil
+	case None, "":
+		return nopCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression codec %s", codec)
+	}
+}
+
+// reader is the reader of the decompressed data, closing the decompressor and the underlying reader.
+type reader struct {
+	io.Reader
+
+	closers []io.Closer
+}
+
+// Close closes the decompressor and the underlying reader.
+func (r *reader) Close() error {
+	var errs []error
+
+	for _, c := range r.closers {
+		errs = append(errs, c.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// zstdCloser adapts the zstd.Decoder, whose Close does not return an error, to io.Closer.
+type zstdCloser struct {
+	d *zstd.Decoder
+}
+
+// Close releases the resources of the decoder.
+func (c zstdCloser) Close() error {
+	c.d.Close()
+
+	return nil
+}
+
+// nopCloser is the writer of the uncompressed data.
+type nopCloser struct {
+	io.Writer
+}
+
+// Close does nothing.
+func (nopCloser) Close() error {
+	return nil
+}
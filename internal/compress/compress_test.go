@@ -0,0 +1,57 @@
+package compress_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/compress"
+)
+
+func TestNewReader(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		codec string
+		data  string
+	}{
+		{codec: compress.None, data: "id,date\n1,2024-04-15\n"},
+		{codec: compress.Gzip, data: "id,date\n1,2024-04-15\n"},
+		{codec: compress.Zstd, data: "id,date\n1,2024-04-15\n"},
+		{codec: compress.None, data: ""},
+		{codec: compress.None, data: "i"},
+	} {
+		t.Run(tt.codec+"/"+tt.data, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+
+			w, err := compress.NewWriter(&buf, tt.codec)
+			require.NoError(t, err)
+
+			_, err = w.Write([]byte(tt.data))
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			require.Equal(t, tt.codec, compress.Detect(buf.Bytes()))
+
+			// The codec is detected from the content.
+			r, err := compress.NewReader(io.NopCloser(&buf))
+			require.NoError(t, err)
+
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			require.Equal(t, tt.data, string(data))
+		})
+	}
+}
+
+func TestNewWriter_unknown(t *testing.T) {
+	t.Parallel()
+
+	_, err := compress.NewWriter(io.Discard, "lz4")
+	require.ErrorContains(t, err, "unknown compression codec lz4")
+}
@@ -0,0 +1,286 @@
+package conversor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bool64/ctxd"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+)
+
+// ErrRateNotCached is returned by the Cache in offline mode when the rate is not in the cache.
+var ErrRateNotCached = errors.New("rate not cached")
+
+// Conversor is the conversor wrapped by the Cache.
+type Conversor interface {
+	// ConvertUSD converts the value in the given currency to USD.
+	ConvertUSD(ctx context.Context, value *big.Rat, currency entities.Currency, ts time.Time) (*big.Rat, error)
+}
+
+// CacheStorage is the storage to persist the cached rates, such as storage.FileSystem or storage.GoogleBucket.
+type CacheStorage interface {
+	// OpenStep opens the file to be read as a stream.
+	//
+	// When the file does not exist, the error must wrap fs.ErrNotExist.
+	OpenStep(ctx context.Context, file string) (io.ReadCloser, error)
+	// CreateStep creates the file to be written as a stream.
+	CreateStep(ctx context.Context, file string) (io.WriteCloser, error)
+}
+
+// CacheConfig is the configuration for the Cache.
+type CacheConfig struct {
+	// File is the name of the file in the storage to persist the rates.
+	File string
+	// Source identifies the conversor, and its mode, producing the rates, e.g. coingecko:historical.
+	//
+	// The rates are cached by source, so the rates of a source, such as the current prices of the spot mode, are never
+	// taken as the rates of another source, such as the prices of the day of the historical mode.
+	Source string
+	// TTL is the time a cached rate is valid since it was fetched.
+	//
+	// If it is 0, the cached rates never expire.
+	TTL time.Duration
+	// Offline is to fail when the rate is not cached, instead of requesting it to the wrapped conversor.
+	Offline bool
+}
+
+// cachedRate is a rate persisted in the cache file.
+type cachedRate struct {
+	Rate      string    `json:"rate"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// Cache is a conversor caching the rates of the wrapped conversor, persisted in a storage to be shared across runs.
+//
+// The rates are cached by source, by currency, resolved by its contract address or by its symbol, and by day of the
+// transaction.
+// The rates requested are persisted once by Flush, at the end of the run.
+type Cache struct {
+	cfg CacheConfig
+
+	conversor Conversor
+	storage   CacheStorage
+
+	rates  map[string]cachedRate
+	loaded bool
+	// dirty is true when rates were requested since the cache was persisted.
+	dirty bool
+	sm    sync.RWMutex
+
+	// saveSm serializes the flushes of the cache file.
+	saveSm sync.Mutex
+
+	logger ctxd.Logger
+}
+
+// CacheOption is a convenience type which will be used to modify Cache private fields.
+type CacheOption func(c *Cache)
+
+// WithCacheLogger configures the logger of a Cache.
+func WithCacheLogger(logger ctxd.Logger) CacheOption {
+	return func(c *Cache) {
+		if logger == nil {
+			return
+		}
+
+		c.logger = logger
+	}
+}
+
+// NewCache creates a new Cache wrapping the given conversor, persisting the rates in the given storage.
+func NewCache(conversor Conversor, storage CacheStorage, cfg CacheConfig, opts ...CacheOption) *Cache {
+	c := &Cache{
+		cfg:       cfg,
+		conversor: conversor,
+		storage:   storage,
+		rates:     make(map[string]cachedRate),
+		logger:    ctxd.NoOpLogger{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// ConvertUSD converts the given value in the given currency to USD.
+//
+// The rate is taken from the cache when it is cached and not expired, otherwise it is requested to the wrapped
+// conversor and cached, to be persisted by Flush. In offline mode, the conversion fails when the rate is not cached.
+func (c *Cache) ConvertUSD(ctx context.Context, value *big.Rat, currency entities.Currency, ts time.Time) (*big.Rat, error) {
+	if err := c.load(ctx); err != nil {
+		return nil, err
+	}
+
+	key := cacheKey(c.cfg.Source, currency, ts)
+
+	c.sm.RLock()
+	cached, ok := c.rates[key]
+	c.sm.RUnlock()
+
+	if ok && !c.expired(cached) {
+		rate, err := entities.ParseDecimal(cached.Rate)
+		if err != nil {
+			return nil, fmt.Errorf("cached rate %s: %w", key, err)
+		}
+
+		return rate.Mul(rate, value), nil
+	}
+
+	if c.cfg.Offline {
+		return nil, fmt.Errorf("%w: %s", ErrRateNotCached, key)
+	}
+
+	rate, err := c.conversor.ConvertUSD(ctx, big.NewRat(1, 1), currency, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.sm.Lock()
+	c.rates[key] = cachedRate{
+		Rate:      entities.FormatDecimal(rate),
+		FetchedAt: time.Now().UTC(),
+	}
+	c.dirty = true
+	c.sm.Unlock()
+
+	c.logger.Debug(ctx, "caching rate", "key", key)
+
+	return new(big.Rat).Mul(rate, value), nil
+}
+
+// Flush persists the rates into the storage, replacing the previous cache file, when rates were requested since the
+// cache was persisted.
+func (c *Cache) Flush(ctx context.Context) error {
+	c.saveSm.Lock()
+	defer c.saveSm.Unlock()
+
+	c.sm.Lock()
+	dirty := c.dirty
+	c.dirty = false
+	c.sm.Unlock()
+
+	if !dirty {
+		return nil
+	}
+
+	if err := c.save(ctx); err != nil {
+		// The rates are persisted on the next flush.
+		c.sm.Lock()
+		c.dirty = true
+		c.sm.Unlock()
+
+		return err
+	}
+
+	c.logger.Debug(ctx, "price cache saved", "file", c.cfg.File)
+
+	return nil
+}
+
+// expired checks if the cached rate is older than the TTL.
+func (c *Cache) expired(cached cachedRate) bool {
+	return c.cfg.TTL > 0 && time.Since(cached.FetchedAt) > c.cfg.TTL
+}
+
+// load loads the rates from the storage the first time it is called.
+//
+// A missing cache file is an empty cache.
+func (c *Cache) load(ctx context.Context) error {
+	c.sm.RLock()
+	loaded := c.loaded
+	c.sm.RUnlock()
+
+	if loaded {
+		return nil
+	}
+
+	c.sm.Lock()
+	defer c.sm.Unlock()
+
+	if c.loaded {
+		return nil
+	}
+
+	r, err := c.storage.OpenStep(ctx, c.cfg.File)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.logger.Debug(ctx, "price cache not found, starting empty", "file", c.cfg.File)
+
+		c.loaded = true
+
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("opening price cache: %w", err)
+	}
+
+	defer r.Close() //nolint:errcheck
+
+	if err = json.NewDecoder(r).Decode(&c.rates); err != nil {
+		return fmt.Errorf("decoding price cache: %w", err)
+	}
+
+	c.logger.Debug(ctx, "price cache loaded", "file", c.cfg.File, "rates", len(c.rates))
+
+	c.loaded = true
+
+	return nil
+}
+
+// save persists all the rates into the storage, replacing the previous cache file.
+func (c *Cache) save(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := c.storage.CreateStep(ctx, c.cfg.File)
+	if err != nil {
+		return fmt.Errorf("creating price cache: %w", err)
+	}
+
+	c.sm.RLock()
+	err = json.NewEncoder(w).Encode(c.rates)
+	c.sm.RUnlock()
+
+	if err != nil {
+		// Cancel the context to discard the partially written cache file.
+		cancel()
+
+		_ = w.Close() //nolint:errcheck
+
+		return fmt.Errorf("encoding price cache: %w", err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("saving price cache: %w", err)
+	}
+
+	return nil
+}
+
+// cacheKey returns the key of the rate of the source for the currency on the day of the given timestamp.
+func cacheKey(source string, currency entities.Currency, ts time.Time) string {
+	key := strings.ToLower(currency.Symbol)
+
+	if currency.ChainID != "" && currency.Address != "" {
+		key = fmt.Sprintf("%s:%s", currency.ChainID, strings.ToLower(currency.Address))
+	}
+
+	key = fmt.Sprintf("%s:%s", key, ts.UTC().Format(time.DateOnly))
+
+	if source == "" {
+		return key
+	}
+
+	return fmt.Sprintf("%s:%s", source, key)
+}
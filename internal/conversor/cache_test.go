@@ -0,0 +1,141 @@
+package conversor_test
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/conversor"
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+	"github.com/dohernandez/horizon-blockchain-games/internal/mocks"
+	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
+)
+
+func TestCache_ConvertUSD(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	st := storage.NewFileSystem(dir, "")
+	cfg := conversor.CacheConfig{File: "rates.json"}
+
+	sfl := entities.Currency{Symbol: "SFL", ChainID: "137", Address: "0xD1f9c58e33933a993A3891F8acFe05a68E1afC05"}
+	ts := time.Date(2024, 4, 15, 2, 15, 7, 0, time.UTC)
+
+	// The rate is requested once per currency and day.
+	inner := mocks.NewConversor(t)
+	inner.EXPECT().ConvertUSD(mock.Anything, big.NewRat(1, 1), sfl, ts).Return(big.NewRat(59499, 1000000), nil).Once()
+
+	c := conversor.NewCache(inner, st, cfg)
+
+	got, err := c.ConvertUSD(ctx, big.NewRat(2, 1), sfl, ts)
+	require.NoError(t, err)
+	require.Equal(t, "0.118998", entities.FormatDecimal(got))
+
+	got, err = c.ConvertUSD(ctx, big.NewRat(1, 1), sfl, ts.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, "0.059499", entities.FormatDecimal(got))
+
+	// The rates are persisted once flushed.
+	require.NoFileExists(t, path.Join(dir, "rates.json"))
+
+	require.NoError(t, c.Flush(ctx))
+	require.FileExists(t, path.Join(dir, "rates.json"))
+
+	// The rate is loaded from the cache file in the next run.
+	c = conversor.NewCache(mocks.NewConversor(t), st, conversor.CacheConfig{File: "rates.json", Offline: true})
+
+	got, err = c.ConvertUSD(ctx, big.NewRat(1, 1), sfl, ts)
+	require.NoError(t, err)
+	require.Equal(t, "0.059499", entities.FormatDecimal(got))
+
+	// The rate of another day is not cached.
+	_, err = c.ConvertUSD(ctx, big.NewRat(1, 1), sfl, ts.AddDate(0, 0, 1))
+	require.ErrorIs(t, err, conversor.ErrRateNotCached)
+}
+
+func TestCache_ConvertUSD_expired(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir := t.TempDir()
+
+	err := os.WriteFile(path.Join(dir, "rates.json"), []byte(`{"sfl:2024-04-15":{"rate":"0.05","fetched_at":"2024-04-15T00:00:00Z"}}`), 0o600)
+	require.NoError(t, err)
+
+	sfl := entities.Currency{Symbol: "SFL"}
+	ts := time.Date(2024, 4, 15, 2, 15, 7, 0, time.UTC)
+
+	// The cached rate is used when it does not expire.
+	c := conversor.NewCache(mocks.NewConversor(t), storage.NewFileSystem(dir, ""), conversor.CacheConfig{File: "rates.json"})
+
+	got, err := c.ConvertUSD(ctx, big.NewRat(1, 1), sfl, ts)
+	require.NoError(t, err)
+	require.Equal(t, "0.05", entities.FormatDecimal(got))
+
+	// The expired rate is missing in offline mode.
+	c = conversor.NewCache(
+		mocks.NewConversor(t),
+		storage.NewFileSystem(dir, ""),
+		conversor.CacheConfig{File: "rates.json", TTL: time.Hour, Offline: true},
+	)
+
+	_, err = c.ConvertUSD(ctx, big.NewRat(1, 1), sfl, ts)
+	require.ErrorIs(t, err, conversor.ErrRateNotCached)
+
+	// The expired rate is requested again.
+	inner := mocks.NewConversor(t)
+	inner.EXPECT().ConvertUSD(mock.Anything, big.NewRat(1, 1), sfl, ts).Return(big.NewRat(6, 100), nil).Once()
+
+	c = conversor.NewCache(inner, storage.NewFileSystem(dir, ""), conversor.CacheConfig{File: "rates.json", TTL: time.Hour})
+
+	got, err = c.ConvertUSD(ctx, big.NewRat(1, 1), sfl, ts)
+	require.NoError(t, err)
+	require.Equal(t, "0.06", entities.FormatDecimal(got))
+}
+
+func TestCache_ConvertUSD_source(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	st := storage.NewFileSystem(t.TempDir(), "")
+
+	sfl := entities.Currency{Symbol: "SFL"}
+	ts := time.Date(2024, 4, 15, 2, 15, 7, 0, time.UTC)
+
+	// The current price is cached by the spot mode.
+	inner := mocks.NewConversor(t)
+	inner.EXPECT().ConvertUSD(mock.Anything, big.NewRat(1, 1), sfl, ts).Return(big.NewRat(6, 100), nil).Once()
+
+	spot := conversor.CoinGeckoSource(conversor.CoinGeckoConfig{})
+
+	c := conversor.NewCache(inner, st, conversor.CacheConfig{File: "rates.json", Source: spot})
+
+	_, err := c.ConvertUSD(ctx, big.NewRat(1, 1), sfl, ts)
+	require.NoError(t, err)
+	require.NoError(t, c.Flush(ctx))
+
+	// The current price is not taken as the price of the day by the historical mode.
+	historical := conversor.CoinGeckoSource(conversor.CoinGeckoConfig{Mode: conversor.HistoricalMode})
+
+	c = conversor.NewCache(mocks.NewConversor(t), st, conversor.CacheConfig{File: "rates.json", Source: historical, Offline: true})
+
+	_, err = c.ConvertUSD(ctx, big.NewRat(1, 1), sfl, ts)
+	require.ErrorIs(t, err, conversor.ErrRateNotCached)
+
+	// The spot mode takes it from the cache.
+	c = conversor.NewCache(mocks.NewConversor(t), st, conversor.CacheConfig{File: "rates.json", Source: spot, Offline: true})
+
+	got, err := c.ConvertUSD(ctx, big.NewRat(1, 1), sfl, ts)
+	require.NoError(t, err)
+	require.Equal(t, "0.06", entities.FormatDecimal(got))
+}
@@ -1,16 +1,25 @@
 package conversor
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/big"
+	"math/rand/v2"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/bool64/ctxd"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
 )
 
 // GoinGeckoType is the type of the CoinGecko conversor.
@@ -28,6 +37,38 @@ const (
 	ProBaseURL = "https://pro-api.coingecko.com/api/v3/"
 )
 
+const (
+	// DemoRateLimit is the number of requests per minute allowed by the CoinGecko demo plan.
+	DemoRateLimit = 30
+	// ProRateLimit is the number of requests per minute allowed by the CoinGecko pro (analyst) plan.
+	ProRateLimit = 500
+)
+
+const (
+	// SpotMode is the mode to convert using the current price of the currency.
+	SpotMode = "spot"
+	// HistoricalMode is the mode to convert using the price of the currency on the day of the transaction.
+	HistoricalMode = "historical"
+)
+
+// DefaultPlatforms maps the chain ids to the CoinGecko asset platform ids, used to resolve the currencies by
+// their contract address.
+var DefaultPlatforms = map[string]string{
+	"1":     "ethereum",
+	"10":    "optimistic-ethereum",
+	"56":    "binance-smart-chain",
+	"137":   "polygon-pos",
+	"8453":  "base",
+	"42161": "arbitrum-one",
+	"43114": "avalanche",
+}
+
+// errNotFound is returned when the CoinGecko API does not find the requested resource.
+var errNotFound = errors.New("not found")
+
+// symbolToID maps the currency symbols to the CoinGecko coin ids.
+//
+// It is used as fallback when the currency can not be resolved by its contract address.
 var symbolToID = map[string]string{
 	"sfl":    "sunflower-land",
 	"matic":  "matic-network",
@@ -41,6 +82,41 @@ type CoinGeckoConfig struct {
 	KeyType string
 	Key     string
 	TTL     time.Duration
+
+	// Mode is the mode to get the price of the currency, either SpotMode or HistoricalMode.
+	// If it is empty, SpotMode is used.
+	Mode string
+
+	// Platforms maps the chain ids to the CoinGecko asset platform ids.
+	// If it is nil, DefaultPlatforms is used.
+	Platforms map[string]string
+
+	// Retries is the number of times a request is retried when it fails with a network error, a 429 or a 5xx
+	// status code. If it is 0, the requests are not retried.
+	Retries int
+	// RetryWait is the base wait of the exponential backoff between retries, the wait is randomized (full jitter)
+	// and doubled on every retry. When the response has a Retry-After header, its value is used instead.
+	// If it is 0, it will be set to 1 second.
+	RetryWait time.Duration
+	// MaxRetryWait is the maximum wait between retries.
+	// If it is 0, it will be set to 1 minute.
+	MaxRetryWait time.Duration
+
+	// RateLimit is the number of requests per minute allowed, see DemoRateLimit and ProRateLimit.
+	// If it is 0, the requests are not rate limited.
+	RateLimit int
+}
+
+// CoinGeckoSource returns the source of the rates of the CoinGecko conversor with the configuration, so the rates of
+// each mode are cached apart, see CacheConfig.Source.
+func CoinGeckoSource(cfg CoinGeckoConfig) string {
+	mode := cfg.Mode
+
+	if mode == "" {
+		mode = SpotMode
+	}
+
+	return GoinGeckoType + ":" + mode
 }
 
 // Option is a convenience type which will be used to modify Client private fields.
@@ -74,9 +150,18 @@ type CoinGecko struct {
 
 	transport http.RoundTripper
 
-	mapRates map[string]float64
+	// mapRates caches the rates by currency, or by currency and day in historical mode.
+	//
+	// The cached rates are never modified, they are only used as operands.
+	mapRates map[string]*big.Rat
 	sm       sync.RWMutex
 
+	// group de-duplicates the concurrent requests of the same uncached rate.
+	group singleflight.Group
+
+	// limiter limits the requests to the rate limit of the API plan, nil when not rate limited.
+	limiter *rate.Limiter
+
 	logger ctxd.Logger
 }
 
@@ -85,10 +170,26 @@ func NewCoinGecko(cfg CoinGeckoConfig, opts ...Option) *CoinGecko {
 	c := &CoinGecko{
 		cfg:       cfg,
 		transport: http.DefaultTransport,
-		mapRates:  make(map[string]float64),
+		mapRates:  make(map[string]*big.Rat),
 		logger:    ctxd.NoOpLogger{},
 	}
 
+	if c.cfg.Platforms == nil {
+		c.cfg.Platforms = DefaultPlatforms
+	}
+
+	if c.cfg.RetryWait == 0 {
+		c.cfg.RetryWait = time.Second
+	}
+
+	if c.cfg.MaxRetryWait == 0 {
+		c.cfg.MaxRetryWait = time.Minute
+	}
+
+	if c.cfg.RateLimit > 0 {
+		c.limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(c.cfg.RateLimit)), 1)
+	}
+
 	for _, opt := range opts {
 		opt(c)
 	}
@@ -97,26 +198,315 @@ func NewCoinGecko(cfg CoinGeckoConfig, opts ...Option) *CoinGecko {
 }
 
 // ConvertUSD converts the given value in USD to the given currency.
-func (c *CoinGecko) ConvertUSD(ctx context.Context, valueDecimal float64, symbol string) (float64, error) {
-	symbol = strings.ToLower(symbol)
+//
+// The currency is resolved by its contract address on the platform of its chain. When the chain is not mapped to
+// a platform, the currency has no address or CoinGecko does not know the address, the currency is resolved by its symbol.
+//
+// When the conversor is configured in historical mode, the rate used is the one of the day of the given timestamp,
+// otherwise the current (spot) rate is used and the timestamp is ignored.
+//
+// Concurrent conversions of the same uncached rate share a single request.
+func (c *CoinGecko) ConvertUSD(ctx context.Context, value *big.Rat, currency entities.Currency, ts time.Time) (*big.Rat, error) {
+	symbol := strings.ToLower(currency.Symbol)
+	address := strings.ToLower(currency.Address)
+	platform := c.cfg.Platforms[currency.ChainID]
+
+	key := symbol
+
+	if platform != "" && address != "" {
+		key = fmt.Sprintf("%s:%s", platform, address)
+	}
+
+	if c.cfg.Mode == HistoricalMode {
+		key = fmt.Sprintf("%s:%s", key, ts.UTC().Format(time.DateOnly))
+	}
 
 	c.sm.RLock()
-	if price, ok := c.mapRates[symbol]; ok {
+	if price, ok := c.mapRates[key]; ok {
 		c.sm.RUnlock()
 
-		return valueDecimal * price, nil
+		return new(big.Rat).Mul(value, price), nil
 	}
 	c.sm.RUnlock()
 
-	ctx = ctxd.AddFields(ctx, "symbol", symbol)
+	ctx = ctxd.AddFields(ctx, "symbol", symbol, "chain_id", currency.ChainID, "address", address)
+
+	v, err, shared := c.group.Do(key, func() (any, error) {
+		return c.price(ctx, key, symbol, platform, address, ts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if shared {
+		c.logger.Debug(ctx, "shared price request", "key", key)
+	}
+
+	price := v.(*big.Rat) //nolint:forcetypeassert
+
+	return new(big.Rat).Mul(value, price), nil
+}
+
+// price requests the USD price of the currency and caches it with the given key.
+func (c *CoinGecko) price(ctx context.Context, key, symbol, platform, address string, ts time.Time) (*big.Rat, error) {
+	var (
+		price *big.Rat
+		found bool
+		err   error
+	)
+
+	if platform != "" && address != "" {
+		price, found, err = c.contractPrice(ctx, platform, address, ts)
+		if err != nil {
+			return nil, err
+		}
 
+		if !found {
+			c.logger.Debug(ctx, "currency address not found, falling back to symbol", "platform", platform)
+		}
+	}
+
+	if !found {
+		price, err = c.symbolPrice(ctx, symbol, ts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.sm.Lock()
+	c.mapRates[key] = price
+	c.sm.Unlock()
+
+	c.logger.Debug(ctx, "got price", "price", price.RatString())
+
+	return price, nil
+}
+
+// symbolPrice requests the USD price of the currency with the given symbol.
+func (c *CoinGecko) symbolPrice(ctx context.Context, symbol string, ts time.Time) (*big.Rat, error) {
 	id, ok := symbolToID[symbol]
 	if !ok {
-		return 0, fmt.Errorf("unknown currency: %s", symbol)
+		return nil, fmt.Errorf("unknown currency: %s", symbol)
+	}
+
+	if c.cfg.Mode == HistoricalMode {
+		return c.historicalPrice(ctx, id, ts)
+	}
+
+	return c.spotPrice(ctx, id)
+}
+
+// contractPrice requests the USD price of the currency with the given contract address on the given platform.
+//
+// It returns false when CoinGecko does not know the contract address.
+func (c *CoinGecko) contractPrice(ctx context.Context, platform, address string, ts time.Time) (*big.Rat, bool, error) {
+	var (
+		price *big.Rat
+		err   error
+	)
+
+	if c.cfg.Mode == HistoricalMode {
+		price, err = c.historicalContractPrice(ctx, platform, address, ts)
+	} else {
+		price, err = c.spotContractPrice(ctx, platform, address)
+	}
+
+	if errors.Is(err, errNotFound) {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return price, true, nil
+}
+
+// spotContractPrice requests the current USD price of the given contract address on the given platform.
+func (c *CoinGecko) spotContractPrice(ctx context.Context, platform, address string) (*big.Rat, error) {
+	url := fmt.Sprintf("%s/simple/token_price/%s?contract_addresses=%s&vs_currencies=usd", c.cfg.URL, platform, address)
+
+	var priceJSON map[string]map[string]json.Number
+
+	if err := c.get(ctx, url, &priceJSON); err != nil {
+		return nil, err
+	}
+
+	priceObj, ok := priceJSON[address]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s on %s", errNotFound, address, platform)
+	}
+
+	return parsePrice(priceObj["usd"])
+}
+
+// historicalContractPrice requests the USD price of the given contract address on the given platform on the day of
+// the given timestamp.
+func (c *CoinGecko) historicalContractPrice(ctx context.Context, platform, address string, ts time.Time) (*big.Rat, error) {
+	// The price used is the first one of the day, to be consistent with the price of the coins history.
+	from := ts.UTC().Truncate(24 * time.Hour)
+	to := from.Add(24 * time.Hour)
+
+	url := fmt.Sprintf("%s/coins/%s/contract/%s/market_chart/range?vs_currency=usd&from=%d&to=%d",
+		c.cfg.URL, platform, address, from.Unix(), to.Unix())
+
+	var chartJSON struct {
+		// Prices is the list of [timestamp in milliseconds, price].
+		Prices [][2]json.Number `json:"prices"`
 	}
 
+	if err := c.get(ctx, url, &chartJSON); err != nil {
+		return nil, err
+	}
+
+	if len(chartJSON.Prices) == 0 {
+		return nil, fmt.Errorf("%w: %s on %s on %s", errNotFound, address, platform, from.Format(time.DateOnly))
+	}
+
+	return parsePrice(chartJSON.Prices[0][1])
+}
+
+// spotPrice requests the current USD price of the given coin id.
+func (c *CoinGecko) spotPrice(ctx context.Context, id string) (*big.Rat, error) {
 	url := fmt.Sprintf("%s/simple/price?ids=%s&vs_currencies=usd", c.cfg.URL, id)
 
+	var priceJSON map[string]map[string]json.Number
+
+	if err := c.get(ctx, url, &priceJSON); err != nil {
+		return nil, err
+	}
+
+	priceObj, ok := priceJSON[id]
+	if !ok {
+		return nil, fmt.Errorf("currency not found: %s", id)
+	}
+
+	return parsePrice(priceObj["usd"])
+}
+
+// historicalPrice requests the USD price of the given coin id on the day of the given timestamp.
+func (c *CoinGecko) historicalPrice(ctx context.Context, id string, ts time.Time) (*big.Rat, error) {
+	// CoinGecko expects the date in the format dd-mm-yyyy, and the price returned is the one at 00:00:00 UTC.
+	url := fmt.Sprintf("%s/coins/%s/history?date=%s&localization=false", c.cfg.URL, id, ts.UTC().Format("02-01-2006"))
+
+	var historyJSON struct {
+		MarketData *struct {
+			CurrentPrice map[string]json.Number `json:"current_price"`
+		} `json:"market_data"`
+	}
+
+	if err := c.get(ctx, url, &historyJSON); err != nil {
+		return nil, err
+	}
+
+	if historyJSON.MarketData == nil {
+		return nil, fmt.Errorf("price not found: %s on %s", id, ts.UTC().Format(time.DateOnly))
+	}
+
+	price, ok := historyJSON.MarketData.CurrentPrice["usd"]
+	if !ok {
+		return nil, fmt.Errorf("usd price not found: %s on %s", id, ts.UTC().Format(time.DateOnly))
+	}
+
+	return parsePrice(price)
+}
+
+// parsePrice parses the price as returned by CoinGecko into an exact decimal number, avoiding the rounding of
+// the float64 representation.
+func parsePrice(n json.Number) (*big.Rat, error) {
+	price, ok := new(big.Rat).SetString(n.String())
+	if !ok {
+		return nil, fmt.Errorf("invalid price: %q", n.String())
+	}
+
+	return price, nil
+}
+
+// retryableError is an error of a request that can be retried.
+type retryableError struct {
+	err error
+
+	// retryAfter is the wait requested by the server with the Retry-After header, 0 if not requested.
+	retryAfter time.Duration
+}
+
+// Error implements error.
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// get requests the given url and unmarshal the response body into v.
+//
+// The request is retried with exponential backoff when it fails with a retryable error, up to the configured retries.
+func (c *CoinGecko) get(ctx context.Context, url string, v any) error {
+	for attempt := 0; ; attempt++ {
+		body, err := c.do(ctx, url)
+		if err == nil {
+			c.logger.Debug(ctx, "unmarshaling response body")
+
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+
+			if err = dec.Decode(v); err != nil {
+				return fmt.Errorf("unmarshaling body: %w", err)
+			}
+
+			return nil
+		}
+
+		var rErr *retryableError
+
+		if !errors.As(err, &rErr) || attempt >= c.cfg.Retries {
+			return err
+		}
+
+		wait := c.backoff(attempt, rErr.retryAfter)
+
+		c.logger.Debug(ctx, "retrying request", "url", url, "attempt", attempt+1, "wait", wait, "error", err)
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the wait before the retry of the given attempt.
+//
+// The wait requested by the server is honored, otherwise the wait is a random value between 0 and the exponential
+// backoff of the attempt (full jitter), capped by the max retry wait.
+func (c *CoinGecko) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, c.cfg.MaxRetryWait)
+	}
+
+	wait := c.cfg.MaxRetryWait
+
+	if attempt < 32 {
+		wait = min(c.cfg.RetryWait<<attempt, c.cfg.MaxRetryWait)
+	}
+
+	return time.Duration(rand.Int64N(int64(wait) + 1)) //nolint:gosec
+}
+
+// do requests the given url once and returns the response body.
+func (c *CoinGecko) do(ctx context.Context, url string) ([]byte, error) {
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("waiting rate limit: %w", err)
+		}
+	}
+
 	var (
 		ctxc   = ctx
 		cancel = func() {}
@@ -132,7 +522,7 @@ func (c *CoinGecko) ConvertUSD(ctx context.Context, valueDecimal float64, symbol
 
 	req, err := http.NewRequestWithContext(ctxc, http.MethodGet, url, nil)
 	if err != nil {
-		return 0, fmt.Errorf("creating request: %w", err)
+		return nil, fmt.Errorf("creating request: %w", err)
 	}
 
 	req.Header.Add("Accept", "application/json")
@@ -145,7 +535,14 @@ func (c *CoinGecko) ConvertUSD(ctx context.Context, valueDecimal float64, symbol
 
 	res, err := c.transport.RoundTrip(req)
 	if err != nil {
-		return 0, fmt.Errorf("doing request: %w", err)
+		err = fmt.Errorf("doing request: %w", err)
+
+		// The request is not retried when the caller context is done.
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		return nil, &retryableError{err: err}
 	}
 
 	defer res.Body.Close() //nolint:errcheck
@@ -154,34 +551,42 @@ func (c *CoinGecko) ConvertUSD(ctx context.Context, valueDecimal float64, symbol
 
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return 0, fmt.Errorf("reading body: %w", err)
+		return nil, fmt.Errorf("reading body: %w", err)
 	}
 
-	if res.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, string(body))
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", errNotFound, string(body))
 	}
 
-	c.logger.Debug(ctx, "unmarshaling response body")
-
-	var priceJSON map[string]map[string]float64
-
-	err = json.Unmarshal(body, &priceJSON)
-	if err != nil {
-		return 0, fmt.Errorf("unmarshaling body: %w", err)
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError {
+		return nil, &retryableError{
+			err:        fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, string(body)),
+			retryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
 	}
 
-	priceObj, ok := priceJSON[id]
-	if !ok {
-		return 0, fmt.Errorf("currency not found: %s", symbol)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, string(body))
 	}
 
-	price := priceObj["usd"]
+	return body, nil
+}
 
-	c.sm.Lock()
-	c.mapRates[symbol] = price
-	c.sm.Unlock()
+// parseRetryAfter parses the Retry-After header, either in seconds or as an HTTP date.
+//
+// It returns 0 when the header is empty or invalid.
+func parseRetryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
 
-	c.logger.Debug(ctx, "got price", "price", price)
+	if seconds, err := strconv.Atoi(h); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(h); err == nil {
+		return max(time.Until(t), 0)
+	}
 
-	return valueDecimal * price, nil
+	return 0
 }
@@ -2,13 +2,18 @@ package conversor_test
 
 import (
 	"context"
+	"fmt"
+	"math/big"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/bool64/httpmock"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/dohernandez/horizon-blockchain-games/internal/conversor"
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
 )
 
 func TestCoinGecko_ConvertUSD(t *testing.T) {
@@ -40,8 +45,359 @@ func TestCoinGecko_ConvertUSD(t *testing.T) {
 
 	c := conversor.NewCoinGecko(cfg)
 
-	got, err := c.ConvertUSD(ctx, 1, "sfl")
+	got, err := c.ConvertUSD(ctx, big.NewRat(1, 1), entities.Currency{Symbol: "sfl"}, time.Now())
 	require.NoError(t, err)
 
-	require.InEpsilon(t, 0.059499, got, 0)
+	require.Equal(t, "0.059499", entities.FormatDecimal(got))
+}
+
+func TestCoinGecko_ConvertUSD_historical(t *testing.T) {
+	ctx := context.Background()
+
+	// Prepare server mock.
+	sm, url := httpmock.NewServer()
+	defer sm.Close()
+
+	cfg := conversor.CoinGeckoConfig{
+		URL:     url,
+		KeyType: conversor.DemoKeyType,
+		Key:     "CG-UJ2zviozYVh558KpFDL7vR2m",
+		TTL:     0,
+		Mode:    conversor.HistoricalMode,
+	}
+
+	// Set successful expectations, one per day.
+	sm.Expect(httpmock.Expectation{
+		Method:     http.MethodGet,
+		RequestURI: "/coins/sunflower-land/history?date=15-04-2024&localization=false",
+		RequestHeader: map[string]string{
+			"accept":    "application/json",
+			cfg.KeyType: cfg.Key,
+		},
+		Status:       http.StatusOK,
+		ResponseBody: []byte(`{"id":"sunflower-land","market_data":{"current_price":{"eur":0.056,"usd":0.059499}}}`),
+	})
+	sm.Expect(httpmock.Expectation{
+		Method:     http.MethodGet,
+		RequestURI: "/coins/sunflower-land/history?date=16-04-2024&localization=false",
+		RequestHeader: map[string]string{
+			"accept":    "application/json",
+			cfg.KeyType: cfg.Key,
+		},
+		Status:       http.StatusOK,
+		ResponseBody: []byte(`{"id":"sunflower-land","market_data":{"current_price":{"eur":0.062,"usd":0.065}}}`),
+	})
+
+	c := conversor.NewCoinGecko(cfg)
+
+	got, err := c.ConvertUSD(ctx, big.NewRat(1, 1), entities.Currency{Symbol: "sfl"}, time.Date(2024, 4, 15, 2, 15, 7, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, "0.059499", entities.FormatDecimal(got))
+
+	// Same day, the rate is taken from the cache.
+	got, err = c.ConvertUSD(ctx, big.NewRat(2, 1), entities.Currency{Symbol: "sfl"}, time.Date(2024, 4, 15, 23, 59, 59, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, "0.118998", entities.FormatDecimal(got))
+
+	got, err = c.ConvertUSD(ctx, big.NewRat(1, 1), entities.Currency{Symbol: "sfl"}, time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, "0.065", entities.FormatDecimal(got))
+
+	require.NoError(t, sm.ExpectationsWereMet())
+}
+
+func TestCoinGecko_ConvertUSD_contract_address(t *testing.T) {
+	ctx := context.Background()
+
+	sfl := entities.Currency{
+		Symbol:  "SFL",
+		ChainID: "137",
+		Address: "0xD1f9c58e33933a993A3891F8acFe05a68E1afC05",
+	}
+
+	tests := []struct {
+		name         string
+		mode         string
+		currency     entities.Currency
+		expectations []httpmock.Expectation
+		expected     string
+	}{
+		{
+			name:     "spot by contract address",
+			mode:     conversor.SpotMode,
+			currency: sfl,
+			expectations: []httpmock.Expectation{
+				{
+					Method:       http.MethodGet,
+					RequestURI:   "/simple/token_price/polygon-pos?contract_addresses=0xd1f9c58e33933a993a3891f8acfe05a68e1afc05&vs_currencies=usd",
+					Status:       http.StatusOK,
+					ResponseBody: []byte(`{"0xd1f9c58e33933a993a3891f8acfe05a68e1afc05":{"usd":0.059499}}`),
+				},
+			},
+			expected: "0.059499",
+		},
+		{
+			name:     "spot by symbol when contract address unknown",
+			mode:     conversor.SpotMode,
+			currency: sfl,
+			expectations: []httpmock.Expectation{
+				{
+					Method:       http.MethodGet,
+					RequestURI:   "/simple/token_price/polygon-pos?contract_addresses=0xd1f9c58e33933a993a3891f8acfe05a68e1afc05&vs_currencies=usd",
+					Status:       http.StatusOK,
+					ResponseBody: []byte(`{}`),
+				},
+				{
+					Method:       http.MethodGet,
+					RequestURI:   "/simple/price?ids=sunflower-land&vs_currencies=usd",
+					Status:       http.StatusOK,
+					ResponseBody: []byte(`{"sunflower-land":{"usd":0.06}}`),
+				},
+			},
+			expected: "0.06",
+		},
+		{
+			name:     "spot by symbol when chain not mapped",
+			mode:     conversor.SpotMode,
+			currency: entities.Currency{Symbol: "SFL", ChainID: "999999", Address: sfl.Address},
+			expectations: []httpmock.Expectation{
+				{
+					Method:       http.MethodGet,
+					RequestURI:   "/simple/price?ids=sunflower-land&vs_currencies=usd",
+					Status:       http.StatusOK,
+					ResponseBody: []byte(`{"sunflower-land":{"usd":0.06}}`),
+				},
+			},
+			expected: "0.06",
+		},
+		{
+			name:     "historical by contract address",
+			mode:     conversor.HistoricalMode,
+			currency: sfl,
+			expectations: []httpmock.Expectation{
+				{
+					Method:       http.MethodGet,
+					RequestURI:   "/coins/polygon-pos/contract/0xd1f9c58e33933a993a3891f8acfe05a68e1afc05/market_chart/range?vs_currency=usd&from=1713139200&to=1713225600",
+					Status:       http.StatusOK,
+					ResponseBody: []byte(`{"prices":[[1713139200000,0.059499],[1713142800000,0.061]],"market_caps":[],"total_volumes":[]}`),
+				},
+			},
+			expected: "0.059499",
+		},
+		{
+			name:     "historical by symbol when contract address unknown",
+			mode:     conversor.HistoricalMode,
+			currency: sfl,
+			expectations: []httpmock.Expectation{
+				{
+					Method:       http.MethodGet,
+					RequestURI:   "/coins/polygon-pos/contract/0xd1f9c58e33933a993a3891f8acfe05a68e1afc05/market_chart/range?vs_currency=usd&from=1713139200&to=1713225600",
+					Status:       http.StatusNotFound,
+					ResponseBody: []byte(`{"error":"coin not found"}`),
+				},
+				{
+					Method:       http.MethodGet,
+					RequestURI:   "/coins/sunflower-land/history?date=15-04-2024&localization=false",
+					Status:       http.StatusOK,
+					ResponseBody: []byte(`{"id":"sunflower-land","market_data":{"current_price":{"usd":0.06}}}`),
+				},
+			},
+			expected: "0.06",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Prepare server mock.
+			sm, url := httpmock.NewServer()
+			defer sm.Close()
+
+			for _, exp := range tt.expectations {
+				sm.Expect(exp)
+			}
+
+			c := conversor.NewCoinGecko(conversor.CoinGeckoConfig{
+				URL:     url,
+				KeyType: conversor.DemoKeyType,
+				Key:     "CG-UJ2zviozYVh558KpFDL7vR2m",
+				Mode:    tt.mode,
+			})
+
+			got, err := c.ConvertUSD(ctx, big.NewRat(1, 1), tt.currency, time.Date(2024, 4, 15, 2, 15, 7, 0, time.UTC))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, entities.FormatDecimal(got))
+
+			// The rate is taken from the cache.
+			got, err = c.ConvertUSD(ctx, big.NewRat(1, 1), tt.currency, time.Date(2024, 4, 15, 8, 15, 7, 0, time.UTC))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, entities.FormatDecimal(got))
+
+			require.NoError(t, sm.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCoinGecko_ConvertUSD_retry(t *testing.T) {
+	ctx := context.Background()
+
+	// Prepare server mock.
+	sm, url := httpmock.NewServer()
+	defer sm.Close()
+
+	requestURI := "/simple/price?ids=sunflower-land&vs_currencies=usd"
+
+	// Set rate limited and server error expectations followed by a successful one.
+	sm.Expect(httpmock.Expectation{
+		Method:         http.MethodGet,
+		RequestURI:     requestURI,
+		Status:         http.StatusTooManyRequests,
+		ResponseHeader: map[string]string{"Retry-After": "0"},
+		ResponseBody:   []byte(`{"status":{"error_code":429,"error_message":"You've exceeded the Rate Limit."}}`),
+	})
+	sm.Expect(httpmock.Expectation{
+		Method:       http.MethodGet,
+		RequestURI:   requestURI,
+		Status:       http.StatusServiceUnavailable,
+		ResponseBody: []byte(`{}`),
+	})
+	sm.Expect(httpmock.Expectation{
+		Method:       http.MethodGet,
+		RequestURI:   requestURI,
+		Status:       http.StatusOK,
+		ResponseBody: []byte(`{"sunflower-land":{"usd":0.059499}}`),
+	})
+
+	c := conversor.NewCoinGecko(conversor.CoinGeckoConfig{
+		URL:       url,
+		KeyType:   conversor.DemoKeyType,
+		Key:       "CG-UJ2zviozYVh558KpFDL7vR2m",
+		Mode:      conversor.SpotMode,
+		Retries:   2,
+		RetryWait: time.Millisecond,
+	})
+
+	got, err := c.ConvertUSD(ctx, big.NewRat(1, 1), entities.Currency{Symbol: "sfl"}, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, "0.059499", entities.FormatDecimal(got))
+
+	require.NoError(t, sm.ExpectationsWereMet())
+}
+
+func TestCoinGecko_ConvertUSD_retries_exceeded(t *testing.T) {
+	ctx := context.Background()
+
+	// Prepare server mock.
+	sm, url := httpmock.NewServer()
+	defer sm.Close()
+
+	sm.Expect(httpmock.Expectation{
+		Method:       http.MethodGet,
+		RequestURI:   "/simple/price?ids=sunflower-land&vs_currencies=usd",
+		Status:       http.StatusTooManyRequests,
+		ResponseBody: []byte(`{}`),
+		Repeated:     2,
+	})
+
+	c := conversor.NewCoinGecko(conversor.CoinGeckoConfig{
+		URL:       url,
+		KeyType:   conversor.DemoKeyType,
+		Key:       "CG-UJ2zviozYVh558KpFDL7vR2m",
+		Mode:      conversor.SpotMode,
+		Retries:   1,
+		RetryWait: time.Millisecond,
+	})
+
+	_, err := c.ConvertUSD(ctx, big.NewRat(1, 1), entities.Currency{Symbol: "sfl"}, time.Now())
+	require.EqualError(t, err, "unexpected status code: 429, body: {}")
+
+	require.NoError(t, sm.ExpectationsWereMet())
+}
+
+func TestCoinGecko_ConvertUSD_concurrent(t *testing.T) {
+	ctx := context.Background()
+
+	// Prepare server mock.
+	sm, url := httpmock.NewServer()
+	defer sm.Close()
+
+	// Delay the response, so all the conversions are waiting for the same request.
+	sm.OnRequest = func(_ http.ResponseWriter, _ *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// The expectation is only used once, a second request would fail.
+	sm.Expect(httpmock.Expectation{
+		Method:       http.MethodGet,
+		RequestURI:   "/simple/price?ids=sunflower-land&vs_currencies=usd",
+		Status:       http.StatusOK,
+		ResponseBody: []byte(`{"sunflower-land":{"usd":0.059499}}`),
+	})
+
+	c := conversor.NewCoinGecko(conversor.CoinGeckoConfig{
+		URL:     url,
+		KeyType: conversor.DemoKeyType,
+		Key:     "CG-UJ2zviozYVh558KpFDL7vR2m",
+		Mode:    conversor.SpotMode,
+	})
+
+	var g errgroup.Group
+
+	for range 10 {
+		g.Go(func() error {
+			got, err := c.ConvertUSD(ctx, big.NewRat(1, 1), entities.Currency{Symbol: "sfl"}, time.Now())
+			if err != nil {
+				return err
+			}
+
+			if entities.FormatDecimal(got) != "0.059499" {
+				return fmt.Errorf("unexpected value: %s", entities.FormatDecimal(got))
+			}
+
+			return nil
+		})
+	}
+
+	require.NoError(t, g.Wait())
+	require.NoError(t, sm.ExpectationsWereMet())
+}
+
+func TestCoinGecko_ConvertUSD_rate_limit(t *testing.T) {
+	ctx := context.Background()
+
+	// Prepare server mock.
+	sm, url := httpmock.NewServer()
+	defer sm.Close()
+
+	sm.Expect(httpmock.Expectation{
+		Method:       http.MethodGet,
+		RequestURI:   "/simple/price?ids=sunflower-land&vs_currencies=usd",
+		Status:       http.StatusOK,
+		ResponseBody: []byte(`{"sunflower-land":{"usd":0.059499}}`),
+	})
+	sm.Expect(httpmock.Expectation{
+		Method:       http.MethodGet,
+		RequestURI:   "/simple/price?ids=matic-network&vs_currencies=usd",
+		Status:       http.StatusOK,
+		ResponseBody: []byte(`{"matic-network":{"usd":0.3264}}`),
+	})
+
+	// 600 requests per minute, one request every 100ms.
+	c := conversor.NewCoinGecko(conversor.CoinGeckoConfig{
+		URL:       url,
+		KeyType:   conversor.DemoKeyType,
+		Key:       "CG-UJ2zviozYVh558KpFDL7vR2m",
+		Mode:      conversor.SpotMode,
+		RateLimit: 600,
+	})
+
+	start := time.Now()
+
+	_, err := c.ConvertUSD(ctx, big.NewRat(1, 1), entities.Currency{Symbol: "sfl"}, time.Now())
+	require.NoError(t, err)
+
+	_, err = c.ConvertUSD(ctx, big.NewRat(1, 1), entities.Currency{Symbol: "matic"}, time.Now())
+	require.NoError(t, err)
+
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	require.NoError(t, sm.ExpectationsWereMet())
 }
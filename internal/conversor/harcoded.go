@@ -3,7 +3,11 @@ package conversor
 import (
 	"context"
 	"fmt"
+	"math/big"
 	"strings"
+	"time"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
 )
 
 // HardcodedType is the type of the Hardcoded conversor.
@@ -13,29 +17,32 @@ const HardcodedType = "hardcoded"
 //
 // It contains a map of exchange rates for some currencies.
 type Hardcoded struct {
-	exchangeRate map[string]float64
+	exchangeRate map[string]*big.Rat
 }
 
 // NewHardcoded creates a new hardcoded conversor.
 func NewHardcoded() *Hardcoded {
 	return &Hardcoded{
-		exchangeRate: map[string]float64{
-			"SFL":    0.05649,
-			"MATIC":  0.3264,
-			"USDC":   1,
-			"USDC.E": 1,
+		exchangeRate: map[string]*big.Rat{
+			"SFL":    big.NewRat(5649, 100000),
+			"MATIC":  big.NewRat(3264, 10000),
+			"USDC":   big.NewRat(1, 1),
+			"USDC.E": big.NewRat(1, 1),
 		},
 	}
 }
 
 // ConvertUSD converts a value from a currency to USD based on the hardcoded exchange rates.
-func (c *Hardcoded) ConvertUSD(_ context.Context, valueDecimal float64, symbol string) (float64, error) {
-	upper := strings.ToUpper(symbol)
+//
+// The hardcoded exchange rates are by symbol and do not change over time, therefore the chain, the address and
+// the timestamp are ignored.
+func (c *Hardcoded) ConvertUSD(_ context.Context, value *big.Rat, currency entities.Currency, _ time.Time) (*big.Rat, error) {
+	upper := strings.ToUpper(currency.Symbol)
 
 	rate, ok := c.exchangeRate[upper]
 	if !ok {
-		return 0, fmt.Errorf("unknown currency: %s", symbol)
+		return nil, fmt.Errorf("unknown currency: %s", currency.Symbol)
 	}
 
-	return valueDecimal * rate, nil
+	return new(big.Rat).Mul(value, rate), nil
 }
@@ -2,11 +2,14 @@ package conversor_test
 
 import (
 	"context"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/dohernandez/horizon-blockchain-games/internal/conversor"
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
 )
 
 func TestHardcoded_ConvertUSD(t *testing.T) {
@@ -14,12 +17,12 @@ func TestHardcoded_ConvertUSD(t *testing.T) {
 
 	ctx := context.Background()
 
-	valueDecimal := 1.0
-	symbol := "SFL"
+	value := big.NewRat(1, 1)
+	currency := entities.Currency{Symbol: "SFL"}
 
 	c := conversor.NewHardcoded()
 
-	got, err := c.ConvertUSD(ctx, valueDecimal, symbol)
+	got, err := c.ConvertUSD(ctx, value, currency, time.Now())
 	require.NoError(t, err)
-	require.InEpsilon(t, 0.05649, got, 0)
+	require.Equal(t, "0.05649", entities.FormatDecimal(got))
 }
@@ -0,0 +1,70 @@
+package entities
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// DecimalPrecision is the number of decimals used to format the numbers without a finite decimal representation.
+//
+// It matches the scale of the BigQuery BIGNUMERIC type.
+const DecimalPrecision = 38
+
+// FormatDecimal formats the number as a decimal string.
+//
+// The number is formatted exactly when it has a finite decimal representation, otherwise it is rounded to
+// DecimalPrecision decimals. A nil number is formatted as 0, the zero value of the entities.
+func FormatDecimal(r *big.Rat) string {
+	if r == nil {
+		return "0"
+	}
+
+	if r.IsInt() {
+		return r.Num().String()
+	}
+
+	// A number has a finite decimal representation when the prime factors of its denominator are only 2 and 5,
+	// being the number of decimals the greatest of their exponents.
+	d := new(big.Int).Set(r.Denom())
+
+	n2 := removeFactor(d, 2)
+	n5 := removeFactor(d, 5)
+
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return strings.TrimRight(r.FloatString(DecimalPrecision), "0")
+	}
+
+	return r.FloatString(max(n2, n5))
+}
+
+// removeFactor divides d by the factor as many times as possible, returning the number of times.
+func removeFactor(d *big.Int, factor int64) int {
+	var (
+		n int
+		f = big.NewInt(factor)
+		q = new(big.Int)
+		m = new(big.Int)
+	)
+
+	for {
+		q.QuoRem(d, f, m)
+
+		if m.Sign() != 0 {
+			return n
+		}
+
+		d.Set(q)
+		n++
+	}
+}
+
+// ParseDecimal parses the decimal string into a number.
+func ParseDecimal(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal: %s", s)
+	}
+
+	return r, nil
+}
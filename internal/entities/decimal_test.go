@@ -0,0 +1,46 @@
+package entities_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+)
+
+func TestFormatDecimal(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		value    *big.Rat
+		expected string
+	}{
+		{name: "integer", value: big.NewRat(3, 1), expected: "3"},
+		{name: "negative integer", value: big.NewRat(-10, 1), expected: "-10"},
+		{name: "finite decimal", value: big.NewRat(6136203411678249, 10000000000000000), expected: "0.6136203411678249"},
+		{name: "finite binary", value: big.NewRat(-3, 8), expected: "-0.375"},
+		{name: "infinite decimal", value: big.NewRat(1, 3), expected: "0.33333333333333333333333333333333333333"},
+		{name: "nil", expected: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expected, entities.FormatDecimal(tt.value))
+		})
+	}
+}
+
+func TestParseDecimal(t *testing.T) {
+	t.Parallel()
+
+	r, err := entities.ParseDecimal("0.6136203411678249")
+	require.NoError(t, err)
+	require.Equal(t, "6136203411678249/10000000000000000", r.RatString())
+
+	_, err = entities.ParseDecimal("not a number")
+	require.EqualError(t, err, "invalid decimal: not a number")
+}
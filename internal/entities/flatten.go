@@ -2,15 +2,29 @@ package entities
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
 )
 
 // Flatten represents a flattened transaction entity.
 type Flatten struct {
-	Date        string  `bigquery:"date"`
-	ProjectID   string  `bigquery:"project_id"`
-	NumTxs      int     `bigquery:"num_transactions"`
-	TotalVolume float64 `bigquery:"total_volume_usd"`
+	Date        string   `bigquery:"date"`
+	ProjectID   string   `bigquery:"project_id"`
+	NumTxs      int      `bigquery:"num_transactions"`
+	TotalVolume *big.Rat `bigquery:"total_volume_usd"`
+}
+
+// flattenFieldNum is the number of fields encoded by Flatten.Encode.
+const flattenFieldNum = 4
+
+// FlattenHeader returns the names of the fields encoded by Flatten.Encode, in the same order.
+func FlattenHeader() []string {
+	return []string{
+		"date",
+		"project_id",
+		"num_transactions",
+		"total_volume_usd",
+	}
 }
 
 // Encode encodes the flatten entity into a slice of strings.
@@ -19,12 +33,16 @@ func (f Flatten) Encode() []string {
 		f.Date,
 		f.ProjectID,
 		fmt.Sprintf("%d", f.NumTxs),
-		strconv.FormatFloat(f.TotalVolume, 'g', -1, 64),
+		FormatDecimal(f.TotalVolume),
 	}
 }
 
 // Decode decodes the flatten entity from a slice of strings.
 func (f *Flatten) Decode(d []string) error {
+	if len(d) < flattenFieldNum {
+		return fmt.Errorf("not enough fields in flatten: %d", len(d))
+	}
+
 	f.Date = d[0]
 	f.ProjectID = d[1]
 
@@ -36,8 +54,8 @@ func (f *Flatten) Decode(d []string) error {
 		return fmt.Errorf("parsing num txs")
 	}
 
-	// Convert string to float64.
-	f.TotalVolume, err = strconv.ParseFloat(d[3], 64)
+	// Convert string to decimal.
+	f.TotalVolume, err = ParseDecimal(d[3])
 	if err != nil {
 		return fmt.Errorf("parsing total volume")
 	}
@@ -1,6 +1,7 @@
 package entities
 
 import (
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -14,7 +15,7 @@ func TestFlatten_Encode(t *testing.T) {
 		Date:        "2024-04-15",
 		ProjectID:   "4974",
 		NumTxs:      5,
-		TotalVolume: 0.6136203411678249,
+		TotalVolume: big.NewRat(6136203411678249, 10000000000000000),
 	}
 
 	// Encode the flatten entity.
@@ -29,6 +30,13 @@ func TestFlatten_Encode(t *testing.T) {
 	}, encoded)
 }
 
+func TestFlatten_Encode_zero(t *testing.T) {
+	t.Parallel()
+
+	// The zero value has no total volume.
+	require.Equal(t, []string{"", "", "0", "0"}, Flatten{}.Encode())
+}
+
 func TestFlatten_Decode(t *testing.T) {
 	t.Parallel()
 
@@ -50,5 +58,22 @@ func TestFlatten_Decode(t *testing.T) {
 	require.Equal(t, "2024-04-15", f.Date)
 	require.Equal(t, "4974", f.ProjectID)
 	require.Equal(t, 5, f.NumTxs)
-	require.InEpsilon(t, 0.6136203411678249, f.TotalVolume, 0)
+	require.Equal(t, "0.6136203411678249", f.TotalVolume.FloatString(16))
+}
+
+func TestFlatten_Decode_not_enough_fields(t *testing.T) {
+	t.Parallel()
+
+	var f Flatten
+
+	err := f.Decode([]string{"2024-04-15", "4974"})
+	require.EqualError(t, err, "not enough fields in flatten: 2")
+}
+
+func TestFlattenHeader(t *testing.T) {
+	t.Parallel()
+
+	f := Flatten{TotalVolume: big.NewRat(1, 1)}
+
+	require.Len(t, FlattenHeader(), len(f.Encode()))
 }
@@ -0,0 +1,100 @@
+package entities
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// TransactionRecord is the typed record of the transaction entity, written into the JSON Lines, gob and Parquet files.
+//
+// The raw value is written as an integer string, empty when it is not available, to keep its precision.
+type TransactionRecord struct {
+	TS                   time.Time `json:"ts"                     parquet:"ts,timestamp(millisecond)"`
+	Event                string    `json:"event"                  parquet:"event"`
+	ProjectID            string    `json:"project_id"             parquet:"project_id"`
+	CurrencySymbol       string    `json:"currency_symbol"        parquet:"currency_symbol"`
+	CurrencyValueDecimal float64   `json:"currency_value_decimal" parquet:"currency_value_decimal"`
+	ChainID              string    `json:"chain_id"               parquet:"chain_id"`
+	CurrencyAddress      string    `json:"currency_address"       parquet:"currency_address"`
+	CurrencyValueRaw     string    `json:"currency_value_raw"     parquet:"currency_value_raw"`
+	CurrencyDecimals     int64     `json:"currency_decimals"      parquet:"currency_decimals"`
+}
+
+// Record returns the typed record of the transaction entity.
+func (t Transaction) Record() TransactionRecord {
+	return TransactionRecord{
+		TS:                   t.TS,
+		Event:                t.Event,
+		ProjectID:            t.ProjectID,
+		CurrencySymbol:       t.CurrencySymbol,
+		CurrencyValueDecimal: t.CurrencyValueDecimal,
+		ChainID:              t.ChainID,
+		CurrencyAddress:      t.CurrencyAddress,
+		CurrencyValueRaw:     rawString(t.CurrencyValueRaw),
+		CurrencyDecimals:     int64(t.CurrencyDecimals),
+	}
+}
+
+// FromRecord sets the transaction entity from its typed record.
+func (t *Transaction) FromRecord(r TransactionRecord) error {
+	t.TS = r.TS.UTC()
+	t.Event = r.Event
+	t.ProjectID = r.ProjectID
+	t.CurrencySymbol = r.CurrencySymbol
+	t.CurrencyValueDecimal = r.CurrencyValueDecimal
+	t.ChainID = r.ChainID
+	t.CurrencyAddress = r.CurrencyAddress
+	t.CurrencyValueRaw = nil
+	t.CurrencyDecimals = 0
+
+	if r.CurrencyValueRaw != "" {
+		raw, ok := new(big.Int).SetString(r.CurrencyValueRaw, 10)
+		if !ok {
+			return fmt.Errorf("parsing currency value raw")
+		}
+
+		t.CurrencyValueRaw = raw
+		t.CurrencyDecimals = int(r.CurrencyDecimals)
+	}
+
+	return nil
+}
+
+// FlattenRecord is the typed record of the flatten entity, written into the JSON Lines, gob and Parquet files.
+//
+// The total volume is written as a decimal string in Parquet and gob, and as a number in JSON, to keep its precision.
+type FlattenRecord struct {
+	Date        string      `json:"date"             parquet:"date"`
+	ProjectID   string      `json:"project_id"       parquet:"project_id"`
+	NumTxs      int64       `json:"num_transactions" parquet:"num_transactions"`
+	TotalVolume json.Number `json:"total_volume_usd" parquet:"total_volume_usd"`
+}
+
+// Record returns the typed record of the flatten entity.
+func (f Flatten) Record() FlattenRecord {
+	return FlattenRecord{
+		Date:        f.Date,
+		ProjectID:   f.ProjectID,
+		NumTxs:      int64(f.NumTxs),
+		TotalVolume: json.Number(FormatDecimal(f.TotalVolume)),
+	}
+}
+
+// FromRecord sets the flatten entity from its typed record.
+func (f *Flatten) FromRecord(r FlattenRecord) error {
+	f.Date = r.Date
+	f.ProjectID = r.ProjectID
+	f.NumTxs = int(r.NumTxs)
+
+	var err error
+
+	// Convert string to decimal.
+	f.TotalVolume, err = ParseDecimal(r.TotalVolume.String())
+	if err != nil {
+		return fmt.Errorf("parsing total volume")
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package entities_test
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+)
+
+func TestTransaction_Record(t *testing.T) {
+	t.Parallel()
+
+	for _, tx := range []entities.Transaction{
+		{
+			TS:                   time.Date(2024, 4, 15, 2, 15, 7, 167000000, time.UTC),
+			Event:                "BUY_ITEMS",
+			ProjectID:            "4974",
+			CurrencySymbol:       "USDC",
+			CurrencyValueDecimal: 1.5,
+			ChainID:              "137",
+			CurrencyAddress:      "0x3c499c542cef5e3811e1192ce70d8cc03d5c3359",
+			CurrencyValueRaw:     big.NewInt(1500000),
+			CurrencyDecimals:     6,
+		},
+		{
+			TS:                   time.Date(2024, 4, 15, 2, 15, 7, 167000000, time.UTC),
+			Event:                "BUY_ITEMS",
+			ProjectID:            "4974",
+			CurrencySymbol:       "SFL",
+			CurrencyValueDecimal: 0.25,
+		},
+	} {
+		t.Run(tx.CurrencySymbol, func(t *testing.T) {
+			t.Parallel()
+
+			var decoded entities.Transaction
+
+			require.NoError(t, decoded.FromRecord(tx.Record()))
+			require.Equal(t, tx, decoded)
+		})
+	}
+}
+
+func TestTransaction_FromRecord_invalid_raw(t *testing.T) {
+	t.Parallel()
+
+	var tx entities.Transaction
+
+	err := tx.FromRecord(entities.TransactionRecord{CurrencyValueRaw: "1.5"})
+	require.EqualError(t, err, "parsing currency value raw")
+}
+
+func TestFlatten_Record(t *testing.T) {
+	t.Parallel()
+
+	f := entities.Flatten{
+		Date:        "2024-04-15",
+		ProjectID:   "4974",
+		NumTxs:      5,
+		TotalVolume: big.NewRat(6136203411678249, 10000000000000000),
+	}
+
+	r := f.Record()
+	require.Equal(t, "0.6136203411678249", r.TotalVolume.String())
+
+	var decoded entities.Flatten
+
+	require.NoError(t, decoded.FromRecord(r))
+	require.Equal(t, f, decoded)
+}
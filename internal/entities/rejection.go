@@ -0,0 +1,53 @@
+package entities
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+)
+
+// Rejection represents an input row rejected during the extraction because it is malformed.
+type Rejection struct {
+	// File is the file of the input, when the extraction reads several files.
+	File string
+	// Line is the line number of the row in the input.
+	Line int
+	// Reason is the error why the row was rejected.
+	Reason string
+	// Record is the raw row as it was read from the input.
+	Record []string
+}
+
+// RejectionHeader returns the names of the fields encoded by Rejection.Encode, in the same order.
+func RejectionHeader() []string {
+	return []string{"line", "reason", "record"}
+}
+
+// Encode encodes the rejection entity into a slice of strings.
+//
+// The line number, prefixed with the file as <file>:<line> when it is set, and the reason are followed by the raw row
+// encoded as a single CSV line, so the rejections have the same number of fields regardless of the raw row.
+func (r Rejection) Encode() []string {
+	line := strconv.Itoa(r.Line)
+
+	if r.File != "" {
+		line = r.File + ":" + line
+	}
+
+	return []string{line, r.Reason, encodeRecord(r.Record)}
+}
+
+// encodeRecord encodes the fields of the raw row as a CSV line, without the trailing new line.
+func encodeRecord(record []string) string {
+	var buf bytes.Buffer
+
+	w := csv.NewWriter(&buf)
+
+	// Writing into a bytes.Buffer never fails.
+	_ = w.Write(record) //nolint:errcheck
+
+	w.Flush()
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
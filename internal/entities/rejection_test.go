@@ -0,0 +1,47 @@
+package entities_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+)
+
+func TestRejection_Encode(t *testing.T) {
+	t.Parallel()
+
+	// Mock the rejection.
+	r := entities.Rejection{
+		Line:   3,
+		Reason: "parsing time: invalid",
+		Record: []string{"seq-market", "invalid", "BUY_ITEMS"},
+	}
+
+	// Encode the rejection.
+	encoded := r.Encode()
+
+	// Check the encoded record.
+	require.Equal(t, []string{
+		"3",
+		"parsing time: invalid",
+		"seq-market,invalid,BUY_ITEMS",
+	}, encoded)
+}
+
+func TestRejection_Encode_file(t *testing.T) {
+	t.Parallel()
+
+	r := entities.Rejection{
+		File:   "events/2024-04-15/00.csv",
+		Line:   3,
+		Reason: "parsing time: invalid",
+		Record: []string{"seq-market", `{"currencySymbol":"SFL","chainId":"137"}`},
+	}
+
+	require.Equal(t, []string{
+		"events/2024-04-15/00.csv:3",
+		"parsing time: invalid",
+		`seq-market,"{""currencySymbol"":""SFL"",""chainId"":""137""}"`,
+	}, r.Encode())
+}
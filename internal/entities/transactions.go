@@ -3,6 +3,7 @@ package entities
 import (
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"strconv"
 	"time"
 )
@@ -21,6 +22,48 @@ type Transaction struct {
 	ProjectID            string
 	CurrencySymbol       string
 	CurrencyValueDecimal float64
+	ChainID              string
+	CurrencyAddress      string
+	// CurrencyValueRaw is the value in the smallest unit of the currency, as it is on-chain.
+	// It is nil when the raw value is not available.
+	CurrencyValueRaw *big.Int
+	// CurrencyDecimals is the number of decimals of the currency, used to get the value from the raw value.
+	CurrencyDecimals int
+}
+
+// Currency identifies the currency of a transaction.
+//
+// The symbol is ambiguous, more than one currency can have the same symbol, while the chain id and the address of
+// the currency contract identify it unequivocally.
+type Currency struct {
+	Symbol  string
+	ChainID string
+	Address string
+}
+
+// Currency returns the currency of the transaction.
+func (t Transaction) Currency() Currency {
+	return Currency{
+		Symbol:  t.CurrencySymbol,
+		ChainID: t.ChainID,
+		Address: t.CurrencyAddress,
+	}
+}
+
+// CurrencyValue returns the exact value of the transaction in its currency.
+//
+// The value is calculated from the raw value and the decimals of the currency. When the raw value is not available,
+// the decimal value is used instead.
+func (t Transaction) CurrencyValue() *big.Rat {
+	if t.CurrencyValueRaw == nil {
+		r, _ := new(big.Rat).SetString(strconv.FormatFloat(t.CurrencyValueDecimal, 'g', -1, 64)) //nolint:errcheck
+
+		return r
+	}
+
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(t.CurrencyDecimals)), nil)
+
+	return new(big.Rat).SetFrac(t.CurrencyValueRaw, unit)
 }
 
 // TransactionNormalize normalizes the input data into a transaction entity.
@@ -43,9 +86,11 @@ func TransactionNormalize(d []string) (Transaction, error) {
 	t.Event = d[2]
 	t.ProjectID = d[3]
 
-	// Parse currency symbol.
+	// Parse currency symbol, chain id and currency address.
 	type propsJSON struct {
-		CurrencySymbol string `json:"currencySymbol"`
+		CurrencySymbol  string `json:"currencySymbol"`
+		ChainID         string `json:"chainId"`
+		CurrencyAddress string `json:"currencyAddress"`
 	}
 
 	var props propsJSON
@@ -55,10 +100,13 @@ func TransactionNormalize(d []string) (Transaction, error) {
 	}
 
 	t.CurrencySymbol = props.CurrencySymbol
+	t.ChainID = props.ChainID
+	t.CurrencyAddress = props.CurrencyAddress
 
-	// Parse currency value decimal.
+	// Parse currency value decimal and raw.
 	type valueJSON struct {
 		CurrencyValueDecimal string `json:"currencyValueDecimal"`
+		CurrencyValueRaw     string `json:"currencyValueRaw"`
 	}
 
 	var value valueJSON
@@ -73,9 +121,87 @@ func TransactionNormalize(d []string) (Transaction, error) {
 		return t, fmt.Errorf("parsing currency value decimal")
 	}
 
+	if value.CurrencyValueRaw == "" {
+		return t, nil
+	}
+
+	raw, ok := new(big.Int).SetString(value.CurrencyValueRaw, 10)
+	if !ok {
+		return t, fmt.Errorf("parsing currency value raw")
+	}
+
+	t.CurrencyValueRaw = raw
+
+	t.CurrencyDecimals, err = currencyDecimals(raw, value.CurrencyValueDecimal)
+	if err != nil {
+		return t, err
+	}
+
 	return t, nil
 }
 
+// maxCurrencyDecimals is the max number of decimals of a currency.
+const maxCurrencyDecimals = 36
+
+// currencyDecimalsTolerance is the relative difference tolerated between the raw value and the decimal value scaled by
+// the number of decimals, as both are formatted from floating point numbers upstream.
+var currencyDecimalsTolerance = big.NewRat(1, 1e9)
+
+// currencyDecimals infers the number of decimals of the currency from the raw value and the decimal value,
+// being raw = decimal * 10^decimals.
+//
+// The number of decimals is computed with exact arithmetic, it is the power of ten the ratio between the raw value and
+// the decimal value is equal to, within currencyDecimalsTolerance.
+func currencyDecimals(raw *big.Int, decimal string) (int, error) {
+	d, ok := new(big.Rat).SetString(decimal)
+	if !ok {
+		return 0, fmt.Errorf("parsing currency value decimal")
+	}
+
+	// Zero value does not tell the number of decimals, and it is not needed to get the value.
+	if raw.Sign() == 0 {
+		return 0, nil
+	}
+
+	if d.Sign() == 0 {
+		return 0, fmt.Errorf("inconsistent currency value raw %s and decimal %s", raw, decimal)
+	}
+
+	ratio := new(big.Rat).Quo(new(big.Rat).SetInt(raw), d)
+	one := big.NewRat(1, 1)
+	scale := big.NewRat(1, 1)
+	ten := big.NewRat(10, 1)
+
+	for decimals := 0; decimals <= maxCurrencyDecimals; decimals++ {
+		// diff is the relative difference |ratio / 10^decimals - 1|.
+		diff := new(big.Rat).Quo(ratio, scale)
+		diff.Abs(diff.Sub(diff, one))
+
+		if diff.Cmp(currencyDecimalsTolerance) <= 0 {
+			return decimals, nil
+		}
+
+		scale.Mul(scale, ten)
+	}
+
+	return 0, fmt.Errorf("inconsistent currency value raw %s and decimal %s", raw, decimal)
+}
+
+// TransactionHeader returns the names of the fields encoded by Transaction.Encode, in the same order.
+func TransactionHeader() []string {
+	return []string{
+		"ts",
+		"event",
+		"project_id",
+		"currency_symbol",
+		"currency_value_decimal",
+		"chain_id",
+		"currency_address",
+		"currency_value_raw",
+		"currency_decimals",
+	}
+}
+
 // Encode encodes the transaction entity into a slice of strings.
 func (t Transaction) Encode() []string {
 	return []string{
@@ -84,11 +210,31 @@ func (t Transaction) Encode() []string {
 		t.ProjectID,
 		t.CurrencySymbol,
 		strconv.FormatFloat(t.CurrencyValueDecimal, 'g', -1, 64),
+		t.ChainID,
+		t.CurrencyAddress,
+		rawString(t.CurrencyValueRaw),
+		strconv.Itoa(t.CurrencyDecimals),
 	}
 }
 
+// rawString returns the string representation of the raw value, empty when it is nil.
+func rawString(raw *big.Int) string {
+	if raw == nil {
+		return ""
+	}
+
+	return raw.String()
+}
+
 // Decode decodes the transaction entity from a slice of strings.
+//
+// The chain id, the currency address, the raw value and the currency decimals are optional to decode the data encoded
+// before they were added.
 func (t *Transaction) Decode(d []string) error {
+	if len(d) < 5 {
+		return fmt.Errorf("not enough fields in transaction: %d", len(d))
+	}
+
 	// Parse date.
 	tsStr := d[0]
 
@@ -108,5 +254,24 @@ func (t *Transaction) Decode(d []string) error {
 		return fmt.Errorf("parsing currency value decimal")
 	}
 
+	if len(d) >= 7 {
+		t.ChainID = d[5]
+		t.CurrencyAddress = d[6]
+	}
+
+	if len(d) >= 9 && d[7] != "" {
+		raw, ok := new(big.Int).SetString(d[7], 10)
+		if !ok {
+			return fmt.Errorf("parsing currency value raw")
+		}
+
+		t.CurrencyValueRaw = raw
+
+		t.CurrencyDecimals, err = strconv.Atoi(d[8])
+		if err != nil {
+			return fmt.Errorf("parsing currency decimals")
+		}
+	}
+
 	return nil
 }
@@ -1,6 +1,7 @@
 package entities_test
 
 import (
+	"math/big"
 	"testing"
 	"time"
 
@@ -44,6 +45,43 @@ func TestTransactionNormalize(t *testing.T) {
 	require.Equal(t, "4974", tx.ProjectID)
 	require.Equal(t, "SFL", tx.CurrencySymbol)
 	require.InEpsilon(t, 0.6136203411678249, tx.CurrencyValueDecimal, 0)
+	require.Equal(t, "137", tx.ChainID)
+	require.Equal(t, "0xd1f9c58e33933a993a3891f8acfe05a68e1afc05", tx.CurrencyAddress)
+	require.Equal(t, "613620341167824900", tx.CurrencyValueRaw.String())
+	require.Equal(t, 18, tx.CurrencyDecimals)
+	require.Equal(t, "6136203411678249/10000000000000000", tx.CurrencyValue().RatString())
+}
+
+func TestTransactionNormalize_inconsistent_value(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{
+		`{"currencyValueDecimal":"0","currencyValueRaw":"613620341167824900"}`,
+		`{"currencyValueDecimal":"0.6136203411678249","currencyValueRaw":"713620341167824900"}`,
+	} {
+		record := []string{
+			"seq-market",
+			"2024-04-15 02:15:07.167",
+			"BUY_ITEMS",
+			"4974",
+			"",
+			"1",
+			"0896ae95dcaeee38e83fa5c43bef99780d7b2be23bcab36214",
+			"5d8afd8fec2fbf3e",
+			"DE",
+			"desktop",
+			"linux",
+			"x86_64",
+			"chrome",
+			"122.0.0.0",
+			`{"chainId":"137","currencyAddress":"0xd1f9c58e33933a993a3891f8acfe05a68e1afc05","currencySymbol":"SFL"}`,
+			value,
+		}
+
+		// The row is rejected rather than converted with wrong decimals.
+		_, err := entities.TransactionNormalize(record)
+		require.ErrorContains(t, err, "inconsistent currency value raw")
+	}
 }
 
 func TestTransaction_Encode(t *testing.T) {
@@ -56,6 +94,10 @@ func TestTransaction_Encode(t *testing.T) {
 		ProjectID:            "4974",
 		CurrencySymbol:       "SFL",
 		CurrencyValueDecimal: 0.6136203411678249,
+		ChainID:              "137",
+		CurrencyAddress:      "0xd1f9c58e33933a993a3891f8acfe05a68e1afc05",
+		CurrencyValueRaw:     big.NewInt(613620341167824900),
+		CurrencyDecimals:     18,
 	}
 
 	// Encode the transaction.
@@ -68,6 +110,10 @@ func TestTransaction_Encode(t *testing.T) {
 		"4974",
 		"SFL",
 		"0.6136203411678249",
+		"137",
+		"0xd1f9c58e33933a993a3891f8acfe05a68e1afc05",
+		"613620341167824900",
+		"18",
 	}, encoded)
 }
 
@@ -99,3 +145,78 @@ func TestTransaction_Decode(t *testing.T) {
 	require.Equal(t, "SFL", tx.CurrencySymbol)
 	require.InEpsilon(t, 0.6136203411678249, tx.CurrencyValueDecimal, 0)
 }
+
+func TestTransaction_Decode_currency(t *testing.T) {
+	t.Parallel()
+
+	// Mock the CSV record.
+	record := []string{
+		"2024-04-15 02:15:07.167",
+		"BUY_ITEMS",
+		"4974",
+		"SFL",
+		"0.6136203411678249",
+		"137",
+		"0xd1f9c58e33933a993a3891f8acfe05a68e1afc05",
+	}
+
+	var tx entities.Transaction
+
+	// Decode the record into a transaction.
+	err := tx.Decode(record)
+	require.NoError(t, err)
+
+	// Check the decoded currency.
+	require.Equal(t, entities.Currency{
+		Symbol:  "SFL",
+		ChainID: "137",
+		Address: "0xd1f9c58e33933a993a3891f8acfe05a68e1afc05",
+	}, tx.Currency())
+}
+
+func TestTransaction_Decode_not_enough_fields(t *testing.T) {
+	t.Parallel()
+
+	var tx entities.Transaction
+
+	err := tx.Decode([]string{"2024-04-15 02:15:07.167", "BUY_ITEMS"})
+	require.EqualError(t, err, "not enough fields in transaction: 2")
+}
+
+func TestTransaction_Decode_raw(t *testing.T) {
+	t.Parallel()
+
+	// Mock the CSV record.
+	record := []string{
+		"2024-04-15 02:15:07.167",
+		"BUY_ITEMS",
+		"4974",
+		"USDC",
+		"1.5",
+		"137",
+		"0x3c499c542cef5e3811e1192ce70d8cc03d5c3359",
+		"1500000",
+		"6",
+	}
+
+	var tx entities.Transaction
+
+	// Decode the record into a transaction.
+	err := tx.Decode(record)
+	require.NoError(t, err)
+
+	// Check the decoded value.
+	require.Equal(t, "1500000", tx.CurrencyValueRaw.String())
+	require.Equal(t, 6, tx.CurrencyDecimals)
+	require.Equal(t, "3/2", tx.CurrencyValue().RatString())
+}
+
+func TestTransaction_CurrencyValue_without_raw(t *testing.T) {
+	t.Parallel()
+
+	tx := entities.Transaction{
+		CurrencyValueDecimal: 0.6136203411678249,
+	}
+
+	require.Equal(t, "6136203411678249/10000000000000000", tx.CurrencyValue().RatString())
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// EventRule is the rule applied to the volume of the transactions of an event.
+type EventRule struct {
+	// Multiplier is the multiplier applied to the volume in USD, e.g. 1 for buys and -1 for sells.
+	//
+	// It is kept as the decimal number written in the registry, to be applied with exact arithmetic.
+	Multiplier json.Number `json:"multiplier"`
+	// Ignore is true when the transactions of the event are not part of the volume.
+	Ignore bool `json:"ignore"`
+}
+
+// rat returns the multiplier as an exact rational number, false when it is not a valid number.
+func (r EventRule) rat() (*big.Rat, bool) {
+	return new(big.Rat).SetString(r.Multiplier.String())
+}
+
+// EventRegistry maps the event names to the rule applied to the volume of their transactions.
+type EventRegistry map[string]EventRule
+
+// DefaultEventRegistry returns the event registry with the marketplace buy and sell events.
+func DefaultEventRegistry() EventRegistry {
+	return EventRegistry{
+		"BUY_ITEMS":  {Multiplier: "1"},
+		"SELL_ITEMS": {Multiplier: "-1"},
+	}
+}
+
+// LoadEventRegistry loads the event registry from the given JSON file.
+//
+// The file maps the event names to their rule, for example:
+//
+//	{
+//	  "BUY_ITEMS": {"multiplier": 1},
+//	  "SELL_ITEMS": {"multiplier": -1},
+//	  "LIST_ITEMS": {"ignore": true}
+//	}
+func LoadEventRegistry(file string) (EventRegistry, error) {
+	data, err := os.ReadFile(file) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("opening event registry: %w", err)
+	}
+
+	var r EventRegistry
+
+	if err = json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parsing event registry: %w", err)
+	}
+
+	for event, rule := range r {
+		if rule.Ignore {
+			continue
+		}
+
+		if multiplier, ok := rule.rat(); !ok || multiplier.Sign() == 0 {
+			return nil, fmt.Errorf("invalid event registry: event %s requires a multiplier or to be ignored", event)
+		}
+	}
+
+	return r, nil
+}
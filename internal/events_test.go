@@ -0,0 +1,56 @@
+package internal_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal"
+)
+
+func TestLoadEventRegistry(t *testing.T) {
+	t.Parallel()
+
+	events, err := internal.LoadEventRegistry("../resources/events.json")
+	require.NoError(t, err)
+
+	require.Equal(t, internal.EventRule{Multiplier: "1"}, events["BUY_ITEMS"])
+	require.Equal(t, internal.EventRule{Multiplier: "-1"}, events["SELL_ITEMS"])
+	require.Equal(t, internal.EventRule{Ignore: true}, events["LIST_ITEMS"])
+}
+
+func TestLoadEventRegistry_invalid(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "events.json")
+
+	err := os.WriteFile(file, []byte(`{"TRANSFER_ITEMS": {}}`), 0o600)
+	require.NoError(t, err)
+
+	_, err = internal.LoadEventRegistry(file)
+	require.ErrorContains(t, err, "event TRANSFER_ITEMS requires a multiplier or to be ignored")
+}
+
+func TestLoadEventRegistry_decimal(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "events.json")
+
+	err := os.WriteFile(file, []byte(`{"BUY_ITEMS": {"multiplier": 0.1}, "SELL_ITEMS": {"multiplier": "-0.1"}}`), 0o600)
+	require.NoError(t, err)
+
+	// The multipliers are kept as written, not as their floating point approximation.
+	events, err := internal.LoadEventRegistry(file)
+	require.NoError(t, err)
+
+	require.Equal(t, internal.EventRule{Multiplier: "0.1"}, events["BUY_ITEMS"])
+	require.Equal(t, internal.EventRule{Multiplier: "-0.1"}, events["SELL_ITEMS"])
+
+	err = os.WriteFile(file, []byte(`{"BUY_ITEMS": {"multiplier": 0}}`), 0o600)
+	require.NoError(t, err)
+
+	_, err = internal.LoadEventRegistry(file)
+	require.ErrorContains(t, err, "event BUY_ITEMS requires a multiplier or to be ignored")
+}
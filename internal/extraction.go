@@ -1,48 +1,293 @@
 package internal
 
 import (
-	"bytes"
 	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
 
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/compress"
 	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
 )
 
+// defaultExtractConcurrency is the number of files read concurrently by the extraction as default.
+const defaultExtractConcurrency = 4
+
 //go:generate mockery --name=ExtractProvider --outpkg=mocks --output=mocks --filename=extract_provider.go --with-expecter
 
 // ExtractProvider is the interface that provides the ability to load the data from the provider.
 type ExtractProvider interface {
-	// Load loads the data from the provider.
-	Load(ctx context.Context) ([]byte, error)
+	// Open opens the data from the provider to be read as a stream.
+	//
+	// The data is read as it is stored, Extract decompresses the gzip or zstd compressed data.
+	// The caller is responsible for closing the returned reader.
+	Open(ctx context.Context) (io.ReadCloser, error)
+}
+
+// MultiFileExtractProvider is the interface implemented by the extract providers able to read several files, such as
+// the storages matching the file as a glob pattern or a prefix.
+type MultiFileExtractProvider interface {
+	ExtractProvider
+
+	// ListFiles returns the files to extract, sorted by name.
+	ListFiles(ctx context.Context) ([]string, error)
+	// OpenFile opens the file, as listed by ListFiles, to be read as a stream.
+	//
+	// The caller is responsible for closing the returned reader.
+	OpenFile(ctx context.Context, file string) (io.ReadCloser, error)
+}
+
+// RejectFunc is the function type called with each row rejected by the extraction.
+type RejectFunc func(ctx context.Context, r entities.Rejection) error
+
+// FileRowsFunc is the function type called with the number of rows read from each file by the extraction.
+type FileRowsFunc func(file string, rows int)
+
+// ExtractPosition is the position of the extraction in the input.
+type ExtractPosition struct {
+	// File is the file being read, when the provider is a MultiFileExtractProvider.
+	File string `json:"file,omitempty"`
+	// Row is the number of rows read from the file, the header excluded.
+	Row int `json:"row"`
+	// Offset is the byte offset of the uncompressed file after the rows read.
+	Offset int64 `json:"offset"`
+	// Rejects is the number of rows rejected so far.
+	Rejects int `json:"rejects"`
+	// Transactions is the number of transactions sent to the output channel by the extraction so far, the transactions
+	// sent before resuming excluded.
+	Transactions int `json:"-"`
+}
+
+// CheckpointFunc is the function type called with the position of the extraction at each checkpoint.
+//
+// The extraction is blocked until it returns, so no transaction is sent to the output channel in the meantime.
+type CheckpointFunc func(ctx context.Context, pos ExtractPosition) error
+
+// ExtractOption is a convenience type which will be used to modify the extraction behavior.
+type ExtractOption func(o *extractOptions)
+
+// extractOptions holds the options of the extraction.
+type extractOptions struct {
+	// tolerate is true when the malformed rows are rejected instead of failing the extraction.
+	tolerate   bool
+	maxRejects int
+	onReject   RejectFunc
+
+	concurrency int
+	onFileRows  FileRowsFunc
+
+	checkpointRows int
+	onCheckpoint   CheckpointFunc
+	resume         *ExtractPosition
+}
+
+// WithRejects configures the extraction to reject the malformed rows instead of failing.
+//
+// The rejected rows, along with their line number and the reason, are passed to onReject when it is not nil.
+// The extraction fails once the number of rejected rows exceeds maxRejects. If maxRejects is 0, there is no limit.
+func WithRejects(maxRejects int, onReject RejectFunc) ExtractOption {
+	return func(o *extractOptions) {
+		o.tolerate = true
+		o.maxRejects = maxRejects
+		o.onReject = onReject
+	}
+}
+
+// WithConcurrency configures the number of files read concurrently when the provider is a MultiFileExtractProvider.
+// If it is 0, it will be set to 4.
+func WithConcurrency(concurrency int) ExtractOption {
+	return func(o *extractOptions) {
+		o.concurrency = concurrency
+	}
+}
+
+// WithFileRows configures the function called with the number of rows read from each file when the provider is a
+// MultiFileExtractProvider.
+func WithFileRows(onFileRows FileRowsFunc) ExtractOption {
+	return func(o *extractOptions) {
+		o.onFileRows = onFileRows
+	}
+}
+
+// WithCheckpoint configures the function called with the position of the extraction every checkpointRows rows read.
+//
+// The files listed by a MultiFileExtractProvider are read one at a time, in order, so the position is consistent.
+func WithCheckpoint(checkpointRows int, onCheckpoint CheckpointFunc) ExtractOption {
+	return func(o *extractOptions) {
+		o.checkpointRows = checkpointRows
+		o.onCheckpoint = onCheckpoint
+	}
+}
+
+// WithResume configures the extraction to resume from the position of a checkpoint.
+//
+// The rows up to the position are skipped, and the files listed before the file of the position too. The extraction
+// fails when the input differs from the input read up to the checkpoint, i.e. the byte offset of the position does not
+// match.
+func WithResume(pos ExtractPosition) ExtractOption {
+	return func(o *extractOptions) {
+		o.resume = &pos
+	}
+}
+
+// extractor holds the state of the extraction shared by the files read concurrently.
+type extractor struct {
+	o extractOptions
+
+	output chan<- entities.Transaction
+
+	rejects atomic.Int64
+	sent    atomic.Int64
+
+	// header is the header of the first file read, headerFile, all the files must share the same header.
+	header     []string
+	headerFile string
+	headerSm   sync.Mutex
 }
 
 // Extract extracts the transactions from the provider and sends them to the output channel.
 //
 // It receives a provider which loads the data and an output channel to send the normalize transactions.
-func Extract(ctx context.Context, provider ExtractProvider, output chan<- entities.Transaction) error {
-	data, err := provider.Load(ctx)
+// The data is read as a stream, record by record, therefore the memory used is bounded regardless of the input size.
+//
+// When the provider is a MultiFileExtractProvider, the files listed are read concurrently and must share the same
+// header. The transactions of the files are sent to the output channel in no particular order.
+//
+// By default, the extraction fails on the first malformed row. Use WithRejects to reject the malformed rows instead.
+func Extract(ctx context.Context, provider ExtractProvider, output chan<- entities.Transaction, opts ...ExtractOption) error {
+	e := extractor{output: output}
+
+	for _, opt := range opts {
+		opt(&e.o)
+	}
+
+	if e.o.concurrency == 0 {
+		e.o.concurrency = defaultExtractConcurrency
+	}
+
+	if e.o.onCheckpoint != nil {
+		e.o.concurrency = 1
+	}
+
+	if e.o.resume != nil {
+		e.rejects.Store(int64(e.o.resume.Rejects))
+	}
+
+	if mp, ok := provider.(MultiFileExtractProvider); ok {
+		return e.extractFiles(ctx, mp)
+	}
+
+	data, err := provider.Open(ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err = decompress(data)
+	if err != nil {
+		return err
+	}
+
+	defer data.Close() //nolint:errcheck
+
+	_, err = e.extract(ctx, data, "")
+
+	return err
+}
+
+// decompress returns the reader of the input decompressing the data of the gzip or zstd compressed input, the codec is
+// detected from the content regardless of the file extension. It closes the input when it fails.
+func decompress(input io.ReadCloser) (io.ReadCloser, error) {
+	data, err := compress.NewReader(input)
+	if err != nil {
+		_ = input.Close() //nolint:errcheck
+
+		return nil, fmt.Errorf("opening input: %w", err)
+	}
+
+	return data, nil
+}
+
+// extractFiles extracts the transactions of the files listed by the provider concurrently.
+func (e *extractor) extractFiles(ctx context.Context, provider MultiFileExtractProvider) error {
+	files, err := provider.ListFiles(ctx)
 	if err != nil {
 		return err
 	}
 
-	reader := csv.NewReader(bytes.NewReader(data))
+	if e.o.resume != nil {
+		i := slices.Index(files, e.o.resume.File)
+		if i == -1 {
+			return fmt.Errorf("file %s to resume from is not listed", e.o.resume.File)
+		}
+
+		files = files[i:]
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(e.o.concurrency)
+
+	for _, file := range files {
+		g.Go(func() error {
+			data, err := provider.OpenFile(ctx, file)
+			if err != nil {
+				return err
+			}
+
+			data, err = decompress(data)
+			if err != nil {
+				return fmt.Errorf("file %s: %w", file, err)
+			}
+
+			defer data.Close() //nolint:errcheck
+
+			rows, err := e.extract(ctx, data, file)
+			if err != nil {
+				return fmt.Errorf("file %s: %w", file, err)
+			}
+
+			if e.o.onFileRows != nil {
+				e.o.onFileRows(file, rows)
+			}
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// extract extracts the transactions of the data read from the file, and returns the number of rows read.
+func (e *extractor) extract(ctx context.Context, data io.Reader, file string) (int, error) {
+	reader := csv.NewReader(data)
 
 	// Read and discard the header line
 	header, err := reader.Read()
 	if err != nil {
-		return fmt.Errorf("reading header: %w", err)
+		return 0, fmt.Errorf("reading header: %w", err)
 	}
 
 	if len(header) != entities.InputFieldNum {
-		return fmt.Errorf("not enough fields in input: %d", len(header))
+		return 0, fmt.Errorf("not enough fields in input: %d", len(header))
+	}
+
+	if err := e.checkHeader(header, file); err != nil {
+		return 0, err
+	}
+
+	rows, err := e.skip(reader, file)
+	if err != nil {
+		return rows, err
 	}
 
 	for {
 		if ctx.Err() != nil {
-			return ctx.Err()
+			return rows, ctx.Err()
 		}
 
 		record, err := reader.Read()
@@ -50,17 +295,128 @@ func Extract(ctx context.Context, provider ExtractProvider, output chan<- entiti
 			break // End.
 		}
 
-		if err != nil {
-			return err
+		rows++
+
+		var (
+			line        int
+			transaction entities.Transaction
+			parseErr    *csv.ParseError
+		)
+
+		switch {
+		case errors.As(err, &parseErr):
+			// Malformed CSV row, the reader can continue with the next one.
+			line = parseErr.StartLine
+		case err != nil:
+			return rows, err
+		default:
+			line, _ = reader.FieldPos(0)
+
+			transaction, err = entities.TransactionNormalize(record)
+		}
+
+		switch {
+		case err == nil:
+			select {
+			case <-ctx.Done():
+				return rows, ctx.Err()
+			case e.output <- transaction:
+				e.sent.Add(1)
+			}
+		case !e.o.tolerate:
+			return rows, fmt.Errorf("line %d: %w", line, err)
+		default:
+			rejects := int(e.rejects.Add(1))
+
+			if err := e.o.reject(ctx, rejects, entities.Rejection{File: file, Line: line, Reason: err.Error(), Record: record}); err != nil {
+				return rows, err
+			}
+		}
+
+		if err := e.checkpoint(ctx, reader, file, rows); err != nil {
+			return rows, err
+		}
+	}
+
+	return rows, nil
+}
+
+// skip skips the rows of the file read up to the position to resume from, and returns the number of rows skipped.
+func (e *extractor) skip(reader *csv.Reader, file string) (int, error) {
+	if e.o.resume == nil || e.o.resume.File != file {
+		return 0, nil
+	}
+
+	var rows int
+
+	for rows < e.o.resume.Row {
+		_, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, fmt.Errorf("input has %d rows, less than the %d rows to resume from", rows, e.o.resume.Row)
 		}
 
-		transaction, err := entities.TransactionNormalize(record)
-		if err != nil {
-			return err
+		rows++
+
+		var parseErr *csv.ParseError
+
+		if err != nil && !errors.As(err, &parseErr) {
+			return rows, err
 		}
+	}
+
+	if offset := reader.InputOffset(); offset != e.o.resume.Offset {
+		return rows, fmt.Errorf("input differs from the input to resume from, offset %d after %d rows, expected %d",
+			offset, rows, e.o.resume.Offset)
+	}
+
+	return rows, nil
+}
+
+// checkpoint passes the position of the extraction to the onCheckpoint function, every checkpoint rows.
+func (e *extractor) checkpoint(ctx context.Context, reader *csv.Reader, file string, rows int) error {
+	if e.o.onCheckpoint == nil || e.o.checkpointRows <= 0 || rows%e.o.checkpointRows != 0 {
+		return nil
+	}
+
+	return e.o.onCheckpoint(ctx, ExtractPosition{
+		File:         file,
+		Row:          rows,
+		Offset:       reader.InputOffset(),
+		Rejects:      int(e.rejects.Load()),
+		Transactions: int(e.sent.Load()),
+	})
+}
+
+// checkHeader checks the header of the file is the same as the header of the first file read.
+func (e *extractor) checkHeader(header []string, file string) error {
+	e.headerSm.Lock()
+	defer e.headerSm.Unlock()
+
+	if e.header == nil {
+		e.header = header
+		e.headerFile = file
+
+		return nil
+	}
 
-		output <- transaction
+	if !slices.Equal(e.header, header) {
+		return fmt.Errorf("header %v differs from header %v of file %s", header, e.header, e.headerFile)
 	}
 
 	return nil
 }
+
+// reject passes the rejected row to the onReject function, if any.
+//
+// It fails when the number of rejects exceeds the max rejects.
+func (o *extractOptions) reject(ctx context.Context, rejects int, r entities.Rejection) error {
+	if o.maxRejects > 0 && rejects > o.maxRejects {
+		return fmt.Errorf("too many rejected rows, max rejects %d exceeded: line %d: %s", o.maxRejects, r.Line, r.Reason)
+	}
+
+	if o.onReject == nil {
+		return nil
+	}
+
+	return o.onReject(ctx, r)
+}
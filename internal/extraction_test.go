@@ -2,8 +2,13 @@ package internal_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/csv"
+	"io"
+	"os"
+	"path"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/mock"
@@ -12,6 +17,7 @@ import (
 	"github.com/dohernandez/horizon-blockchain-games/internal"
 	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
 	"github.com/dohernandez/horizon-blockchain-games/internal/mocks"
+	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
 )
 
 func TestExtract(t *testing.T) {
@@ -37,7 +43,7 @@ func TestExtract(t *testing.T) {
 	require.NoError(t, writer.Error())
 
 	provider := mocks.NewExtractProvider(t)
-	provider.EXPECT().Load(mock.Anything).Return(buf.Bytes(), nil)
+	provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(&buf), nil)
 
 	output := make(chan entities.Transaction, 20)
 
@@ -60,3 +66,221 @@ func TestExtract(t *testing.T) {
 		i++
 	}
 }
+
+func TestExtract_rejects(t *testing.T) {
+	t.Parallel()
+
+	// Load sample data with limit 3 to load 2 data lines since offset is -1 which means load the header line.
+	dataSample, err := internal.LoadSampleData(3, -1)
+	require.NoError(t, err)
+
+	// Build the input with malformed rows on line 3 (invalid timestamp) and line 5 (missing fields).
+	invalidTS := make([]string, len(dataSample[1]))
+	copy(invalidTS, dataSample[1])
+	invalidTS[1] = "15/04/2024"
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	for _, record := range [][]string{dataSample[0], dataSample[1], invalidTS, dataSample[2], dataSample[1][:3]} {
+		err = writer.Write(record)
+		require.NoError(t, err)
+	}
+
+	writer.Flush()
+	require.NoError(t, writer.Error())
+
+	input := buf.Bytes()
+
+	tests := []struct {
+		name     string
+		opts     func(rejected *[]entities.Rejection) []internal.ExtractOption
+		expTxs   int
+		expLines []int
+		expErr   string
+	}{
+		{
+			name: "fail fast",
+			opts: func(_ *[]entities.Rejection) []internal.ExtractOption {
+				return nil
+			},
+			expTxs: 1,
+			expErr: "line 3: parsing time",
+		},
+		{
+			name: "reject without limit",
+			opts: func(rejected *[]entities.Rejection) []internal.ExtractOption {
+				return []internal.ExtractOption{
+					internal.WithRejects(0, func(_ context.Context, r entities.Rejection) error {
+						*rejected = append(*rejected, r)
+
+						return nil
+					}),
+				}
+			},
+			expTxs:   2,
+			expLines: []int{3, 5},
+		},
+		{
+			name: "reject exceeding max rejects",
+			opts: func(rejected *[]entities.Rejection) []internal.ExtractOption {
+				return []internal.ExtractOption{
+					internal.WithRejects(1, func(_ context.Context, r entities.Rejection) error {
+						*rejected = append(*rejected, r)
+
+						return nil
+					}),
+				}
+			},
+			expTxs:   2,
+			expLines: []int{3},
+			expErr:   "too many rejected rows, max rejects 1 exceeded: line 5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			provider := mocks.NewExtractProvider(t)
+			provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(input)), nil)
+
+			var rejected []entities.Rejection
+
+			output := make(chan entities.Transaction, 20)
+
+			err := internal.Extract(ctx, provider, output, tt.opts(&rejected)...)
+
+			close(output)
+
+			if tt.expErr != "" {
+				require.ErrorContains(t, err, tt.expErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Len(t, output, tt.expTxs)
+			require.Len(t, rejected, len(tt.expLines))
+
+			for i, line := range tt.expLines {
+				require.Equal(t, line, rejected[i].Line)
+				require.NotEmpty(t, rejected[i].Reason)
+				require.NotEmpty(t, rejected[i].Record)
+			}
+		})
+	}
+}
+
+// newFilesProvider returns the extract provider of the files of the directory matching the glob pattern or prefix.
+func newFilesProvider(dir, pattern string) internal.ExtractProvider {
+	return storage.NewFileSystem(dir, pattern)
+}
+
+// writeShard writes the records as a CSV file in the directory.
+func writeShard(t *testing.T, dir, file string, records [][]string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	writer := csv.NewWriter(&buf)
+	require.NoError(t, writer.WriteAll(records))
+
+	require.NoError(t, os.MkdirAll(path.Dir(path.Join(dir, file)), 0o750))
+	require.NoError(t, os.WriteFile(path.Join(dir, file), buf.Bytes(), 0o600))
+}
+
+func TestExtract_compressed(t *testing.T) {
+	t.Parallel()
+
+	// Load sample data with limit 4 to load 3 data lines since offset is -1 which means load the header line.
+	dataSample, err := internal.LoadSampleData(4, -1)
+	require.NoError(t, err)
+
+	var data bytes.Buffer
+
+	w := gzip.NewWriter(&data)
+
+	_, err = w.Write(encodeToBytes(t, dataSample, func(_ *testing.T, record []string) []string {
+		return record
+	}))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	// The input is decompressed on the fly, the provider reads it as it is stored.
+	transactions, err := extractAll(t, data.Bytes())
+	require.NoError(t, err)
+	require.Len(t, transactions, 3)
+}
+
+func TestExtract_files(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Load sample data with limit 6 to load 5 data lines since offset is -1 which means load the header line.
+	dataSample, err := internal.LoadSampleData(6, -1)
+	require.NoError(t, err)
+
+	header := dataSample[0]
+
+	dir := t.TempDir()
+
+	writeShard(t, dir, "events/2024-04-15/00.csv", [][]string{header, dataSample[1], dataSample[2]})
+	writeShard(t, dir, "events/2024-04-15/01.csv", [][]string{header, dataSample[3], dataSample[4], dataSample[5]})
+	writeShard(t, dir, "events/2024-04-15/02.json", [][]string{{"not a shard"}})
+	writeShard(t, dir, "events/2024-04-16/00.csv", [][]string{header, dataSample[1]})
+
+	var (
+		fileRows = make(map[string]int)
+		sm       sync.Mutex
+	)
+
+	output := make(chan entities.Transaction, 20)
+
+	err = internal.Extract(ctx, newFilesProvider(dir, "events/2024-04-15/*.csv"), output,
+		internal.WithConcurrency(2),
+		internal.WithFileRows(func(file string, rows int) {
+			sm.Lock()
+			defer sm.Unlock()
+
+			fileRows[file] = rows
+		}),
+	)
+	require.NoError(t, err)
+
+	close(output)
+
+	require.Len(t, output, 5)
+	require.Equal(t, map[string]int{
+		"events/2024-04-15/00.csv": 2,
+		"events/2024-04-15/01.csv": 3,
+	}, fileRows)
+}
+
+func TestExtract_files_header(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dataSample, err := internal.LoadSampleData(2, -1)
+	require.NoError(t, err)
+
+	header := dataSample[0]
+
+	// The second shard has the same number of columns in a different order.
+	otherHeader := append([]string{header[1], header[0]}, header[2:]...)
+
+	dir := t.TempDir()
+
+	writeShard(t, dir, "events/00.csv", [][]string{header, dataSample[1]})
+	writeShard(t, dir, "events/01.csv", [][]string{otherHeader, dataSample[1]})
+
+	output := make(chan entities.Transaction, 20)
+
+	// The files are read one at a time, so the first file sets the header.
+	err = internal.Extract(ctx, newFilesProvider(dir, "events/"), output, internal.WithConcurrency(1))
+	require.ErrorContains(t, err, "file events/01.csv: header")
+	require.ErrorContains(t, err, "differs from header")
+}
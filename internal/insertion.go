@@ -11,7 +11,12 @@ import (
 // WarehouseProvider is the interface that provides the ability to save the flatten entity.
 type WarehouseProvider interface {
 	// Save saves the flatten entity.
+	//
+	// The provider may buffer the flatten entities to save them in batches, they are not guaranteed to be saved
+	// until Flush is called.
 	Save(ctx context.Context, flatten entities.Flatten) error
+	// Flush saves the buffered flatten entities.
+	Flush(ctx context.Context) error
 }
 
 // Insert inserts the flatten entity into the target.
@@ -2,6 +2,7 @@ package internal_test
 
 import (
 	"context"
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/mock"
@@ -22,13 +23,13 @@ func TestInsert(t *testing.T) {
 			Date:        "2024-04-15",
 			ProjectID:   "4974",
 			NumTxs:      6,
-			TotalVolume: 6.00,
+			TotalVolume: big.NewRat(6, 1),
 		},
 		"2024-04-01": {
 			Date:        "2024-04-01",
 			ProjectID:   "0",
 			NumTxs:      10,
-			TotalVolume: -10.00,
+			TotalVolume: big.NewRat(-10, 1),
 		},
 	}
 
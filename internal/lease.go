@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/lock"
+)
+
+// DefaultLockTTL is the time to live of the lease of the lock of the step data, when it is not configured.
+const DefaultLockTTL = time.Minute
+
+// stepDataUsed returns whether the run reads or writes the step data, i.e. it does not run all the steps at once, or it
+// saves the rejected rows.
+func (p *Pipeline) stepDataUsed() bool {
+	allInOne := p.cfg.ExtractStepEnabled && p.cfg.CalculateStepEnabled && p.cfg.InsertStepEnabled
+
+	return !allInOne || p.cfg.ErrorPolicy == DeadLetterPolicy
+}
+
+// acquireLock acquires the lease of the lock of the step data, when the run uses the step data and the step provider
+// supports locking, and renews it every third of its time to live while the run is running.
+//
+// It returns the context of the run, canceled with the error when the lease is lost, or it can not be renewed before
+// it expires, and the function to release the lease once the run is done.
+func (p *Pipeline) acquireLock(ctx context.Context) (context.Context, func() error, error) {
+	noop := func() error { return nil }
+
+	if !p.stepDataUsed() {
+		return ctx, noop, nil
+	}
+
+	locker, ok := p.b.StepProvider().(lock.Locker)
+	if !ok {
+		return ctx, noop, nil
+	}
+
+	if p.cfg.LockTTL <= 0 {
+		return ctx, nil, fmt.Errorf("invalid lock ttl %s, it must be positive", p.cfg.LockTTL)
+	}
+
+	if p.cfg.ForceUnlock {
+		if err := locker.ForceUnlock(ctx); err != nil {
+			return ctx, nil, fmt.Errorf("force unlocking: %w", err)
+		}
+	}
+
+	lease, err := locker.Acquire(ctx, p.cfg.LockTTL)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("acquiring lock: %w", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		// The ticker requires a positive interval, the time to live may be shorter than 3ns.
+		ticker := time.NewTicker(max(p.cfg.LockTTL/3, time.Nanosecond))
+		defer ticker.Stop()
+
+		renewedAt := time.Now()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := lease.Renew(ctx)
+				if err == nil {
+					renewedAt = time.Now()
+
+					continue
+				}
+
+				// The renewal is retried on the next tick, unless the lease is lost or expired.
+				if errors.Is(err, lock.ErrLost) || time.Since(renewedAt) >= p.cfg.LockTTL {
+					cancel(fmt.Errorf("renewing lock: %w", err))
+
+					return
+				}
+			}
+		}
+	}()
+
+	release := func() error {
+		cancel(nil)
+		<-done
+
+		// The context of the run is canceled once it is done.
+		if err := lease.Release(context.WithoutCancel(ctx)); err != nil {
+			return fmt.Errorf("releasing lock: %w", err)
+		}
+
+		return nil
+	}
+
+	return ctx, release, nil
+}
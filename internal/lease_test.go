@@ -0,0 +1,127 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal"
+	"github.com/dohernandez/horizon-blockchain-games/internal/lock"
+	"github.com/dohernandez/horizon-blockchain-games/internal/mocks"
+	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
+)
+
+func TestPipeline_Run_lock(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	stepProvider := storage.NewFileSystem(dir, "")
+
+	// The lock is held by another run.
+	_, err := stepProvider.Acquire(ctx, time.Minute)
+	require.NoError(t, err)
+
+	b := mocks.NewPipelineBackend(t)
+	b.EXPECT().StepProvider().Return(stepProvider)
+
+	err = internal.NewPipeline(b, internal.PipelineConfig{
+		ExtractStepEnabled: true,
+	}).Run(ctx)
+	require.ErrorIs(t, err, lock.ErrLocked)
+
+	// The run holds the lock once forced to unlock, and releases it once done.
+	dataSample, err := internal.LoadSampleData(4, -1)
+	require.NoError(t, err)
+
+	provider := mocks.NewExtractProvider(t)
+	provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(encodeToBytes(t, dataSample,
+		func(_ *testing.T, record []string) []string {
+			return record
+		},
+	))), nil)
+
+	b.EXPECT().ExtractProvider().Return(provider)
+
+	err = internal.NewPipeline(b, internal.PipelineConfig{
+		ExtractStepEnabled: true,
+		ForceUnlock:        true,
+	}).Run(ctx)
+	require.NoError(t, err)
+
+	_, err = os.Stat(path.Join(dir, lock.File))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+// lostLocker is the step provider whose lease is lost once it is renewed.
+type lostLocker struct {
+	*storage.FileSystem
+
+	// renewed is closed with the error once the lease is renewed, to stop the extraction reading from it.
+	renewed *io.PipeWriter
+}
+
+// Acquire returns the lease lost once it is renewed.
+func (l lostLocker) Acquire(context.Context, time.Duration) (lock.Lease, error) {
+	return lostLease(l), nil
+}
+
+// lostLease is the lease lost once it is renewed.
+type lostLease lostLocker
+
+// Renew fails with lock.ErrLost.
+func (l lostLease) Renew(context.Context) error {
+	_ = l.renewed.CloseWithError(errors.New("input closed")) //nolint:errcheck
+
+	return lock.ErrLost
+}
+
+// Release does nothing.
+func (lostLease) Release(context.Context) error {
+	return nil
+}
+
+func TestPipeline_Run_lock_lost(t *testing.T) {
+	t.Parallel()
+
+	r, w := io.Pipe()
+
+	provider := mocks.NewExtractProvider(t)
+	provider.EXPECT().Open(mock.Anything).Return(r, nil)
+
+	b := mocks.NewPipelineBackend(t)
+	b.EXPECT().ExtractProvider().Return(provider)
+	b.EXPECT().StepProvider().Return(lostLocker{FileSystem: storage.NewFileSystem(t.TempDir(), ""), renewed: w})
+
+	// The run fails with the cause once the lease is lost.
+	err := internal.NewPipeline(b, internal.PipelineConfig{
+		ExtractStepEnabled: true,
+		LockTTL:            30 * time.Millisecond,
+	}).Run(context.Background())
+	require.ErrorIs(t, err, lock.ErrLost)
+	require.ErrorContains(t, err, "renewing lock")
+}
+
+func TestPipeline_Run_lock_invalid_ttl(t *testing.T) {
+	t.Parallel()
+
+	b := mocks.NewPipelineBackend(t)
+	b.EXPECT().StepProvider().Return(storage.NewFileSystem(t.TempDir(), ""))
+
+	// The run fails instead of renewing the lease with an invalid period.
+	err := internal.NewPipeline(b, internal.PipelineConfig{
+		ExtractStepEnabled: true,
+		LockTTL:            -time.Second,
+	}).Run(context.Background())
+	require.ErrorContains(t, err, "invalid lock ttl -1s, it must be positive")
+}
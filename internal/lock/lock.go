@@ -0,0 +1,94 @@
+// Package lock provides the lease which prevents concurrent runs on the same step data.
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// File is the file of the lock, stored at the root of the step data.
+const File = "lock"
+
+var (
+	// ErrLocked is returned when the lock is held by another run.
+	ErrLocked = errors.New("locked")
+	// ErrLost is returned when the lease is renewed or released after it was taken over by another run.
+	ErrLost = errors.New("lock lost")
+)
+
+// Lease is the lock held by the run, for a limited time unless it is renewed.
+type Lease interface {
+	// Renew extends the expiry of the lease by its time to live, failing with ErrLost when the lease was taken over.
+	Renew(ctx context.Context) error
+	// Release releases the lock, failing with ErrLost when the lease was taken over.
+	Release(ctx context.Context) error
+}
+
+// Locker is the step provider which supports locking the step data.
+type Locker interface {
+	// Acquire acquires the lease of the lock for ttl, failing with ErrLocked when the lock is held by another run.
+	// The lock whose lease expired is taken over, its holder is considered gone.
+	Acquire(ctx context.Context, ttl time.Duration) (Lease, error)
+	// ForceUnlock releases the lock regardless of its holder.
+	ForceUnlock(ctx context.Context) error
+}
+
+// Info is the content of the lock file, identifying its holder.
+type Info struct {
+	// Token identifies the lease, it is unique per acquisition.
+	Token string `json:"token"`
+	// Host is the host name of the holder.
+	Host string `json:"host"`
+	// PID is the process id of the holder.
+	PID int `json:"pid"`
+	// AcquiredAt is the time the lease was acquired.
+	AcquiredAt time.Time `json:"acquired_at"`
+	// ExpiresAt is the time the lease expires unless it is renewed.
+	ExpiresAt time.Time `json:"expires_at"`
+	// TTL is the time to live of the lease.
+	TTL time.Duration `json:"ttl"`
+}
+
+// NewInfo returns the info of a new lease of the current process for ttl.
+func NewInfo(ttl time.Duration) Info {
+	token := make([]byte, 8)
+
+	// crypto/rand Read never returns an error.
+	_, _ = rand.Read(token) //nolint:errcheck
+
+	// The host name is informative, the lease is identified by the token.
+	host, _ := os.Hostname() //nolint:errcheck
+
+	now := time.Now().UTC()
+
+	return Info{
+		Token:      hex.EncodeToString(token),
+		Host:       host,
+		PID:        os.Getpid(),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+		TTL:        ttl,
+	}
+}
+
+// Renewed returns the info of the lease renewed at now.
+func (i Info) Renewed(now time.Time) Info {
+	i.ExpiresAt = now.UTC().Add(i.TTL)
+
+	return i
+}
+
+// Expired returns whether the lease is expired at now.
+func (i Info) Expired(now time.Time) bool {
+	return !now.Before(i.ExpiresAt)
+}
+
+// Locked returns the ErrLocked error describing the holder of the lock.
+func Locked(i Info) error {
+	return fmt.Errorf("%w by %s pid %d until %s", ErrLocked, i.Host, i.PID, i.ExpiresAt.Format(time.RFC3339))
+}
@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"slices"
+	"time"
+)
+
+const (
+	// StepSchemaVersion is the schema version of the step data written by the pipeline.
+	//
+	// Version 2 step data starts with a header row and is described by a sidecar manifest.
+	StepSchemaVersion = 2
+	// legacyStepSchemaVersion is the schema version of the step data written without header row nor manifest.
+	//
+	// Its columns are in the order of the entities Encode at the time it was written, the fields added later are
+	// optional to decode it.
+	legacyStepSchemaVersion = 1
+
+	// checksumAlgorithm is the algorithm of the step data checksum.
+	checksumAlgorithm = "sha256"
+)
+
+// StepManifest describes the step data saved by the pipeline.
+//
+// It is saved along with the step data, into <run-id>/<step>.manifest.json, once the step data is persisted. The files
+// of the step data are relative to the directory of the run.
+type StepManifest struct {
+	// SchemaVersion is the schema version of the step data.
+	SchemaVersion int `json:"schema_version"`
+	// Step is the step of the pipeline the data belongs to.
+	Step string `json:"step"`
+	// File is the file of the step data, e.g. extraction or extraction.parquet.zst. It is empty when the step data is
+	// saved into parts.
+	File string `json:"file,omitempty"`
+	// Format is the format of the step data. If it is empty, the step data is CSV.
+	Format string `json:"format"`
+	// Compression is the codec the step data is compressed with.
+	Compression string `json:"compression"`
+	// Header is the names of the fields of the step data, the header row of the CSV step data.
+	Header []string `json:"header"`
+	// Rows is the number of rows of the step data, the header row excluded.
+	Rows int `json:"rows"`
+	// Checksum is the checksum of the uncompressed step data, as <algorithm>:<hex>. It is empty when the step data is
+	// saved into parts, each part has its own checksum.
+	Checksum string `json:"checksum,omitempty"`
+	// Parts is the parts of the step data, in order, when it is saved into several files, e.g. by a checkpointed run.
+	Parts []StepPart `json:"parts,omitempty"`
+	// ProducerVersion is the version of the application which saved the step data.
+	ProducerVersion string `json:"producer_version"`
+	// SourceFile is the file the data was extracted from.
+	SourceFile string `json:"source_file"`
+	// CreatedAt is the time the step data was saved.
+	CreatedAt time.Time `json:"created_at"`
+
+	// runID is the identifier of the run the step data is loaded from.
+	runID string
+}
+
+// StepPart is a file of the step data saved into several files.
+//
+// Each part is a complete file in the format of the step data, the CSV part starts with the header row.
+type StepPart struct {
+	// File is the file of the part.
+	File string `json:"file"`
+	// Rows is the number of rows of the part, the header row excluded.
+	Rows int `json:"rows"`
+	// Checksum is the checksum of the uncompressed part, as <algorithm>:<hex>.
+	Checksum string `json:"checksum"`
+}
+
+// stepParts returns the parts of the step data described by the manifest, the step data saved into a single file is a
+// single part.
+func stepParts(m StepManifest) []StepPart {
+	if len(m.Parts) > 0 {
+		return m.Parts
+	}
+
+	return []StepPart{{File: m.File, Rows: m.Rows, Checksum: m.Checksum}}
+}
+
+// stepPartName returns the name of the part used in the errors, the step, along with the file of the part when the
+// step data is saved into parts.
+func stepPartName(m StepManifest, part StepPart) string {
+	if len(m.Parts) == 0 {
+		return m.Step
+	}
+
+	return m.Step + " part " + part.File
+}
+
+// manifestFile returns the file of the manifest of the step data.
+func manifestFile(step Step) string {
+	return step.String() + ".manifest.json"
+}
+
+// newChecksum returns the hash used to calculate the checksum of the step data.
+func newChecksum() hash.Hash {
+	return sha256.New()
+}
+
+// formatChecksum returns the checksum of the step data from its hash.
+func formatChecksum(h hash.Hash) string {
+	return checksumAlgorithm + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// saveStepManifest saves the manifest of the step data.
+func (p *Pipeline) saveStepManifest(ctx context.Context, m StepManifest) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := p.b.StepProvider().CreateStep(ctx, runFile(p.cfg.RunID, manifestFile(Step(m.Step))))
+	if err != nil {
+		return fmt.Errorf("creating step %s manifest: %w", m.Step, err)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err = enc.Encode(m); err != nil {
+		// Cancel the context to discard the partially written manifest.
+		cancel()
+
+		_ = w.Close() //nolint:errcheck
+
+		return fmt.Errorf("encoding step %s manifest: %w", m.Step, err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("saving step %s manifest: %w", m.Step, err)
+	}
+
+	return nil
+}
+
+// loadStepManifest loads the manifest of the step data of the run the step data is loaded from.
+//
+// The step data saved before the manifest was introduced has no manifest, a legacy manifest is returned for it, whose
+// file is found among the files of the step compressed with any codec.
+func (p *Pipeline) loadStepManifest(ctx context.Context, step Step) (StepManifest, error) {
+	runID, err := p.loadRunID(ctx, step)
+	if err != nil {
+		return StepManifest{}, err
+	}
+
+	r, err := p.openStepFile(ctx, runFile(runID, manifestFile(step)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return StepManifest{SchemaVersion: legacyStepSchemaVersion, Step: step.String(), runID: runID}, nil
+	}
+
+	if err != nil {
+		return StepManifest{}, fmt.Errorf("opening step %s manifest: %w", step, err)
+	}
+
+	defer r.Close() //nolint:errcheck
+
+	var m StepManifest
+
+	if err = json.NewDecoder(r).Decode(&m); err != nil {
+		return StepManifest{}, fmt.Errorf("decoding step %s manifest: %w", step, err)
+	}
+
+	m.runID = runID
+
+	if m.SchemaVersion < legacyStepSchemaVersion || m.SchemaVersion > StepSchemaVersion {
+		return StepManifest{}, fmt.Errorf("step %s schema version %d is not supported, supported versions %d to %d",
+			step, m.SchemaVersion, legacyStepSchemaVersion, StepSchemaVersion)
+	}
+
+	return m, nil
+}
+
+// stepColumns returns, for each field of the header, the index of its column in the header row of the step data,
+// or -1 when the step data has no such column.
+//
+// It fails when the header row differs from the header of the manifest.
+func stepColumns(m StepManifest, header, row []string) ([]int, error) {
+	if !slices.Equal(m.Header, row) {
+		return nil, fmt.Errorf("step %s header %v differs from manifest header %v", m.Step, row, m.Header)
+	}
+
+	columns := make([]int, len(header))
+
+	for i, field := range header {
+		columns[i] = slices.Index(row, field)
+	}
+
+	return columns, nil
+}
+
+// migrateRecord returns the record with its fields in the order of the columns, the missing fields are empty.
+func migrateRecord(record []string, columns []int) []string {
+	if columns == nil {
+		return record
+	}
+
+	migrated := make([]string, len(columns))
+
+	for i, c := range columns {
+		if c >= 0 && c < len(record) {
+			migrated[i] = record[c]
+		}
+	}
+
+	return migrated
+}
+
+// verifyStepData verifies the rows and the checksum of the part of the step data read match its manifest.
+func verifyStepData(m StepManifest, part StepPart, rows int, h hash.Hash) error {
+	if m.SchemaVersion == legacyStepSchemaVersion {
+		// Nothing to verify against.
+		return nil
+	}
+
+	if rows != part.Rows {
+		return fmt.Errorf("step %s has %d rows, manifest %d rows", stepPartName(m, part), rows, part.Rows)
+	}
+
+	if sum := formatChecksum(h); sum != part.Checksum {
+		return fmt.Errorf("step %s checksum %s differs from manifest checksum %s", stepPartName(m, part), sum, part.Checksum)
+	}
+
+	return nil
+}
+
+// openStepData opens the part of the step data described by the manifest.
+func (p *Pipeline) openStepData(ctx context.Context, m StepManifest, part StepPart) (io.ReadCloser, error) {
+	if m.SchemaVersion == legacyStepSchemaVersion {
+		return p.openStep(ctx, m.runID, Step(m.Step))
+	}
+
+	return p.openStepFile(ctx, runFile(m.runID, part.File))
+}
@@ -0,0 +1,163 @@
+package internal_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal"
+	"github.com/dohernandez/horizon-blockchain-games/internal/compress"
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+	"github.com/dohernandez/horizon-blockchain-games/internal/mocks"
+	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
+)
+
+// writeCalculationStep writes the calculation step data, along with its manifest, into the dir.
+//
+// The manifest is modified by modify, when it is not nil, before it is written.
+func writeCalculationStep(t *testing.T, dir string, header []string, rows [][]string, modify func(m *internal.StepManifest)) {
+	t.Helper()
+
+	data := withHeader(t, header, encodeToBytes(t, rows, func(_ *testing.T, record []string) []string {
+		return record
+	}))
+
+	sum := sha256.Sum256(data)
+
+	m := internal.StepManifest{
+		SchemaVersion: internal.StepSchemaVersion,
+		Step:          "calculation",
+		File:          "calculation",
+		Format:        internal.CSVStepFormat,
+		Compression:   compress.None,
+		Header:        header,
+		Rows:          len(rows),
+		Checksum:      "sha256:" + hex.EncodeToString(sum[:]),
+	}
+
+	if modify != nil {
+		modify(&m)
+	}
+
+	mData, err := json.Marshal(m)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path.Join(dir, "calculation"), data, 0o600))
+	require.NoError(t, os.WriteFile(path.Join(dir, "calculation.manifest.json"), mData, 0o600))
+}
+
+// runInsertion runs the insertion step loading the calculation step data from the dir.
+func runInsertion(t *testing.T, dir string, warehouse *mocks.WarehouseProvider) error {
+	t.Helper()
+
+	b := mocks.NewPipelineBackend(t)
+	b.EXPECT().StepProvider().Return(storage.NewFileSystem(dir, "")).Maybe()
+	b.EXPECT().WarehouseProvider().Return(warehouse).Maybe()
+
+	return internal.NewPipeline(b, internal.PipelineConfig{
+		InsertStepEnabled: true,
+	}).Run(context.Background())
+}
+
+func TestPipeline_Run_step_manifest(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	writeCalculationStep(t, dir, entities.FlattenHeader(), [][]string{{"2024-04-15", "4974", "3", "3"}}, nil)
+
+	warehouse := mocks.NewWarehouseProvider(t)
+	warehouse.EXPECT().Save(mock.Anything, matchFlatten(entities.Flatten{
+		Date:        "2024-04-15",
+		ProjectID:   "4974",
+		NumTxs:      3,
+		TotalVolume: big.NewRat(3, 1),
+	})).Return(nil)
+	warehouse.EXPECT().Flush(mock.Anything).Return(nil)
+
+	require.NoError(t, runInsertion(t, dir, warehouse))
+}
+
+func TestPipeline_Run_step_manifest_migrate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	// The step data written with the columns in a different order is decoded by the column names.
+	writeCalculationStep(t, dir,
+		[]string{"project_id", "date", "total_volume_usd", "num_transactions"},
+		[][]string{{"4974", "2024-04-15", "3", "3"}},
+		nil,
+	)
+
+	warehouse := mocks.NewWarehouseProvider(t)
+	warehouse.EXPECT().Save(mock.Anything, matchFlatten(entities.Flatten{
+		Date:        "2024-04-15",
+		ProjectID:   "4974",
+		NumTxs:      3,
+		TotalVolume: big.NewRat(3, 1),
+	})).Return(nil)
+	warehouse.EXPECT().Flush(mock.Anything).Return(nil)
+
+	require.NoError(t, runInsertion(t, dir, warehouse))
+}
+
+func TestPipeline_Run_step_manifest_invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name   string
+		modify func(m *internal.StepManifest)
+		err    string
+	}{
+		{
+			name: "newer schema version",
+			modify: func(m *internal.StepManifest) {
+				m.SchemaVersion = internal.StepSchemaVersion + 1
+			},
+			err: "step calculation schema version 3 is not supported, supported versions 1 to 2",
+		},
+		{
+			name: "header",
+			modify: func(m *internal.StepManifest) {
+				m.Header = []string{"date", "project_id"}
+			},
+			err: "step calculation header [date project_id num_transactions total_volume_usd] differs from manifest header [date project_id]",
+		},
+		{
+			name: "rows",
+			modify: func(m *internal.StepManifest) {
+				m.Rows = 2
+			},
+			err: "step calculation has 1 rows, manifest 2 rows",
+		},
+		{
+			name: "checksum",
+			modify: func(m *internal.StepManifest) {
+				m.Checksum = "sha256:00"
+			},
+			err: "differs from manifest checksum sha256:00",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+
+			writeCalculationStep(t, dir, entities.FlattenHeader(), [][]string{{"2024-04-15", "4974", "3", "3"}}, tt.modify)
+
+			warehouse := mocks.NewWarehouseProvider(t)
+			warehouse.EXPECT().Save(mock.Anything, mock.Anything).Return(nil).Maybe()
+
+			require.ErrorContains(t, runInsertion(t, dir, warehouse), tt.err)
+		})
+	}
+}
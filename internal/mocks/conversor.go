@@ -4,8 +4,13 @@ package mocks
 
 import (
 	context "context"
+	big "math/big"
+
+	entities "github.com/dohernandez/horizon-blockchain-games/internal/entities"
 
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // Conversor is an autogenerated mock type for the Conversor type
@@ -21,27 +26,29 @@ func (_m *Conversor) EXPECT() *Conversor_Expecter {
 	return &Conversor_Expecter{mock: &_m.Mock}
 }
 
-// ConvertUSD provides a mock function with given fields: ctx, valueDecimal, symbol
-func (_m *Conversor) ConvertUSD(ctx context.Context, valueDecimal float64, symbol string) (float64, error) {
-	ret := _m.Called(ctx, valueDecimal, symbol)
+// ConvertUSD provides a mock function with given fields: ctx, value, currency, ts
+func (_m *Conversor) ConvertUSD(ctx context.Context, value *big.Rat, currency entities.Currency, ts time.Time) (*big.Rat, error) {
+	ret := _m.Called(ctx, value, currency, ts)
 
 	if len(ret) == 0 {
 		panic("no return value specified for ConvertUSD")
 	}
 
-	var r0 float64
+	var r0 *big.Rat
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, float64, string) (float64, error)); ok {
-		return rf(ctx, valueDecimal, symbol)
+	if rf, ok := ret.Get(0).(func(context.Context, *big.Rat, entities.Currency, time.Time) (*big.Rat, error)); ok {
+		return rf(ctx, value, currency, ts)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, float64, string) float64); ok {
-		r0 = rf(ctx, valueDecimal, symbol)
+	if rf, ok := ret.Get(0).(func(context.Context, *big.Rat, entities.Currency, time.Time) *big.Rat); ok {
+		r0 = rf(ctx, value, currency, ts)
 	} else {
-		r0 = ret.Get(0).(float64)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*big.Rat)
+		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, float64, string) error); ok {
-		r1 = rf(ctx, valueDecimal, symbol)
+	if rf, ok := ret.Get(1).(func(context.Context, *big.Rat, entities.Currency, time.Time) error); ok {
+		r1 = rf(ctx, value, currency, ts)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -56,25 +63,26 @@ type Conversor_ConvertUSD_Call struct {
 
 // ConvertUSD is a helper method to define mock.On call
 //   - ctx context.Context
-//   - valueDecimal float64
-//   - symbol string
-func (_e *Conversor_Expecter) ConvertUSD(ctx interface{}, valueDecimal interface{}, symbol interface{}) *Conversor_ConvertUSD_Call {
-	return &Conversor_ConvertUSD_Call{Call: _e.mock.On("ConvertUSD", ctx, valueDecimal, symbol)}
+//   - value *big.Rat
+//   - currency entities.Currency
+//   - ts time.Time
+func (_e *Conversor_Expecter) ConvertUSD(ctx interface{}, value interface{}, currency interface{}, ts interface{}) *Conversor_ConvertUSD_Call {
+	return &Conversor_ConvertUSD_Call{Call: _e.mock.On("ConvertUSD", ctx, value, currency, ts)}
 }
 
-func (_c *Conversor_ConvertUSD_Call) Run(run func(ctx context.Context, valueDecimal float64, symbol string)) *Conversor_ConvertUSD_Call {
+func (_c *Conversor_ConvertUSD_Call) Run(run func(ctx context.Context, value *big.Rat, currency entities.Currency, ts time.Time)) *Conversor_ConvertUSD_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(float64), args[2].(string))
+		run(args[0].(context.Context), args[1].(*big.Rat), args[2].(entities.Currency), args[3].(time.Time))
 	})
 	return _c
 }
 
-func (_c *Conversor_ConvertUSD_Call) Return(_a0 float64, _a1 error) *Conversor_ConvertUSD_Call {
+func (_c *Conversor_ConvertUSD_Call) Return(_a0 *big.Rat, _a1 error) *Conversor_ConvertUSD_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *Conversor_ConvertUSD_Call) RunAndReturn(run func(context.Context, float64, string) (float64, error)) *Conversor_ConvertUSD_Call {
+func (_c *Conversor_ConvertUSD_Call) RunAndReturn(run func(context.Context, *big.Rat, entities.Currency, time.Time) (*big.Rat, error)) *Conversor_ConvertUSD_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -5,6 +5,8 @@ package mocks
 import (
 	context "context"
 
+	io "io"
+
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -21,24 +23,24 @@ func (_m *ExtractProvider) EXPECT() *ExtractProvider_Expecter {
 	return &ExtractProvider_Expecter{mock: &_m.Mock}
 }
 
-// Load provides a mock function with given fields: ctx
-func (_m *ExtractProvider) Load(ctx context.Context) ([]byte, error) {
+// Open provides a mock function with given fields: ctx
+func (_m *ExtractProvider) Open(ctx context.Context) (io.ReadCloser, error) {
 	ret := _m.Called(ctx)
 
 	if len(ret) == 0 {
-		panic("no return value specified for Load")
+		panic("no return value specified for Open")
 	}
 
-	var r0 []byte
+	var r0 io.ReadCloser
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context) ([]byte, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context) (io.ReadCloser, error)); ok {
 		return rf(ctx)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context) []byte); ok {
+	if rf, ok := ret.Get(0).(func(context.Context) io.ReadCloser); ok {
 		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]byte)
+			r0 = ret.Get(0).(io.ReadCloser)
 		}
 	}
 
@@ -51,30 +53,30 @@ func (_m *ExtractProvider) Load(ctx context.Context) ([]byte, error) {
 	return r0, r1
 }
 
-// ExtractProvider_Load_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Load'
-type ExtractProvider_Load_Call struct {
+// ExtractProvider_Open_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Open'
+type ExtractProvider_Open_Call struct {
 	*mock.Call
 }
 
-// Load is a helper method to define mock.On call
+// Open is a helper method to define mock.On call
 //   - ctx context.Context
-func (_e *ExtractProvider_Expecter) Load(ctx interface{}) *ExtractProvider_Load_Call {
-	return &ExtractProvider_Load_Call{Call: _e.mock.On("Load", ctx)}
+func (_e *ExtractProvider_Expecter) Open(ctx interface{}) *ExtractProvider_Open_Call {
+	return &ExtractProvider_Open_Call{Call: _e.mock.On("Open", ctx)}
 }
 
-func (_c *ExtractProvider_Load_Call) Run(run func(ctx context.Context)) *ExtractProvider_Load_Call {
+func (_c *ExtractProvider_Open_Call) Run(run func(ctx context.Context)) *ExtractProvider_Open_Call {
 	_c.Call.Run(func(args mock.Arguments) {
 		run(args[0].(context.Context))
 	})
 	return _c
 }
 
-func (_c *ExtractProvider_Load_Call) Return(_a0 []byte, _a1 error) *ExtractProvider_Load_Call {
+func (_c *ExtractProvider_Open_Call) Return(_a0 io.ReadCloser, _a1 error) *ExtractProvider_Open_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *ExtractProvider_Load_Call) RunAndReturn(run func(context.Context) ([]byte, error)) *ExtractProvider_Load_Call {
+func (_c *ExtractProvider_Open_Call) RunAndReturn(run func(context.Context) (io.ReadCloser, error)) *ExtractProvider_Open_Call {
 	_c.Call.Return(run)
 	return _c
 }
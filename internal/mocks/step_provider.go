@@ -5,6 +5,8 @@ package mocks
 import (
 	context "context"
 
+	io "io"
+
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -21,24 +23,24 @@ func (_m *StepProvider) EXPECT() *StepProvider_Expecter {
 	return &StepProvider_Expecter{mock: &_m.Mock}
 }
 
-// LoadStep provides a mock function with given fields: ctx, step
-func (_m *StepProvider) LoadStep(ctx context.Context, step string) ([]byte, error) {
+// CreateStep provides a mock function with given fields: ctx, step
+func (_m *StepProvider) CreateStep(ctx context.Context, step string) (io.WriteCloser, error) {
 	ret := _m.Called(ctx, step)
 
 	if len(ret) == 0 {
-		panic("no return value specified for LoadStep")
+		panic("no return value specified for CreateStep")
 	}
 
-	var r0 []byte
+	var r0 io.WriteCloser
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) ([]byte, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, string) (io.WriteCloser, error)); ok {
 		return rf(ctx, step)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string) []byte); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, string) io.WriteCloser); ok {
 		r0 = rf(ctx, step)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]byte)
+			r0 = ret.Get(0).(io.WriteCloser)
 		}
 	}
 
@@ -51,79 +53,90 @@ func (_m *StepProvider) LoadStep(ctx context.Context, step string) ([]byte, erro
 	return r0, r1
 }
 
-// StepProvider_LoadStep_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'LoadStep'
-type StepProvider_LoadStep_Call struct {
+// StepProvider_CreateStep_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CreateStep'
+type StepProvider_CreateStep_Call struct {
 	*mock.Call
 }
 
-// LoadStep is a helper method to define mock.On call
+// CreateStep is a helper method to define mock.On call
 //   - ctx context.Context
 //   - step string
-func (_e *StepProvider_Expecter) LoadStep(ctx interface{}, step interface{}) *StepProvider_LoadStep_Call {
-	return &StepProvider_LoadStep_Call{Call: _e.mock.On("LoadStep", ctx, step)}
+func (_e *StepProvider_Expecter) CreateStep(ctx interface{}, step interface{}) *StepProvider_CreateStep_Call {
+	return &StepProvider_CreateStep_Call{Call: _e.mock.On("CreateStep", ctx, step)}
 }
 
-func (_c *StepProvider_LoadStep_Call) Run(run func(ctx context.Context, step string)) *StepProvider_LoadStep_Call {
+func (_c *StepProvider_CreateStep_Call) Run(run func(ctx context.Context, step string)) *StepProvider_CreateStep_Call {
 	_c.Call.Run(func(args mock.Arguments) {
 		run(args[0].(context.Context), args[1].(string))
 	})
 	return _c
 }
 
-func (_c *StepProvider_LoadStep_Call) Return(_a0 []byte, _a1 error) *StepProvider_LoadStep_Call {
+func (_c *StepProvider_CreateStep_Call) Return(_a0 io.WriteCloser, _a1 error) *StepProvider_CreateStep_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *StepProvider_LoadStep_Call) RunAndReturn(run func(context.Context, string) ([]byte, error)) *StepProvider_LoadStep_Call {
+func (_c *StepProvider_CreateStep_Call) RunAndReturn(run func(context.Context, string) (io.WriteCloser, error)) *StepProvider_CreateStep_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// SaveStep provides a mock function with given fields: ctx, step, data
-func (_m *StepProvider) SaveStep(ctx context.Context, step string, data []byte) error {
-	ret := _m.Called(ctx, step, data)
+// OpenStep provides a mock function with given fields: ctx, step
+func (_m *StepProvider) OpenStep(ctx context.Context, step string) (io.ReadCloser, error) {
+	ret := _m.Called(ctx, step)
 
 	if len(ret) == 0 {
-		panic("no return value specified for SaveStep")
+		panic("no return value specified for OpenStep")
 	}
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, []byte) error); ok {
-		r0 = rf(ctx, step, data)
+	var r0 io.ReadCloser
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (io.ReadCloser, error)); ok {
+		return rf(ctx, step)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) io.ReadCloser); ok {
+		r0 = rf(ctx, step)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(io.ReadCloser)
+		}
 	}
 
-	return r0
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, step)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// StepProvider_SaveStep_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SaveStep'
-type StepProvider_SaveStep_Call struct {
+// StepProvider_OpenStep_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'OpenStep'
+type StepProvider_OpenStep_Call struct {
 	*mock.Call
 }
 
-// SaveStep is a helper method to define mock.On call
+// OpenStep is a helper method to define mock.On call
 //   - ctx context.Context
 //   - step string
-//   - data []byte
-func (_e *StepProvider_Expecter) SaveStep(ctx interface{}, step interface{}, data interface{}) *StepProvider_SaveStep_Call {
-	return &StepProvider_SaveStep_Call{Call: _e.mock.On("SaveStep", ctx, step, data)}
+func (_e *StepProvider_Expecter) OpenStep(ctx interface{}, step interface{}) *StepProvider_OpenStep_Call {
+	return &StepProvider_OpenStep_Call{Call: _e.mock.On("OpenStep", ctx, step)}
 }
 
-func (_c *StepProvider_SaveStep_Call) Run(run func(ctx context.Context, step string, data []byte)) *StepProvider_SaveStep_Call {
+func (_c *StepProvider_OpenStep_Call) Run(run func(ctx context.Context, step string)) *StepProvider_OpenStep_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(string), args[2].([]byte))
+		run(args[0].(context.Context), args[1].(string))
 	})
 	return _c
 }
 
-func (_c *StepProvider_SaveStep_Call) Return(_a0 error) *StepProvider_SaveStep_Call {
-	_c.Call.Return(_a0)
+func (_c *StepProvider_OpenStep_Call) Return(_a0 io.ReadCloser, _a1 error) *StepProvider_OpenStep_Call {
+	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *StepProvider_SaveStep_Call) RunAndReturn(run func(context.Context, string, []byte) error) *StepProvider_SaveStep_Call {
+func (_c *StepProvider_OpenStep_Call) RunAndReturn(run func(context.Context, string) (io.ReadCloser, error)) *StepProvider_OpenStep_Call {
 	_c.Call.Return(run)
 	return _c
 }
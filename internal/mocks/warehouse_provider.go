@@ -23,6 +23,52 @@ func (_m *WarehouseProvider) EXPECT() *WarehouseProvider_Expecter {
 	return &WarehouseProvider_Expecter{mock: &_m.Mock}
 }
 
+// Flush provides a mock function with given fields: ctx
+func (_m *WarehouseProvider) Flush(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Flush")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// WarehouseProvider_Flush_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Flush'
+type WarehouseProvider_Flush_Call struct {
+	*mock.Call
+}
+
+// Flush is a helper method to define mock.On call
+//   - ctx context.Context
+func (_e *WarehouseProvider_Expecter) Flush(ctx interface{}) *WarehouseProvider_Flush_Call {
+	return &WarehouseProvider_Flush_Call{Call: _e.mock.On("Flush", ctx)}
+}
+
+func (_c *WarehouseProvider_Flush_Call) Run(run func(ctx context.Context)) *WarehouseProvider_Flush_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context))
+	})
+	return _c
+}
+
+func (_c *WarehouseProvider_Flush_Call) Return(_a0 error) *WarehouseProvider_Flush_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *WarehouseProvider_Flush_Call) RunAndReturn(run func(context.Context) error) *WarehouseProvider_Flush_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Save provides a mock function with given fields: ctx, flatten
 func (_m *WarehouseProvider) Save(ctx context.Context, flatten entities.Flatten) error {
 	ret := _m.Called(ctx, flatten)
@@ -1,15 +1,20 @@
 package internal
 
 import (
-	"bytes"
 	"context"
-	"encoding/csv"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"io/fs"
+	"maps"
+	"math/big"
 	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
+	"github.com/dohernandez/horizon-blockchain-games/internal/compress"
 	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
 )
 
@@ -29,14 +34,42 @@ const (
 	extractionStep Step = "extraction"
 	// calculationStep is the calculation step.
 	calculationStep Step = "calculation"
+	// rejectedStep is the step holding the rows rejected by the extraction step.
+	rejectedStep Step = "rejected"
+)
+
+// ErrorPolicy is the type to represent the policy applied to the malformed rows in the extraction step.
+type ErrorPolicy string
+
+// String returns the string representation of the error policy.
+func (e ErrorPolicy) String() string {
+	return string(e)
+}
+
+const (
+	// FailFastPolicy fails the extraction on the first malformed row.
+	FailFastPolicy ErrorPolicy = "fail-fast"
+	// SkipPolicy skips the malformed rows.
+	SkipPolicy ErrorPolicy = "skip"
+	// DeadLetterPolicy skips the malformed rows and saves them, along with their line number and the reason,
+	// into the rejected step data.
+	DeadLetterPolicy ErrorPolicy = "dead-letter"
 )
 
 //go:generate mockery --name=StepProvider --outpkg=mocks --output=mocks --filename=step_provider.go --with-expecter
 
 // StepProvider is the interface that provides the ability to load and save the step data.
 type StepProvider interface {
-	LoadStep(ctx context.Context, step string) ([]byte, error)
-	SaveStep(ctx context.Context, step string, data []byte) error
+	// OpenStep opens the step data to be read as a stream.
+	//
+	// The data is read as it is stored, the pipeline decompresses the gzip or zstd compressed step data.
+	// The caller is responsible for closing the returned reader.
+	OpenStep(ctx context.Context, step string) (io.ReadCloser, error)
+	// CreateStep creates the step data to be written as a stream.
+	//
+	// The data is persisted when the returned writer is closed. When the context is canceled before closing the writer,
+	// the data written so far is discarded, and the previous step data, if any, is kept.
+	CreateStep(ctx context.Context, step string) (io.WriteCloser, error)
 }
 
 //go:generate mockery --name=PipelineBackend --outpkg=mocks --output=mocks --filename=pipeline_backend.go --with-expecter
@@ -50,6 +83,16 @@ type PipelineBackend interface {
 	StepProvider() StepProvider
 }
 
+// GroupKeyFunc is the function type to get the key used to aggregate the flatten entities in the calculation step.
+//
+// Flatten entities with the same key are aggregated into one.
+type GroupKeyFunc func(f entities.Flatten) string
+
+// DateProjectGroupKey groups the flatten entities by date and project id.
+func DateProjectGroupKey(f entities.Flatten) string {
+	return f.Date + "|" + f.ProjectID
+}
+
 // PipelineConfig holds the configuration for the pipeline.
 type PipelineConfig struct {
 	// Workers is the number of workers to step's pipeline.
@@ -57,6 +100,66 @@ type PipelineConfig struct {
 	// It is used for the calculation steps.
 	Workers int
 
+	// GroupKey is the function to get the key used to aggregate the results of the calculation step.
+	// If it is nil, it will be set to DateProjectGroupKey.
+	GroupKey GroupKeyFunc
+
+	// ErrorPolicy is the policy applied to the malformed rows in the extraction step.
+	// If it is empty, it will be set to FailFastPolicy.
+	ErrorPolicy ErrorPolicy
+	// MaxRejects is the number of rows that can be rejected before the extraction step fails.
+	// If it is 0, there is no limit. It is used with SkipPolicy and DeadLetterPolicy.
+	MaxRejects int
+
+	// StepFormat is the format of the step data, CSVStepFormat, JSONLStepFormat, ParquetStepFormat or GobStepFormat.
+	// If it is empty, it will be set to CSVStepFormat. The rejected step data is always saved as CSV.
+	StepFormat string
+	// StepCompression is the codec to compress the step data, compress.None, compress.Gzip or compress.Zstd.
+	// If it is empty, it will be set to compress.None. The step data is saved into <step>.<format><extension>,
+	// e.g. extraction.parquet or extraction.csv.zst, except the uncompressed CSV step data saved into <step>.
+	// The step data is loaded regardless of the format and the codec it was saved with.
+	StepCompression string
+
+	// ProducerVersion is the version of the application recorded into the manifest of the step data.
+	ProducerVersion string
+	// SourceFile is the file the data is extracted from, recorded into the manifest of the step data.
+	SourceFile string
+
+	// RunID is the identifier of the run, the step data saved by the run is stored under <run-id>/, e.g.
+	// <run-id>/extraction, so the runs sharing the step provider do not overwrite each other. Once the run completes,
+	// the latest file points the steps whose data is saved to the run. If it is empty, it will be set by NewRunID.
+	RunID string
+	// FromRunID is the identifier of the run the step data is loaded from, when the step producing it is not enabled.
+	// If it is empty, the step data is loaded from the latest run of the step, and when no run saved the step data, from
+	// the step data stored at the root, saved before the run identifiers were introduced.
+	FromRunID string
+	// CheckpointRows is the number of rows read by the extraction step between checkpoints. If it is 0, or RunID is
+	// empty, the run is not checkpointed. Only the extraction step data is checkpointed, so the run is checkpointed
+	// when the extraction step is enabled and the calculation step is not. The step data is saved into parts, one per
+	// checkpoint, and the files are read one at a time regardless of ExtractConcurrency.
+	CheckpointRows int
+	// Resume resumes the run RunID from its last checkpoint, saved into <run-id>/checkpoint.json, skipping the rows of
+	// the input read up to it, so the transactions are not duplicated. The rejected step data saved before resuming is
+	// replaced.
+	Resume bool
+
+	// LockTTL is the time to live of the lease of the lock of the step data, acquired by the run when the step provider
+	// supports locking, so the runs sharing the step data do not run concurrently, and renewed while the run is running.
+	// If it is 0, it will be set to DefaultLockTTL. The run fails when it is negative.
+	LockTTL time.Duration
+	// ForceUnlock releases the lock held by another run before acquiring it, e.g. the lock of a crashed run which did
+	// not expire yet.
+	ForceUnlock bool
+
+	// ExtractConcurrency is the number of files read concurrently in the extraction step, when the extract provider
+	// lists several files. If it is 0, it will be set to 4.
+	ExtractConcurrency int
+
+	// Events is the event registry used to calculate the volume of the transactions.
+	// If it is nil, it will be set to DefaultEventRegistry.
+	// The transactions whose event is not in the registry are skipped and counted in the run report.
+	Events EventRegistry
+
 	// ExtractStepEnabled enable the extraction step.
 	ExtractStepEnabled bool
 	// CalculateStepEnabled enable the calculation step.
@@ -65,11 +168,22 @@ type PipelineConfig struct {
 	InsertStepEnabled bool
 }
 
+// Report holds the summary of a pipeline run.
+type Report struct {
+	// UnmatchedEvents is the number of transactions skipped per event because the event is not in the event registry.
+	UnmatchedEvents map[string]int
+	// FileRows is the number of rows read per file by the extraction step, when the extract provider lists the files.
+	FileRows map[string]int
+}
+
 // Pipeline is the struct that holds the pipeline configuration and the backend dependencies.
 type Pipeline struct {
 	b PipelineBackend
 
 	cfg PipelineConfig
+
+	report   Report
+	reportSm sync.Mutex
 }
 
 // NewPipeline creates a new pipeline with the given backend dependencies and configuration.
@@ -78,6 +192,34 @@ func NewPipeline(b PipelineBackend, cfg PipelineConfig) *Pipeline {
 		cfg.Workers = 1
 	}
 
+	if cfg.GroupKey == nil {
+		cfg.GroupKey = DateProjectGroupKey
+	}
+
+	if cfg.ErrorPolicy == "" {
+		cfg.ErrorPolicy = FailFastPolicy
+	}
+
+	if cfg.Events == nil {
+		cfg.Events = DefaultEventRegistry()
+	}
+
+	if cfg.StepFormat == "" {
+		cfg.StepFormat = CSVStepFormat
+	}
+
+	if cfg.StepCompression == "" {
+		cfg.StepCompression = compress.None
+	}
+
+	if cfg.RunID == "" {
+		cfg.RunID = NewRunID()
+	}
+
+	if cfg.LockTTL == 0 {
+		cfg.LockTTL = DefaultLockTTL
+	}
+
 	return &Pipeline{b: b, cfg: cfg}
 }
 
@@ -91,8 +233,34 @@ func NewPipeline(b PipelineBackend, cfg PipelineConfig) *Pipeline {
 // The calculation step is responsible for calculating the total volume of the transactions in USD
 // and send the flatten entities to the insertion step.
 // The insertion step is responsible for saving the flatten entities into the target.
-func (p *Pipeline) Run(ctx context.Context) error {
-	g, ctx := errgroup.WithContext(ctx)
+//
+// The run holds the lock of the step data while it is running, when the step provider supports locking, and fails when
+// the lock is held by another run, or when it is lost.
+func (p *Pipeline) Run(ctx context.Context) (err error) {
+	p.reportSm.Lock()
+	p.report = Report{
+		UnmatchedEvents: make(map[string]int),
+		FileRows:        make(map[string]int),
+	}
+	p.reportSm.Unlock()
+
+	ctx, release, err := p.acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if rerr := release(); err == nil {
+			err = rerr
+		}
+	}()
+
+	cp, err := p.startCheckpoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	g, gCtx := errgroup.WithContext(ctx)
 
 	var (
 		transactions chan entities.Transaction
@@ -100,32 +268,108 @@ func (p *Pipeline) Run(ctx context.Context) error {
 	)
 
 	if p.cfg.ExtractStepEnabled {
-		transactions = p.runExtraction(ctx, g)
+		transactions = p.runExtraction(gCtx, g, cp)
 	}
 
 	if p.cfg.CalculateStepEnabled {
-		flattens = p.runCalculation(ctx, g, transactions)
+		flattens = p.runCalculation(gCtx, g, transactions)
 	}
 
 	if p.cfg.InsertStepEnabled {
-		p.runInsertion(ctx, g, flattens)
+		p.runInsertion(gCtx, g, flattens)
 	}
 
 	if err := g.Wait(); err != nil {
+		// The run is canceled with the cause when the lock is lost.
+		if cause := context.Cause(ctx); cause != nil {
+			return cause
+		}
+
 		return err
 	}
 
-	return nil
+	// The group context is canceled once the steps are done.
+	return p.saveLatestRuns(ctx, p.savedSteps()...)
+}
+
+// RunID returns the identifier of the run.
+func (p *Pipeline) RunID() string {
+	return p.cfg.RunID
+}
+
+// Report returns the summary of the last pipeline run.
+func (p *Pipeline) Report() Report {
+	p.reportSm.Lock()
+	defer p.reportSm.Unlock()
+
+	r := Report{
+		UnmatchedEvents: maps.Clone(p.report.UnmatchedEvents),
+		FileRows:        maps.Clone(p.report.FileRows),
+	}
+
+	return r
+}
+
+// countUnmatchedEvent counts the transaction skipped because its event is not in the event registry.
+func (p *Pipeline) countUnmatchedEvent(event string) {
+	p.reportSm.Lock()
+	defer p.reportSm.Unlock()
+
+	p.report.UnmatchedEvents[event]++
+}
+
+// countFileRows counts the rows read from the file by the extraction step.
+func (p *Pipeline) countFileRows(file string, rows int) {
+	p.reportSm.Lock()
+	defer p.reportSm.Unlock()
+
+	p.report.FileRows[file] += rows
 }
 
 // runExtraction runs the extraction step.
-func (p *Pipeline) runExtraction(ctx context.Context, g *errgroup.Group) chan entities.Transaction {
+//
+// When the run is checkpointed, cp is the checkpoint the run starts from, otherwise it is nil.
+func (p *Pipeline) runExtraction(ctx context.Context, g *errgroup.Group, cp *Checkpoint) chan entities.Transaction {
 	transactions := make(chan entities.Transaction, chanCap)
 
+	var rejected chan encoder
+
+	opts := []ExtractOption{
+		WithConcurrency(p.cfg.ExtractConcurrency),
+		WithFileRows(p.countFileRows),
+	}
+
+	switch p.cfg.ErrorPolicy {
+	case FailFastPolicy:
+		// Default behavior of the extraction.
+	case SkipPolicy:
+		opts = append(opts, WithRejects(p.cfg.MaxRejects, nil))
+	case DeadLetterPolicy:
+		rejected = make(chan encoder, chanCap)
+
+		opts = append(opts, WithRejects(p.cfg.MaxRejects, p.saveRejectedStepData(ctx, g, rejected)))
+	}
+
+	var requests chan checkpointRequest
+
+	if cp != nil {
+		requests = make(chan checkpointRequest)
+
+		opts = append(opts, WithCheckpoint(p.cfg.CheckpointRows, checkpointFunc(requests)))
+
+		if cp.Position != (ExtractPosition{}) {
+			opts = append(opts, WithResume(cp.Position))
+		}
+	}
+
 	g.Go(func() error {
 		defer close(transactions)
 
-		err := Extract(ctx, p.b.ExtractProvider(), transactions)
+		if rejected != nil {
+			defer close(rejected)
+		}
+
+		err := Extract(ctx, p.b.ExtractProvider(), transactions, opts...)
 		if err != nil {
 			return err
 		}
@@ -134,7 +378,10 @@ func (p *Pipeline) runExtraction(ctx context.Context, g *errgroup.Group) chan en
 	})
 
 	// Since CalculateStepEnabled is not enable, there is a need to save the step data.
-	if !p.cfg.CalculateStepEnabled {
+	switch {
+	case cp != nil:
+		p.saveCheckpointedStepData(ctx, g, transactions, requests, cp)
+	case !p.cfg.CalculateStepEnabled:
 		p.saveExtractionStepData(ctx, g, transactions)
 	}
 
@@ -147,7 +394,7 @@ func (p *Pipeline) runExtraction(ctx context.Context, g *errgroup.Group) chan en
 func (p *Pipeline) saveExtractionStepData(ctx context.Context, g *errgroup.Group, transactions <-chan entities.Transaction) {
 	data := make(chan encoder, chanCap)
 
-	p.saveStepData(ctx, g, extractionStep, data)
+	p.saveStepData(ctx, g, extractionStep, transactionSchema(), data)
 
 	g.Go(func() error {
 		defer close(data)
@@ -164,50 +411,256 @@ func (p *Pipeline) saveExtractionStepData(ctx context.Context, g *errgroup.Group
 	})
 }
 
+// saveRejectedStepData saves the rows rejected by the extraction step.
+//
+// It returns the function to send the rejected rows to be saved. The rejected rows are saved even when the pipeline
+// fails, e.g. because the max rejects is exceeded, so they can be inspected.
+func (p *Pipeline) saveRejectedStepData(ctx context.Context, g *errgroup.Group, rejected chan encoder) RejectFunc {
+	p.saveStepData(context.WithoutCancel(ctx), g, rejectedStep, rejectionSchema(), rejected)
+
+	return func(ctx context.Context, r entities.Rejection) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case rejected <- r:
+			return nil
+		}
+	}
+}
+
 // encoder is the interface that provides the ability to encode the data.
 type encoder interface {
 	Encode() []string
 }
 
 // saveStepData saves the step data.
-func (p *Pipeline) saveStepData(ctx context.Context, g *errgroup.Group, step Step, data <-chan encoder) {
+//
+// The data is written to the step provider as it is produced, so the memory used is bounded regardless of the data size.
+// The step data is written in the configured format, the CSV step data starts with the header row, and it is described
+// by the manifest saved once the step data is persisted.
+func (p *Pipeline) saveStepData(ctx context.Context, g *errgroup.Group, step Step, schema stepSchema, data <-chan encoder) {
 	g.Go(func() error {
-		// Create a bytes.Buffer to store the transaction CSV data.
-		var buf bytes.Buffer
-		writer := csv.NewWriter(&buf)
+		m, err := p.writeStepData(ctx, step, schema, data)
+		if err != nil {
+			return err
+		}
 
-	loop:
-		for {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case d, ok := <-data:
-				if !ok {
-					break loop
-				}
+		return p.saveStepManifest(ctx, m)
+	})
+}
 
-				err := writer.Write(d.Encode())
-				if err != nil {
-					return err
-				}
+// writeStepData writes the step data, and returns its manifest.
+func (p *Pipeline) writeStepData(ctx context.Context, step Step, schema stepSchema, data <-chan encoder) (StepManifest, error) {
+	format := schema.format(p.cfg.StepFormat)
+
+	m := StepManifest{
+		SchemaVersion:   StepSchemaVersion,
+		Step:            step.String(),
+		File:            stepFile(step, format, p.cfg.StepCompression),
+		Format:          format,
+		Compression:     p.cfg.StepCompression,
+		Header:          schema.header,
+		ProducerVersion: p.cfg.ProducerVersion,
+		SourceFile:      p.cfg.SourceFile,
+	}
+
+	w, err := p.createStepFile(ctx, m.File, format, m.Compression, schema)
+	if err != nil {
+		return m, err
+	}
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			w.Abort()
+
+			return m, ctx.Err()
+		case d, ok := <-data:
+			if !ok {
+				break loop
+			}
+
+			if err := w.Write(d); err != nil {
+				w.Abort()
+
+				return m, err
 			}
 		}
+	}
 
-		writer.Flush()
+	part, err := w.Close()
+	if err != nil {
+		return m, err
+	}
 
-		if err := writer.Error(); err != nil {
-			return err
+	m.Rows = part.Rows
+	m.Checksum = part.Checksum
+	m.CreatedAt = time.Now().UTC()
+
+	return m, nil
+}
+
+// stepFileWriter writes the entities of the step data into a step file.
+type stepFileWriter struct {
+	cancel context.CancelFunc
+
+	file       io.WriteCloser
+	compressor io.WriteCloser
+	checksum   hash.Hash
+	writer     stepWriter
+
+	part StepPart
+}
+
+// createStepFile creates the step file of the run to write the entities in the format, compressed with the codec.
+func (p *Pipeline) createStepFile(ctx context.Context, file, format, codec string, schema stepSchema) (*stepFileWriter, error) {
+	// The context is canceled before closing the step file when it is aborted, so the partial data is discarded.
+	ctx, cancel := context.WithCancel(ctx)
+
+	w := &stepFileWriter{
+		cancel: cancel,
+		// The checksum is calculated over the uncompressed data.
+		checksum: newChecksum(),
+		part:     StepPart{File: file},
+	}
+
+	var err error
+
+	w.file, err = p.b.StepProvider().CreateStep(ctx, runFile(p.cfg.RunID, file))
+	if err != nil {
+		cancel()
+
+		return nil, err
+	}
+
+	w.compressor, err = compress.NewWriter(w.file, codec)
+	if err != nil {
+		w.Abort()
+
+		return nil, err
+	}
+
+	w.writer, err = newStepWriter(io.MultiWriter(w.compressor, w.checksum), format, schema)
+	if err != nil {
+		w.Abort()
+
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write writes the entity into the step file.
+func (w *stepFileWriter) Write(d encoder) error {
+	if err := w.writer.Write(d); err != nil {
+		return err
+	}
+
+	w.part.Rows++
+
+	return nil
+}
+
+// Close persists the step file, and returns the part of the step data written.
+func (w *stepFileWriter) Close() (StepPart, error) {
+	defer w.cancel()
+
+	if err := w.writer.Close(); err != nil {
+		w.Abort()
+
+		return w.part, err
+	}
+
+	if err := w.compressor.Close(); err != nil {
+		w.Abort()
+
+		return w.part, err
+	}
+
+	if err := w.file.Close(); err != nil {
+		return w.part, err
+	}
+
+	w.part.Checksum = formatChecksum(w.checksum)
+
+	return w.part, nil
+}
+
+// Abort discards the step file.
+func (w *stepFileWriter) Abort() {
+	w.cancel()
+
+	_ = w.file.Close() //nolint:errcheck
+}
+
+// stepFile returns the file of the step data in the format compressed with the codec.
+//
+// The uncompressed CSV step data is saved into the file named as the step.
+func stepFile(step Step, format, codec string) string {
+	if format == CSVStepFormat && codec == compress.None {
+		return step.String()
+	}
+
+	return step.String() + "." + format + compress.Extension(codec)
+}
+
+// openStepFile opens the file of the step data to be read as a stream, decompressing the data of the gzip or zstd
+// compressed file, the codec is detected from the content regardless of the extension.
+//
+// When the file does not exist, the error wraps fs.ErrNotExist.
+func (p *Pipeline) openStepFile(ctx context.Context, file string) (io.ReadCloser, error) {
+	r, err := p.b.StepProvider().OpenStep(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := compress.NewReader(r)
+	if err != nil {
+		_ = r.Close() //nolint:errcheck
+
+		return nil, fmt.Errorf("opening %s: %w", file, err)
+	}
+
+	return data, nil
+}
+
+// openStep opens the CSV step data of the run, looking for the file of the configured codec first, and then for the
+// files of the other codecs, so the step data is loaded regardless of the codec it was saved with.
+func (p *Pipeline) openStep(ctx context.Context, runID string, step Step) (io.ReadCloser, error) {
+	codecs := []string{p.cfg.StepCompression}
+
+	for _, codec := range compress.Codecs() {
+		if codec != p.cfg.StepCompression {
+			codecs = append(codecs, codec)
 		}
+	}
 
-		return p.b.StepProvider().SaveStep(ctx, step.String(), buf.Bytes())
-	})
+	var firstErr error
+
+	for _, codec := range codecs {
+		data, err := p.openStepFile(ctx, runFile(runID, stepFile(step, CSVStepFormat, codec)))
+		if err == nil {
+			return data, nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return nil, firstErr
 }
 
 // runCalculation runs the calculation step.
 //
 // It runs the calculation step in parallel using the number of workers defined in the configuration.
 // Along with the calculation step, it runs the flatten step in parallel to flatten the results into a map,
-// and then send the flatten entities to the insertion step.
+// grouped by the key returned by the configured GroupKey, and then send the flatten entities to the insertion step.
 func (p *Pipeline) runCalculation(ctx context.Context, g *errgroup.Group, transactions chan entities.Transaction) chan entities.Flatten {
 	// Since ExtractStepEnabled is not enable, there is a need to load the step data.
 	if !p.cfg.ExtractStepEnabled {
@@ -231,7 +684,10 @@ func (p *Pipeline) runCalculation(ctx context.Context, g *errgroup.Group, transa
 				fsSm.Unlock()
 			}()
 
-			err := Calculate(ctx, p.b.Conversor(), transactions, fs)
+			err := Calculate(ctx, p.b.Conversor(), transactions, fs,
+				WithEventRegistry(p.cfg.Events),
+				WithUnmatchedEvents(p.countUnmatchedEvent),
+			)
 			if err != nil {
 				return err
 			}
@@ -265,18 +721,19 @@ func (p *Pipeline) runCalculation(ctx context.Context, g *errgroup.Group, transa
 
 				mfsSm.Lock()
 
-				date := f.Date
+				key := p.cfg.GroupKey(f)
 
-				if _, ok := mfs[date]; !ok {
-					mfs[date] = &entities.Flatten{
-						Date:      date,
-						ProjectID: f.ProjectID,
+				if _, ok := mfs[key]; !ok {
+					mfs[key] = &entities.Flatten{
+						Date:        f.Date,
+						ProjectID:   f.ProjectID,
+						TotalVolume: new(big.Rat),
 					}
 				}
 
-				mfs[date].NumTxs++
+				mfs[key].NumTxs++
 
-				mfs[date].TotalVolume += f.TotalVolume
+				mfs[key].TotalVolume.Add(mfs[key].TotalVolume, f.TotalVolume)
 
 				mfsSm.Unlock()
 			}
@@ -301,73 +758,61 @@ func (p *Pipeline) runCalculation(ctx context.Context, g *errgroup.Group, transa
 //
 // It loads the extraction step data when the extraction step is not enabled.
 func (p *Pipeline) loadExtractionStepData(ctx context.Context, g *errgroup.Group) chan entities.Transaction {
-	data := p.loadDataStep(ctx, g, extractionStep, func(v []string) (any, error) {
-		var t entities.Transaction
-
-		err := t.Decode(v)
-		if err != nil {
-			return nil, err
-		}
-
-		return t, nil
-	})
+	data := p.loadDataStep(ctx, g, extractionStep, transactionSchema())
 
 	transactions := make(chan entities.Transaction, chanCap)
 
 	g.Go(func() error {
 		defer close(transactions)
 
-		for d := range data {
-			if ctx.Err() != nil {
+		for {
+			select {
+			case <-ctx.Done():
 				return ctx.Err()
-			}
+			case d, ok := <-data:
+				if !ok {
+					return nil
+				}
 
-			transactions <- d.(entities.Transaction)
+				transactions <- d.(entities.Transaction)
+			}
 		}
-
-		return nil
 	})
 
 	return transactions
 }
 
-// decoderFunc is the function type to decode the data.
-type decoderFunc func([]string) (any, error)
-
 // loadDataStep loads the data step.
-func (p *Pipeline) loadDataStep(ctx context.Context, g *errgroup.Group, step Step, decoder decoderFunc) chan any {
+//
+// The step data is read in the format it was saved with, and validated against its manifest: the header row of the
+// CSV step data must match the manifest header, and the number of rows and the checksum are verified once the step data
+// is read, failing the step when they differ. The columns of the CSV step data are decoded in the order of the schema
+// header, and the fields of the typed step formats by name, so the step data written with the fields in a different
+// order, or missing the fields added later, is migrated. The step data without manifest is decoded as it is.
+//
+// The step data saved into parts, by a checkpointed run, is loaded part by part, each part verified on its own.
+//
+// The returned channel is closed only when the step data is loaded successfully, so the next steps do not take the data
+// loaded as complete when it fails.
+func (p *Pipeline) loadDataStep(ctx context.Context, g *errgroup.Group, step Step, schema stepSchema) chan any {
 	data := make(chan any, chanCap)
 
-	g.Go(func() error {
-		defer close(data)
+	g.Go(func() (err error) {
+		defer func() {
+			if err == nil {
+				close(data)
+			}
+		}()
 
-		dataLoaded, err := p.b.StepProvider().LoadStep(ctx, step.String())
+		m, err := p.loadStepManifest(ctx, step)
 		if err != nil {
 			return err
 		}
 
-		reader := csv.NewReader(bytes.NewReader(dataLoaded))
-
-		for {
-			if ctx.Err() != nil {
-				return ctx.Err()
-			}
-
-			record, err := reader.Read()
-			if errors.Is(err, io.EOF) {
-				break // End.
-			}
-
-			if err != nil {
+		for _, part := range stepParts(m) {
+			if err := p.loadStepPart(ctx, m, part, schema, data); err != nil {
 				return err
 			}
-
-			d, err := decoder(record)
-			if err != nil {
-				return err
-			}
-
-			data <- d
 		}
 
 		return nil
@@ -376,13 +821,59 @@ func (p *Pipeline) loadDataStep(ctx context.Context, g *errgroup.Group, step Ste
 	return data
 }
 
+// loadStepPart loads the part of the step data described by the manifest into the data channel.
+func (p *Pipeline) loadStepPart(ctx context.Context, m StepManifest, part StepPart, schema stepSchema, data chan<- any) error {
+	dataLoaded, err := p.openStepData(ctx, m, part)
+	if err != nil {
+		return err
+	}
+
+	defer dataLoaded.Close() //nolint:errcheck
+
+	checksum := newChecksum()
+
+	reader, err := newStepReader(io.TeeReader(dataLoaded, checksum), m, schema)
+	if err != nil {
+		return err
+	}
+
+	defer reader.Close() //nolint:errcheck
+
+	var rows int
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		d, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break // End.
+		}
+
+		rows++
+
+		if err != nil {
+			return fmt.Errorf("step %s row %d: %w", stepPartName(m, part), rows, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case data <- d:
+		}
+	}
+
+	return verifyStepData(m, part, rows, checksum)
+}
+
 // saveCalculationStepData saves the calculation step data.
 //
 // The data is saved when the insertion step is not enabled.
 func (p *Pipeline) saveCalculationStepData(ctx context.Context, g *errgroup.Group, flattens <-chan entities.Flatten) {
 	data := make(chan encoder, chanCap)
 
-	p.saveStepData(ctx, g, calculationStep, data)
+	p.saveStepData(ctx, g, calculationStep, flattenSchema(), data)
 
 	g.Go(func() error {
 		defer close(data)
@@ -409,7 +900,12 @@ func (p *Pipeline) runInsertion(ctx context.Context, g *errgroup.Group, flattens
 				return ctx.Err()
 			case f, ok := <-flattens:
 				if !ok {
-					return nil
+					// The flatten entities are not flushed when the previous steps failed.
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+
+					return p.b.WarehouseProvider().Flush(ctx)
 				}
 
 				err := Insert(ctx, p.b.WarehouseProvider(), f)
@@ -425,31 +921,25 @@ func (p *Pipeline) runInsertion(ctx context.Context, g *errgroup.Group, flattens
 //
 // It loads the calculation step data when the calculation step is not enabled.
 func (p *Pipeline) loadCalculationStepData(ctx context.Context, g *errgroup.Group) chan entities.Flatten {
-	data := p.loadDataStep(ctx, g, calculationStep, func(v []string) (any, error) {
-		var f entities.Flatten
-
-		err := f.Decode(v)
-		if err != nil {
-			return nil, err
-		}
-
-		return f, nil
-	})
+	data := p.loadDataStep(ctx, g, calculationStep, flattenSchema())
 
 	flattens := make(chan entities.Flatten, chanCap)
 
 	g.Go(func() error {
 		defer close(flattens)
 
-		for d := range data {
-			if ctx.Err() != nil {
+		for {
+			select {
+			case <-ctx.Done():
 				return ctx.Err()
-			}
+			case d, ok := <-data:
+				if !ok {
+					return nil
+				}
 
-			flattens <- d.(entities.Flatten)
+				flattens <- d.(entities.Flatten)
+			}
 		}
-
-		return nil
 	})
 
 	return flattens
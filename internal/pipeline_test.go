@@ -3,17 +3,28 @@ package internal_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"math/big"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/dohernandez/horizon-blockchain-games/internal"
+	"github.com/dohernandez/horizon-blockchain-games/internal/compress"
 	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
 	"github.com/dohernandez/horizon-blockchain-games/internal/mocks"
+	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
 )
 
 func TestPipeline_Run_all_in_one(t *testing.T) {
@@ -34,7 +45,7 @@ func TestPipeline_Run_all_in_one(t *testing.T) {
 		return record
 	})
 
-	provider.EXPECT().Load(mock.Anything).Return(extBytes, nil)
+	provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(extBytes)), nil)
 
 	// Mock Conversor.
 	conversor := mocks.NewConversor(t)
@@ -44,17 +55,18 @@ func TestPipeline_Run_all_in_one(t *testing.T) {
 		transaction, err := entities.TransactionNormalize(record)
 		require.NoError(t, err)
 
-		conversor.EXPECT().ConvertUSD(mock.Anything, transaction.CurrencyValueDecimal, transaction.CurrencySymbol).Return(1.0, nil)
+		conversor.EXPECT().ConvertUSD(mock.Anything, transaction.CurrencyValue(), transaction.Currency(), transaction.TS).Return(big.NewRat(1, 1), nil)
 	}
 
 	// Mock WarehouseProvider.
 	storage := mocks.NewWarehouseProvider(t)
-	storage.EXPECT().Save(mock.Anything, entities.Flatten{
+	storage.EXPECT().Save(mock.Anything, matchFlatten(entities.Flatten{
 		Date:        "2024-04-15",
 		ProjectID:   "4974",
 		NumTxs:      3,
-		TotalVolume: 3.00,
-	}).Return(nil)
+		TotalVolume: big.NewRat(3, 1),
+	})).Return(nil)
+	storage.EXPECT().Flush(mock.Anything).Return(nil)
 
 	// Mock PipelineBackend.
 	b := mocks.NewPipelineBackend(t)
@@ -74,6 +86,211 @@ func TestPipeline_Run_all_in_one(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestPipeline_Run_group_by_date_project(t *testing.T) {
+	t.Parallel()
+
+	// Load sample data with limit 2 to load 1 data line since offset is -1 which means load the header line.
+	dataSample, err := internal.LoadSampleData(2, -1)
+	require.NoError(t, err)
+
+	header, sample := dataSample[0], dataSample[1]
+
+	// record returns a copy of the sample record with the given timestamp, event and project id.
+	record := func(ts, event, projectID string) []string {
+		r := make([]string, len(sample))
+		copy(r, sample)
+
+		r[1] = ts
+		r[2] = event
+		r[3] = projectID
+
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		records  [][]string
+		expected []entities.Flatten
+	}{
+		{
+			name: "single project single day",
+			records: [][]string{
+				record("2024-04-15 02:15:07.167", "BUY_ITEMS", "4974"),
+				record("2024-04-15 10:15:07.167", "BUY_ITEMS", "4974"),
+			},
+			expected: []entities.Flatten{
+				{Date: "2024-04-15", ProjectID: "4974", NumTxs: 2, TotalVolume: big.NewRat(2, 1)},
+			},
+		},
+		{
+			name: "multiple projects same day",
+			records: [][]string{
+				record("2024-04-15 02:15:07.167", "BUY_ITEMS", "4974"),
+				record("2024-04-15 03:15:07.167", "BUY_ITEMS", "1660"),
+				record("2024-04-15 04:15:07.167", "SELL_ITEMS", "4974"),
+				record("2024-04-15 05:15:07.167", "BUY_ITEMS", "1660"),
+				record("2024-04-15 06:15:07.167", "BUY_ITEMS", "0"),
+			},
+			expected: []entities.Flatten{
+				{Date: "2024-04-15", ProjectID: "4974", NumTxs: 2, TotalVolume: big.NewRat(0, 1)},
+				{Date: "2024-04-15", ProjectID: "1660", NumTxs: 2, TotalVolume: big.NewRat(2, 1)},
+				{Date: "2024-04-15", ProjectID: "0", NumTxs: 1, TotalVolume: big.NewRat(1, 1)},
+			},
+		},
+		{
+			name: "multiple projects multiple days",
+			records: [][]string{
+				record("2024-04-15 02:15:07.167", "BUY_ITEMS", "4974"),
+				record("2024-04-15 03:15:07.167", "BUY_ITEMS", "1660"),
+				record("2024-04-16 02:15:07.167", "BUY_ITEMS", "4974"),
+				record("2024-04-16 03:15:07.167", "SELL_ITEMS", "4974"),
+				record("2024-04-16 04:15:07.167", "SELL_ITEMS", "1660"),
+			},
+			expected: []entities.Flatten{
+				{Date: "2024-04-15", ProjectID: "4974", NumTxs: 1, TotalVolume: big.NewRat(1, 1)},
+				{Date: "2024-04-15", ProjectID: "1660", NumTxs: 1, TotalVolume: big.NewRat(1, 1)},
+				{Date: "2024-04-16", ProjectID: "4974", NumTxs: 2, TotalVolume: big.NewRat(0, 1)},
+				{Date: "2024-04-16", ProjectID: "1660", NumTxs: 1, TotalVolume: big.NewRat(-1, 1)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			// Mock ExtractProvider.
+			provider := mocks.NewExtractProvider(t)
+
+			extBytes := encodeToBytes(t, append([][]string{header}, tt.records...), func(t *testing.T, record []string) []string {
+				t.Helper()
+
+				return record
+			})
+
+			provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(extBytes)), nil)
+
+			// Mock Conversor.
+			conversor := mocks.NewConversor(t)
+			conversor.EXPECT().ConvertUSD(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(big.NewRat(1, 1), nil)
+
+			// Mock WarehouseProvider.
+			storage := mocks.NewWarehouseProvider(t)
+
+			for _, f := range tt.expected {
+				storage.EXPECT().Save(mock.Anything, matchFlatten(f)).Return(nil).Once()
+			}
+
+			storage.EXPECT().Flush(mock.Anything).Return(nil)
+
+			// Mock PipelineBackend.
+			b := mocks.NewPipelineBackend(t)
+			b.EXPECT().ExtractProvider().Return(provider)
+			b.EXPECT().Conversor().Return(conversor)
+			b.EXPECT().WarehouseProvider().Return(storage)
+
+			// Run the pipeline.
+			pipeline := internal.NewPipeline(b, internal.PipelineConfig{
+				Workers:              2,
+				ExtractStepEnabled:   true,
+				CalculateStepEnabled: true,
+				InsertStepEnabled:    true,
+			})
+
+			err := pipeline.Run(ctx)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestPipeline_Run_unmatched_events(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Load sample data with limit 4 to load 3 data lines since offset is -1 which means load the header line.
+	dataSample, err := internal.LoadSampleData(4, -1)
+	require.NoError(t, err)
+
+	// Mock ExtractProvider.
+	provider := mocks.NewExtractProvider(t)
+
+	extBytes := encodeToBytes(t, dataSample, func(t *testing.T, record []string) []string {
+		t.Helper()
+
+		return record
+	})
+
+	provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(extBytes)), nil)
+
+	// Mock Conversor and WarehouseProvider, nothing is converted nor saved since all the transactions are skipped.
+	conversor := mocks.NewConversor(t)
+	storage := mocks.NewWarehouseProvider(t)
+	storage.EXPECT().Flush(mock.Anything).Return(nil)
+
+	// Mock PipelineBackend.
+	b := mocks.NewPipelineBackend(t)
+	b.EXPECT().ExtractProvider().Return(provider)
+	b.EXPECT().Conversor().Return(conversor)
+	b.EXPECT().WarehouseProvider().Return(storage).Maybe()
+
+	// Run the pipeline with a registry without the sample data event.
+	pipeline := internal.NewPipeline(b, internal.PipelineConfig{
+		Workers:              1,
+		ExtractStepEnabled:   true,
+		CalculateStepEnabled: true,
+		InsertStepEnabled:    true,
+		Events: internal.EventRegistry{
+			"SELL_ITEMS": {Multiplier: "-1"},
+		},
+	})
+
+	err = pipeline.Run(ctx)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]int{"BUY_ITEMS": 3}, pipeline.Report().UnmatchedEvents)
+}
+
+func TestPipeline_Run_file_rows(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Load sample data with limit 4 to load 3 data lines since offset is -1 which means load the header line.
+	dataSample, err := internal.LoadSampleData(4, -1)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	writeShard(t, dir, "events/00.csv", [][]string{dataSample[0], dataSample[1]})
+	writeShard(t, dir, "events/01.csv", [][]string{dataSample[0], dataSample[2], dataSample[3]})
+
+	// Mock Conversor and WarehouseProvider, nothing is converted nor saved since all the transactions are skipped.
+	conversor := mocks.NewConversor(t)
+	warehouse := mocks.NewWarehouseProvider(t)
+	warehouse.EXPECT().Flush(mock.Anything).Return(nil)
+
+	// Mock PipelineBackend extracting the files of the prefix.
+	b := mocks.NewPipelineBackend(t)
+	b.EXPECT().ExtractProvider().Return(newFilesProvider(dir, "events/"))
+	b.EXPECT().Conversor().Return(conversor)
+	b.EXPECT().WarehouseProvider().Return(warehouse).Maybe()
+
+	pipeline := internal.NewPipeline(b, internal.PipelineConfig{
+		ExtractStepEnabled:   true,
+		CalculateStepEnabled: true,
+		InsertStepEnabled:    true,
+		Events:               internal.EventRegistry{},
+	})
+
+	err = pipeline.Run(ctx)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]int{"events/00.csv": 1, "events/01.csv": 2}, pipeline.Report().FileRows)
+}
+
 func encodeToBytes(t *testing.T, dataSample [][]string, encoder func(*testing.T, []string) []string) []byte {
 	t.Helper()
 
@@ -93,6 +310,90 @@ func encodeToBytes(t *testing.T, dataSample [][]string, encoder func(*testing.T,
 	return buf.Bytes()
 }
 
+// matchFlatten matches a flatten entity with the same values as the expected one, comparing the total volume by
+// its decimal value instead of its internal representation.
+func matchFlatten(expected entities.Flatten) any {
+	return mock.MatchedBy(func(f entities.Flatten) bool {
+		return f.Date == expected.Date &&
+			f.ProjectID == expected.ProjectID &&
+			f.NumTxs == expected.NumTxs &&
+			f.TotalVolume != nil &&
+			f.TotalVolume.Cmp(expected.TotalVolume) == 0
+	})
+}
+
+// withHeader returns the step data prefixed with the header row.
+func withHeader(t *testing.T, header []string, data []byte) []byte {
+	t.Helper()
+
+	return append(encodeToBytes(t, [][]string{header}, func(_ *testing.T, record []string) []string {
+		return record
+	}), data...)
+}
+
+// readStep reads the file of the step data as it is stored by the step provider.
+func readStep(t *testing.T, stepProvider internal.StepProvider, file string) []byte {
+	t.Helper()
+
+	r, err := stepProvider.OpenStep(context.Background(), file)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	return data
+}
+
+// expectStepManifest mocks the manifest of the step of the run saved by the pipeline, returning the buffer it is written
+// into.
+func expectStepManifest(stepProvider *mocks.StepProvider, runID, step string) *stepBuffer {
+	m := &stepBuffer{}
+
+	stepProvider.EXPECT().CreateStep(mock.Anything, runID+"/"+step+".manifest.json").Return(m, nil)
+
+	return m
+}
+
+// expectLatestRuns mocks the latest runs pointer saved by the pipeline, the first one, returning the buffer it is
+// written into.
+func expectLatestRuns(stepProvider *mocks.StepProvider) *stepBuffer {
+	var (
+		latest  = &stepBuffer{}
+		created atomic.Bool
+	)
+
+	stepProvider.EXPECT().OpenStep(mock.Anything, "latest").Return(nil, fs.ErrNotExist).Maybe()
+	stepProvider.EXPECT().CreateStep(mock.Anything, "latest").RunAndReturn(func(context.Context, string) (io.WriteCloser, error) {
+		// The pipelines running concurrently write their own pointer.
+		if created.Swap(true) {
+			return &stepBuffer{}, nil
+		}
+
+		return latest, nil
+	})
+
+	return latest
+}
+
+// expectLegacyStep mocks the step loaded by the pipeline as saved without manifest nor run, before they were
+// introduced.
+func expectLegacyStep(stepProvider *mocks.StepProvider, step string, data []byte) {
+	stepProvider.EXPECT().OpenStep(mock.Anything, "latest").Return(nil, fs.ErrNotExist).Maybe()
+	stepProvider.EXPECT().OpenStep(mock.Anything, step+".manifest.json").Return(nil, fs.ErrNotExist)
+	stepProvider.EXPECT().OpenStep(mock.Anything, step).Return(io.NopCloser(bytes.NewReader(data)), nil)
+}
+
+// stepBuffer is an in-memory step writer used to check the step data saved by the pipeline.
+type stepBuffer struct {
+	bytes.Buffer
+}
+
+// Close implements io.Closer.
+func (*stepBuffer) Close() error {
+	return nil
+}
+
 func TestPipeline_Run_only_extract_step(t *testing.T) {
 	t.Parallel()
 
@@ -111,7 +412,7 @@ func TestPipeline_Run_only_extract_step(t *testing.T) {
 		return record
 	})
 
-	provider.EXPECT().Load(mock.Anything).Return(extBytes, nil)
+	provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(extBytes)), nil)
 
 	// Mock StepProvider.
 	stepProvider := mocks.NewStepProvider(t)
@@ -125,7 +426,10 @@ func TestPipeline_Run_only_extract_step(t *testing.T) {
 		return tx.Encode()
 	})
 
-	stepProvider.EXPECT().SaveStep(mock.Anything, "extraction", saveBytes).Return(nil)
+	extStep := &stepBuffer{}
+	stepProvider.EXPECT().CreateStep(mock.Anything, "run/extraction").Return(extStep, nil)
+	extManifest := expectStepManifest(stepProvider, "run", "extraction")
+	latest := expectLatestRuns(stepProvider)
 
 	// Mock PipelineBackend.
 	b := mocks.NewPipelineBackend(t)
@@ -135,11 +439,193 @@ func TestPipeline_Run_only_extract_step(t *testing.T) {
 	// Run the pipeline.
 	pipeline := internal.NewPipeline(b, internal.PipelineConfig{
 		Workers:            1,
+		RunID:              "run",
 		ExtractStepEnabled: true,
+		ProducerVersion:    "v1.2.3",
+		SourceFile:         "sample_data.csv",
 	})
 
 	err = pipeline.Run(ctx)
 	require.NoError(t, err)
+
+	saveBytes = withHeader(t, entities.TransactionHeader(), saveBytes)
+	require.Equal(t, saveBytes, extStep.Bytes())
+
+	var m internal.StepManifest
+
+	require.NoError(t, json.Unmarshal(extManifest.Bytes(), &m))
+	require.WithinDuration(t, time.Now(), m.CreatedAt, time.Minute)
+
+	sum := sha256.Sum256(saveBytes)
+
+	m.CreatedAt = time.Time{}
+	require.Equal(t, internal.StepManifest{
+		SchemaVersion:   internal.StepSchemaVersion,
+		Step:            "extraction",
+		File:            "extraction",
+		Format:          internal.CSVStepFormat,
+		Compression:     compress.None,
+		Header:          entities.TransactionHeader(),
+		Rows:            3,
+		Checksum:        "sha256:" + hex.EncodeToString(sum[:]),
+		ProducerVersion: "v1.2.3",
+		SourceFile:      "sample_data.csv",
+	}, m)
+
+	// The latest run of the extraction step is the run.
+	require.JSONEq(t, `{"extraction": "run"}`, latest.String())
+}
+
+func TestPipeline_Run_step_compression(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dataSample, err := internal.LoadSampleData(4, -1)
+	require.NoError(t, err)
+
+	// Mock ExtractProvider.
+	provider := mocks.NewExtractProvider(t)
+
+	extBytes := encodeToBytes(t, dataSample, func(t *testing.T, record []string) []string {
+		t.Helper()
+
+		return record
+	})
+
+	provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(extBytes)), nil)
+
+	stepProvider := storage.NewFileSystem(t.TempDir(), "")
+
+	b := mocks.NewPipelineBackend(t)
+	b.EXPECT().ExtractProvider().Return(provider)
+	b.EXPECT().StepProvider().Return(stepProvider)
+
+	// The extraction step data is saved compressed.
+	err = internal.NewPipeline(b, internal.PipelineConfig{
+		Workers:            1,
+		RunID:              "extract-run",
+		ExtractStepEnabled: true,
+		StepCompression:    compress.Zstd,
+	}).Run(ctx)
+	require.NoError(t, err)
+
+	stored, err := stepProvider.OpenStep(ctx, "extract-run/extraction.csv.zst")
+	require.NoError(t, err)
+
+	r, err := compress.NewReader(stored)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, withHeader(t, entities.TransactionHeader(), encodeToBytes(t, dataSample[1:], func(t *testing.T, record []string) []string {
+		t.Helper()
+
+		tx, err := entities.TransactionNormalize(record)
+		require.NoError(t, err)
+
+		return tx.Encode()
+	})), data)
+
+	// The calculation step loads the compressed extraction step data, even though it is configured without compression.
+	conversor := mocks.NewConversor(t)
+	conversor.EXPECT().ConvertUSD(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(big.NewRat(1, 1), nil).Times(3)
+
+	b = mocks.NewPipelineBackend(t)
+	b.EXPECT().Conversor().Return(conversor)
+	b.EXPECT().StepProvider().Return(stepProvider)
+
+	err = internal.NewPipeline(b, internal.PipelineConfig{
+		Workers:              1,
+		RunID:                "calculate-run",
+		CalculateStepEnabled: true,
+	}).Run(ctx)
+	require.NoError(t, err)
+
+	data = readStep(t, stepProvider, "calculate-run/calculation")
+	require.Equal(t, "date,project_id,num_transactions,total_volume_usd\n2024-04-15,4974,3,3\n", string(data))
+}
+
+func TestPipeline_Run_dead_letter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Load sample data with limit 3 to load 2 data lines since offset is -1 which means load the header line.
+	dataSample, err := internal.LoadSampleData(3, -1)
+	require.NoError(t, err)
+
+	// Build the input with a malformed row on line 3.
+	invalidTS := make([]string, len(dataSample[1]))
+	copy(invalidTS, dataSample[1])
+	invalidTS[1] = "15/04/2024"
+
+	records := [][]string{dataSample[0], dataSample[1], invalidTS, dataSample[2]}
+
+	// Mock ExtractProvider.
+	provider := mocks.NewExtractProvider(t)
+
+	extBytes := encodeToBytes(t, records, func(t *testing.T, record []string) []string {
+		t.Helper()
+
+		return record
+	})
+
+	provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(extBytes)), nil)
+
+	// Mock Conversor.
+	conversor := mocks.NewConversor(t)
+	conversor.EXPECT().ConvertUSD(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(big.NewRat(1, 1), nil)
+
+	// Mock WarehouseProvider.
+	storage := mocks.NewWarehouseProvider(t)
+	storage.EXPECT().Save(mock.Anything, matchFlatten(entities.Flatten{
+		Date:        "2024-04-15",
+		ProjectID:   "4974",
+		NumTxs:      2,
+		TotalVolume: big.NewRat(2, 1),
+	})).Return(nil)
+	storage.EXPECT().Flush(mock.Anything).Return(nil)
+
+	// Mock StepProvider.
+	stepProvider := mocks.NewStepProvider(t)
+
+	rejectedStep := &stepBuffer{}
+	stepProvider.EXPECT().CreateStep(mock.Anything, "run/rejected").Return(rejectedStep, nil)
+	expectStepManifest(stepProvider, "run", "rejected")
+
+	// Mock PipelineBackend.
+	b := mocks.NewPipelineBackend(t)
+	b.EXPECT().ExtractProvider().Return(provider)
+	b.EXPECT().Conversor().Return(conversor)
+	b.EXPECT().WarehouseProvider().Return(storage)
+	b.EXPECT().StepProvider().Return(stepProvider)
+
+	// Run the pipeline.
+	pipeline := internal.NewPipeline(b, internal.PipelineConfig{
+		Workers:              1,
+		RunID:                "run",
+		ExtractStepEnabled:   true,
+		CalculateStepEnabled: true,
+		InsertStepEnabled:    true,
+		ErrorPolicy:          internal.DeadLetterPolicy,
+	})
+
+	err = pipeline.Run(ctx)
+	require.NoError(t, err)
+
+	// The raw row follows the line and the reason, encoded as a single field.
+	rejected, err := csv.NewReader(rejectedStep).ReadAll()
+	require.NoError(t, err)
+
+	require.Len(t, rejected, 2)
+	require.Equal(t, entities.RejectionHeader(), rejected[0])
+	require.Equal(t, "3", rejected[1][0])
+	require.Contains(t, rejected[1][1], "parsing time")
+	record, err := csv.NewReader(strings.NewReader(rejected[1][2])).Read()
+	require.NoError(t, err)
+	require.Equal(t, invalidTS, record)
 }
 
 func TestPipeline_Run_only_calculation_step(t *testing.T) {
@@ -157,7 +643,7 @@ func TestPipeline_Run_only_calculation_step(t *testing.T) {
 		tx, err := entities.TransactionNormalize(record)
 		require.NoError(t, err)
 
-		conversor.EXPECT().ConvertUSD(mock.Anything, tx.CurrencyValueDecimal, tx.CurrencySymbol).Return(1.0, nil)
+		conversor.EXPECT().ConvertUSD(mock.Anything, tx.CurrencyValue(), tx.Currency(), tx.TS).Return(big.NewRat(1, 1), nil)
 	}
 
 	// Mock StepProvider.
@@ -172,7 +658,7 @@ func TestPipeline_Run_only_calculation_step(t *testing.T) {
 		return tx.Encode()
 	})
 
-	stepProvider.EXPECT().LoadStep(mock.Anything, "extraction").Return(loadBytes, nil)
+	expectLegacyStep(stepProvider, "extraction", loadBytes)
 
 	saveBytes := encodeToBytes(t, [][]string{{"2024-04-15", "4974", "3", "3"}}, func(t *testing.T, record []string) []string {
 		t.Helper()
@@ -180,7 +666,10 @@ func TestPipeline_Run_only_calculation_step(t *testing.T) {
 		return record
 	})
 
-	stepProvider.EXPECT().SaveStep(mock.Anything, "calculation", saveBytes).Return(nil)
+	calcStep := &stepBuffer{}
+	stepProvider.EXPECT().CreateStep(mock.Anything, "run/calculation").Return(calcStep, nil)
+	expectStepManifest(stepProvider, "run", "calculation")
+	expectLatestRuns(stepProvider)
 
 	// Mock PipelineBackend.
 	b := mocks.NewPipelineBackend(t)
@@ -190,11 +679,14 @@ func TestPipeline_Run_only_calculation_step(t *testing.T) {
 	// Run the pipeline.
 	pipeline := internal.NewPipeline(b, internal.PipelineConfig{
 		Workers:              1,
+		RunID:                "run",
 		CalculateStepEnabled: true,
 	})
 
 	err = pipeline.Run(ctx)
 	require.NoError(t, err)
+
+	require.Equal(t, withHeader(t, entities.FlattenHeader(), saveBytes), calcStep.Bytes())
 }
 
 func TestPipeline_Run_only_insert_step(t *testing.T) {
@@ -204,12 +696,13 @@ func TestPipeline_Run_only_insert_step(t *testing.T) {
 
 	// Mock WarehouseProvider.
 	storage := mocks.NewWarehouseProvider(t)
-	storage.EXPECT().Save(mock.Anything, entities.Flatten{
+	storage.EXPECT().Save(mock.Anything, matchFlatten(entities.Flatten{
 		Date:        "2024-04-15",
 		ProjectID:   "4974",
 		NumTxs:      3,
-		TotalVolume: 3.00,
-	}).Return(nil)
+		TotalVolume: big.NewRat(3, 1),
+	})).Return(nil)
+	storage.EXPECT().Flush(mock.Anything).Return(nil)
 
 	// Mock StepProvider.
 	stepProvider := mocks.NewStepProvider(t)
@@ -220,7 +713,7 @@ func TestPipeline_Run_only_insert_step(t *testing.T) {
 		return record
 	})
 
-	stepProvider.EXPECT().LoadStep(mock.Anything, "calculation").Return(saveBytes, nil)
+	expectLegacyStep(stepProvider, "calculation", saveBytes)
 
 	// Mock PipelineBackend.
 	b := mocks.NewPipelineBackend(t)
@@ -255,7 +748,7 @@ func TestPipeline_Run_all_split(t *testing.T) {
 		return record
 	})
 
-	provider.EXPECT().Load(mock.Anything).Return(extBytes, nil)
+	provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(extBytes)), nil)
 
 	// Mock Conversor.
 	conversor := mocks.NewConversor(t)
@@ -265,17 +758,18 @@ func TestPipeline_Run_all_split(t *testing.T) {
 		transaction, err := entities.TransactionNormalize(record)
 		require.NoError(t, err)
 
-		conversor.EXPECT().ConvertUSD(mock.Anything, transaction.CurrencyValueDecimal, transaction.CurrencySymbol).Return(1.0, nil)
+		conversor.EXPECT().ConvertUSD(mock.Anything, transaction.CurrencyValue(), transaction.Currency(), transaction.TS).Return(big.NewRat(1, 1), nil)
 	}
 
 	// Mock WarehouseProvider.
 	storage := mocks.NewWarehouseProvider(t)
-	storage.EXPECT().Save(mock.Anything, entities.Flatten{
+	storage.EXPECT().Save(mock.Anything, matchFlatten(entities.Flatten{
 		Date:        "2024-04-15",
 		ProjectID:   "4974",
 		NumTxs:      3,
-		TotalVolume: 3.00,
-	}).Return(nil)
+		TotalVolume: big.NewRat(3, 1),
+	})).Return(nil)
+	storage.EXPECT().Flush(mock.Anything).Return(nil)
 
 	// Mock StepProvider.
 	stepProvider := mocks.NewStepProvider(t)
@@ -290,8 +784,10 @@ func TestPipeline_Run_all_split(t *testing.T) {
 		return tx.Encode()
 	})
 
-	stepProvider.EXPECT().SaveStep(mock.Anything, "extraction", txBytes).Return(nil)
-	stepProvider.EXPECT().LoadStep(mock.Anything, "extraction").Return(txBytes, nil)
+	extStep := &stepBuffer{}
+	stepProvider.EXPECT().CreateStep(mock.Anything, "extract-run/extraction").Return(extStep, nil)
+	expectStepManifest(stepProvider, "extract-run", "extraction")
+	expectLegacyStep(stepProvider, "extraction", txBytes)
 
 	// Calculation step.
 	conBytes := encodeToBytes(t, [][]string{{"2024-04-15", "4974", "3", "3"}}, func(t *testing.T, record []string) []string {
@@ -300,8 +796,11 @@ func TestPipeline_Run_all_split(t *testing.T) {
 		return record
 	})
 
-	stepProvider.EXPECT().SaveStep(mock.Anything, "calculation", conBytes).Return(nil)
-	stepProvider.EXPECT().LoadStep(mock.Anything, "calculation").Return(conBytes, nil)
+	calcStep := &stepBuffer{}
+	stepProvider.EXPECT().CreateStep(mock.Anything, "calculate-run/calculation").Return(calcStep, nil)
+	expectStepManifest(stepProvider, "calculate-run", "calculation")
+	expectLegacyStep(stepProvider, "calculation", conBytes)
+	expectLatestRuns(stepProvider)
 
 	// Mock PipelineBackend.
 	b := mocks.NewPipelineBackend(t)
@@ -332,6 +831,7 @@ func TestPipeline_Run_all_split(t *testing.T) {
 		defer wg.Done()
 
 		pipeline := internal.NewPipeline(b, internal.PipelineConfig{
+			RunID:                "calculate-run",
 			CalculateStepEnabled: true,
 		})
 
@@ -340,6 +840,7 @@ func TestPipeline_Run_all_split(t *testing.T) {
 	}()
 
 	pipeline := internal.NewPipeline(b, internal.PipelineConfig{
+		RunID:              "extract-run",
 		ExtractStepEnabled: true,
 	})
 
@@ -347,4 +848,7 @@ func TestPipeline_Run_all_split(t *testing.T) {
 	require.NoError(t, err)
 
 	wg.Wait()
+
+	require.Equal(t, withHeader(t, entities.TransactionHeader(), txBytes), extStep.Bytes())
+	require.Equal(t, withHeader(t, entities.FlattenHeader(), conBytes), calcStep.Bytes())
 }
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"time"
+)
+
+// latestFile is the file of the pointer to the latest run of each step, stored along with the runs.
+const latestFile = "latest"
+
+// NewRunID returns a new run identifier, the UTC time the run started followed by a random suffix, e.g.
+// 20240415T103000Z-1a2b3c4d.
+func NewRunID() string {
+	suffix := make([]byte, 4)
+
+	// crypto/rand Read never returns an error.
+	_, _ = rand.Read(suffix) //nolint:errcheck
+
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// runFile returns the file of the step provider where the file of the run is stored, <run-id>/<file>.
+//
+// The files of the runs saved before the run identifiers were introduced are stored at the root, their run is empty.
+func runFile(runID, file string) string {
+	if runID == "" {
+		return file
+	}
+
+	return path.Join(runID, file)
+}
+
+// LatestRuns is the pointer to the latest run of each step, saved into the latest file once the run which saved the step
+// data completes.
+//
+// It maps the step to the identifier of the run.
+type LatestRuns map[string]string
+
+// loadLatestRuns loads the pointer to the latest run of each step, empty when no run saved the step data yet.
+func (p *Pipeline) loadLatestRuns(ctx context.Context) (LatestRuns, error) {
+	r, err := p.openStepFile(ctx, latestFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return LatestRuns{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("opening latest runs: %w", err)
+	}
+
+	defer r.Close() //nolint:errcheck
+
+	latest := LatestRuns{}
+
+	if err = json.NewDecoder(r).Decode(&latest); err != nil {
+		return nil, fmt.Errorf("decoding latest runs: %w", err)
+	}
+
+	return latest, nil
+}
+
+// saveLatestRuns points the latest run of the steps to the run.
+func (p *Pipeline) saveLatestRuns(ctx context.Context, steps ...Step) error {
+	if len(steps) == 0 {
+		return nil
+	}
+
+	latest, err := p.loadLatestRuns(ctx)
+	if err != nil {
+		return err
+	}
+
+	latest = maps.Clone(latest)
+
+	for _, step := range steps {
+		latest[step.String()] = p.cfg.RunID
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := p.b.StepProvider().CreateStep(ctx, latestFile)
+	if err != nil {
+		return fmt.Errorf("creating latest runs: %w", err)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err = enc.Encode(latest); err != nil {
+		// Cancel the context to discard the partially written pointer.
+		cancel()
+
+		_ = w.Close() //nolint:errcheck
+
+		return fmt.Errorf("encoding latest runs: %w", err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("saving latest runs: %w", err)
+	}
+
+	return nil
+}
+
+// savedSteps returns the steps whose data is saved by the run, to be loaded by the next runs.
+func (p *Pipeline) savedSteps() []Step {
+	var steps []Step
+
+	if p.cfg.ExtractStepEnabled && !p.cfg.CalculateStepEnabled {
+		steps = append(steps, extractionStep)
+	}
+
+	if p.cfg.CalculateStepEnabled && !p.cfg.InsertStepEnabled {
+		steps = append(steps, calculationStep)
+	}
+
+	return steps
+}
+
+// loadRunID returns the identifier of the run the step data is loaded from.
+//
+// It is the configured FromRunID, otherwise the latest run of the step. When no run saved the step data, it is empty,
+// so the step data stored at the root, saved before the run identifiers were introduced, is loaded.
+func (p *Pipeline) loadRunID(ctx context.Context, step Step) (string, error) {
+	if p.cfg.FromRunID != "" {
+		return p.cfg.FromRunID, nil
+	}
+
+	latest, err := p.loadLatestRuns(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return latest[step.String()], nil
+}
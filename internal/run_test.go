@@ -0,0 +1,94 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal"
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+	"github.com/dohernandez/horizon-blockchain-games/internal/mocks"
+	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
+)
+
+func TestPipeline_Run_run_id(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	stepProvider := storage.NewFileSystem(t.TempDir(), "")
+
+	// extract runs the extraction step of the run with the first rows of the sample data.
+	extract := func(runID string, rows int) {
+		dataSample, err := internal.LoadSampleData(rows+1, -1)
+		require.NoError(t, err)
+
+		provider := mocks.NewExtractProvider(t)
+		provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(encodeToBytes(t, dataSample,
+			func(_ *testing.T, record []string) []string {
+				return record
+			},
+		))), nil)
+
+		b := mocks.NewPipelineBackend(t)
+		b.EXPECT().ExtractProvider().Return(provider)
+		b.EXPECT().StepProvider().Return(stepProvider)
+
+		err = internal.NewPipeline(b, internal.PipelineConfig{
+			RunID:              runID,
+			ExtractStepEnabled: true,
+		}).Run(ctx)
+		require.NoError(t, err)
+	}
+
+	// calculate runs the calculation and insertion steps loading the extraction step data from the run, the latest run
+	// when it is empty, and expects the number of transactions loaded.
+	calculate := func(fromRunID string, numTxs int) {
+		conversor := mocks.NewConversor(t)
+		conversor.EXPECT().ConvertUSD(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(big.NewRat(1, 1), nil).Times(numTxs)
+
+		warehouse := mocks.NewWarehouseProvider(t)
+		warehouse.EXPECT().Save(mock.Anything, matchFlatten(entities.Flatten{
+			Date:        "2024-04-15",
+			ProjectID:   "4974",
+			NumTxs:      numTxs,
+			TotalVolume: big.NewRat(int64(numTxs), 1),
+		})).Return(nil)
+		warehouse.EXPECT().Flush(mock.Anything).Return(nil)
+
+		b := mocks.NewPipelineBackend(t)
+		b.EXPECT().Conversor().Return(conversor)
+		b.EXPECT().WarehouseProvider().Return(warehouse)
+		b.EXPECT().StepProvider().Return(stepProvider)
+
+		err := internal.NewPipeline(b, internal.PipelineConfig{
+			FromRunID:            fromRunID,
+			CalculateStepEnabled: true,
+			InsertStepEnabled:    true,
+		}).Run(ctx)
+		require.NoError(t, err)
+	}
+
+	// The runs sharing the step provider do not overwrite each other.
+	extract("first", 3)
+	extract("second", 6)
+
+	for _, file := range []string{"first/extraction", "second/extraction", "latest"} {
+		require.NotEmpty(t, readStep(t, stepProvider, file))
+	}
+
+	// The step data is loaded from the latest run by default, or from the given run.
+	calculate("", 6)
+	calculate("first", 3)
+
+	// The run identifier is generated when it is not given.
+	p := internal.NewPipeline(mocks.NewPipelineBackend(t), internal.PipelineConfig{})
+	require.NotEmpty(t, p.RunID())
+	require.NotEqual(t, p.RunID(), internal.NewPipeline(mocks.NewPipelineBackend(t), internal.PipelineConfig{}).RunID())
+}
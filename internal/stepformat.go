@@ -0,0 +1,378 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+)
+
+const (
+	// CSVStepFormat writes the step data as CSV with a header row.
+	CSVStepFormat = "csv"
+	// JSONLStepFormat writes the step data as JSON Lines of the typed records.
+	JSONLStepFormat = "jsonl"
+	// ParquetStepFormat writes the step data as Parquet of the typed records.
+	ParquetStepFormat = "parquet"
+	// GobStepFormat writes the step data as a gob stream of the typed records.
+	GobStepFormat = "gob"
+)
+
+// StepFormats returns the supported step formats.
+func StepFormats() []string {
+	return []string{CSVStepFormat, JSONLStepFormat, ParquetStepFormat, GobStepFormat}
+}
+
+// stepSchema describes the entity of the step data, how it is encoded and decoded in the step formats.
+type stepSchema struct {
+	// header is the names of the fields of the entity, the header row of the CSV step data.
+	header []string
+	// decode decodes the entity from the CSV record.
+	decode func(v []string) (any, error)
+
+	// newRecord returns a pointer to a new typed record of the entity, to decode it from the typed step formats.
+	// It is nil when the entity has no typed record, and then the step data is always written as CSV.
+	newRecord func() any
+	// record returns the typed record of the entity.
+	record func(d encoder) any
+	// fromRecord returns the entity from the pointer to its typed record.
+	fromRecord func(r any) (any, error)
+}
+
+// transactionSchema is the schema of the extraction step data.
+func transactionSchema() stepSchema {
+	return stepSchema{
+		header: entities.TransactionHeader(),
+		decode: func(v []string) (any, error) {
+			var t entities.Transaction
+
+			err := t.Decode(v)
+			if err != nil {
+				return nil, err
+			}
+
+			return t, nil
+		},
+		newRecord: func() any {
+			return &entities.TransactionRecord{}
+		},
+		record: func(d encoder) any {
+			return d.(entities.Transaction).Record()
+		},
+		fromRecord: func(r any) (any, error) {
+			var t entities.Transaction
+
+			err := t.FromRecord(*r.(*entities.TransactionRecord))
+			if err != nil {
+				return nil, err
+			}
+
+			return t, nil
+		},
+	}
+}
+
+// flattenSchema is the schema of the calculation step data.
+func flattenSchema() stepSchema {
+	return stepSchema{
+		header: entities.FlattenHeader(),
+		decode: func(v []string) (any, error) {
+			var f entities.Flatten
+
+			err := f.Decode(v)
+			if err != nil {
+				return nil, err
+			}
+
+			return f, nil
+		},
+		newRecord: func() any {
+			return &entities.FlattenRecord{}
+		},
+		record: func(d encoder) any {
+			return d.(entities.Flatten).Record()
+		},
+		fromRecord: func(r any) (any, error) {
+			var f entities.Flatten
+
+			err := f.FromRecord(*r.(*entities.FlattenRecord))
+			if err != nil {
+				return nil, err
+			}
+
+			return f, nil
+		},
+	}
+}
+
+// rejectionSchema is the schema of the rejected step data.
+//
+// The rejections are never loaded, and they have no typed record since the raw rows are malformed.
+func rejectionSchema() stepSchema {
+	return stepSchema{
+		header: entities.RejectionHeader(),
+	}
+}
+
+// format returns the format the step data of the schema is written with, CSVStepFormat when the schema has no typed
+// record.
+func (s stepSchema) format(format string) string {
+	if s.newRecord == nil {
+		return CSVStepFormat
+	}
+
+	return format
+}
+
+// stepWriter writes the entities of the step data.
+type stepWriter interface {
+	Write(d encoder) error
+	// Close flushes the step data, it does not close the underlying writer.
+	Close() error
+}
+
+// newStepWriter returns the writer of the step data in the format.
+func newStepWriter(w io.Writer, format string, schema stepSchema) (stepWriter, error) {
+	switch format {
+	case CSVStepFormat:
+		cw := csv.NewWriter(w)
+
+		if err := cw.Write(schema.header); err != nil {
+			return nil, err
+		}
+
+		return &csvStepWriter{w: cw}, nil
+	case JSONLStepFormat:
+		return &encoderStepWriter{enc: json.NewEncoder(w), record: schema.record}, nil
+	case GobStepFormat:
+		return &encoderStepWriter{enc: gob.NewEncoder(w), record: schema.record}, nil
+	case ParquetStepFormat:
+		return &parquetStepWriter{
+			w:      parquet.NewWriter(w, parquet.SchemaOf(schema.newRecord())),
+			record: schema.record,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown step format %s", format)
+	}
+}
+
+// csvStepWriter writes the step data as CSV.
+type csvStepWriter struct {
+	w *csv.Writer
+}
+
+// Write writes the entity as a CSV record.
+func (s *csvStepWriter) Write(d encoder) error {
+	return s.w.Write(d.Encode())
+}
+
+// Close flushes the CSV records.
+func (s *csvStepWriter) Close() error {
+	s.w.Flush()
+
+	return s.w.Error()
+}
+
+// encoderStepWriter writes the step data as a stream of typed records, such as JSON Lines or gob.
+type encoderStepWriter struct {
+	enc interface {
+		Encode(v any) error
+	}
+	record func(d encoder) any
+}
+
+// Write writes the typed record of the entity.
+func (s *encoderStepWriter) Write(d encoder) error {
+	return s.enc.Encode(s.record(d))
+}
+
+// Close does nothing, the records are written as they are encoded.
+func (*encoderStepWriter) Close() error {
+	return nil
+}
+
+// parquetStepWriter writes the step data as Parquet.
+type parquetStepWriter struct {
+	w      *parquet.Writer
+	record func(d encoder) any
+}
+
+// Write writes the typed record of the entity.
+func (s *parquetStepWriter) Write(d encoder) error {
+	return s.w.Write(s.record(d))
+}
+
+// Close flushes the row groups and writes the footer of the Parquet file.
+func (s *parquetStepWriter) Close() error {
+	return s.w.Close()
+}
+
+// stepReader reads the entities of the step data.
+type stepReader interface {
+	// Read returns the next entity, io.EOF when there are no more entities.
+	Read() (any, error)
+	// Close releases the resources of the reader, it does not close the underlying reader.
+	Close() error
+}
+
+// newStepReader returns the reader of the step data described by the manifest.
+//
+// The CSV step data starts with a header row, except the legacy step data, and the columns are decoded in the order of
+// the schema header. The typed step formats decode the fields by name.
+func newStepReader(r io.Reader, m StepManifest, schema stepSchema) (stepReader, error) {
+	format := m.Format
+	if format == "" {
+		format = CSVStepFormat
+	}
+
+	switch format {
+	case CSVStepFormat:
+		return newCSVStepReader(r, m, schema)
+	case JSONLStepFormat:
+		return &decoderStepReader{dec: json.NewDecoder(r), schema: schema}, nil
+	case GobStepFormat:
+		return &decoderStepReader{dec: gob.NewDecoder(r), schema: schema}, nil
+	case ParquetStepFormat:
+		return newParquetStepReader(r, schema)
+	default:
+		return nil, fmt.Errorf("step %s format %s is not supported", m.Step, format)
+	}
+}
+
+// csvStepReader reads the step data as CSV.
+type csvStepReader struct {
+	r       *csv.Reader
+	columns []int
+	decode  func(v []string) (any, error)
+}
+
+// newCSVStepReader returns the reader of the CSV step data, reading the header row, if any.
+func newCSVStepReader(r io.Reader, m StepManifest, schema stepSchema) (*csvStepReader, error) {
+	s := &csvStepReader{r: csv.NewReader(r), decode: schema.decode}
+
+	if m.SchemaVersion == legacyStepSchemaVersion {
+		return s, nil
+	}
+
+	row, err := s.r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading step %s header: %w", m.Step, err)
+	}
+
+	s.columns, err = stepColumns(m, schema.header, row)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Read decodes the next CSV record.
+func (s *csvStepReader) Read() (any, error) {
+	record, err := s.r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decode(migrateRecord(record, s.columns))
+}
+
+// Close does nothing.
+func (*csvStepReader) Close() error {
+	return nil
+}
+
+// decoderStepReader reads the step data as a stream of typed records, such as JSON Lines or gob.
+type decoderStepReader struct {
+	dec interface {
+		Decode(v any) error
+	}
+	schema stepSchema
+}
+
+// Read decodes the next typed record.
+func (s *decoderStepReader) Read() (any, error) {
+	r := s.schema.newRecord()
+
+	if err := s.dec.Decode(r); err != nil {
+		return nil, err
+	}
+
+	return s.schema.fromRecord(r)
+}
+
+// Close does nothing.
+func (*decoderStepReader) Close() error {
+	return nil
+}
+
+// parquetStepReader reads the step data as Parquet.
+//
+// Parquet is read from the footer, so the step data is spooled into a temporary file first, the memory used is bounded
+// regardless of the data size.
+type parquetStepReader struct {
+	f      *os.File
+	r      *parquet.Reader
+	schema stepSchema
+}
+
+// newParquetStepReader returns the reader of the Parquet step data, spooled into a temporary file.
+func newParquetStepReader(r io.Reader, schema stepSchema) (_ *parquetStepReader, err error) {
+	f, err := os.CreateTemp("", "step-*.parquet")
+	if err != nil {
+		return nil, fmt.Errorf("creating parquet spool file: %w", err)
+	}
+
+	s := &parquetStepReader{f: f, schema: schema}
+
+	defer func() {
+		if err != nil {
+			_ = s.Close() //nolint:errcheck
+		}
+	}()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return nil, fmt.Errorf("spooling parquet: %w", err)
+	}
+
+	pf, err := parquet.OpenFile(f, size)
+	if err != nil {
+		return nil, fmt.Errorf("opening parquet: %w", err)
+	}
+
+	s.r = parquet.NewReader(pf, parquet.SchemaOf(schema.newRecord()))
+
+	return s, nil
+}
+
+// Read decodes the next typed record.
+func (s *parquetStepReader) Read() (any, error) {
+	r := s.schema.newRecord()
+
+	if err := s.r.Read(r); err != nil {
+		return nil, err
+	}
+
+	return s.schema.fromRecord(r)
+}
+
+// Close closes the reader and removes the spool file.
+func (s *parquetStepReader) Close() error {
+	var errs []error
+
+	if s.r != nil {
+		errs = append(errs, s.r.Close())
+	}
+
+	errs = append(errs, s.f.Close(), os.Remove(s.f.Name()))
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,155 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal"
+	"github.com/dohernandez/horizon-blockchain-games/internal/compress"
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+	"github.com/dohernandez/horizon-blockchain-games/internal/mocks"
+	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
+)
+
+func TestPipeline_Run_step_format(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		format string
+		codec  string
+		file   string
+	}{
+		{format: internal.CSVStepFormat, codec: compress.None, file: "extraction"},
+		{format: internal.JSONLStepFormat, codec: compress.None, file: "extraction.jsonl"},
+		{format: internal.GobStepFormat, codec: compress.Gzip, file: "extraction.gob.gz"},
+		{format: internal.ParquetStepFormat, codec: compress.None, file: "extraction.parquet"},
+		{format: internal.ParquetStepFormat, codec: compress.Zstd, file: "extraction.parquet.zst"},
+	} {
+		t.Run(tt.file, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			dataSample, err := internal.LoadSampleData(4, -1)
+			require.NoError(t, err)
+
+			provider := mocks.NewExtractProvider(t)
+			provider.EXPECT().Open(mock.Anything).Return(io.NopCloser(bytes.NewReader(encodeToBytes(t, dataSample,
+				func(_ *testing.T, record []string) []string {
+					return record
+				},
+			))), nil)
+
+			stepProvider := storage.NewFileSystem(t.TempDir(), "")
+
+			b := mocks.NewPipelineBackend(t)
+			b.EXPECT().ExtractProvider().Return(provider)
+			b.EXPECT().StepProvider().Return(stepProvider)
+
+			err = internal.NewPipeline(b, internal.PipelineConfig{
+				RunID:              "run",
+				ExtractStepEnabled: true,
+				StepFormat:         tt.format,
+				StepCompression:    tt.codec,
+			}).Run(ctx)
+			require.NoError(t, err)
+
+			require.NotEmpty(t, readStep(t, stepProvider, "run/"+tt.file))
+
+			// The transactions are loaded as they were extracted, regardless of the configured format.
+			conversor := mocks.NewConversor(t)
+
+			for _, record := range dataSample[1:] {
+				tx, err := entities.TransactionNormalize(record)
+				require.NoError(t, err)
+
+				conversor.EXPECT().ConvertUSD(mock.Anything, tx.CurrencyValue(), tx.Currency(), tx.TS).Return(big.NewRat(1, 1), nil)
+			}
+
+			warehouse := mocks.NewWarehouseProvider(t)
+			warehouse.EXPECT().Save(mock.Anything, matchFlatten(entities.Flatten{
+				Date:        "2024-04-15",
+				ProjectID:   "4974",
+				NumTxs:      3,
+				TotalVolume: big.NewRat(3, 1),
+			})).Return(nil)
+			warehouse.EXPECT().Flush(mock.Anything).Return(nil)
+
+			b = mocks.NewPipelineBackend(t)
+			b.EXPECT().Conversor().Return(conversor)
+			b.EXPECT().WarehouseProvider().Return(warehouse)
+			b.EXPECT().StepProvider().Return(stepProvider)
+
+			err = internal.NewPipeline(b, internal.PipelineConfig{
+				CalculateStepEnabled: true,
+				InsertStepEnabled:    true,
+			}).Run(ctx)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestPipeline_Run_step_format_calculation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dataSample, err := internal.LoadSampleData(3, 0)
+	require.NoError(t, err)
+
+	stepProvider := storage.NewFileSystem(t.TempDir(), "")
+
+	// The extraction step data is loaded without manifest.
+	extStep, err := stepProvider.CreateStep(ctx, "extraction")
+	require.NoError(t, err)
+
+	_, err = extStep.Write(encodeToBytes(t, dataSample, func(t *testing.T, record []string) []string {
+		t.Helper()
+
+		tx, err := entities.TransactionNormalize(record)
+		require.NoError(t, err)
+
+		return tx.Encode()
+	}))
+	require.NoError(t, err)
+	require.NoError(t, extStep.Close())
+
+	// The calculation step data is saved as Parquet, keeping the precision of the total volume.
+	conversor := mocks.NewConversor(t)
+	conversor.EXPECT().ConvertUSD(mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(big.NewRat(1234567890123456789, 10000000000000000), nil).Times(3)
+
+	b := mocks.NewPipelineBackend(t)
+	b.EXPECT().Conversor().Return(conversor)
+	b.EXPECT().StepProvider().Return(stepProvider)
+
+	err = internal.NewPipeline(b, internal.PipelineConfig{
+		CalculateStepEnabled: true,
+		StepFormat:           internal.ParquetStepFormat,
+	}).Run(ctx)
+	require.NoError(t, err)
+
+	warehouse := mocks.NewWarehouseProvider(t)
+	warehouse.EXPECT().Save(mock.Anything, matchFlatten(entities.Flatten{
+		Date:        "2024-04-15",
+		ProjectID:   "4974",
+		NumTxs:      3,
+		TotalVolume: big.NewRat(3*1234567890123456789, 10000000000000000),
+	})).Return(nil)
+	warehouse.EXPECT().Flush(mock.Anything).Return(nil)
+
+	b = mocks.NewPipelineBackend(t)
+	b.EXPECT().WarehouseProvider().Return(warehouse)
+	b.EXPECT().StepProvider().Return(stepProvider)
+
+	err = internal.NewPipeline(b, internal.PipelineConfig{
+		InsertStepEnabled: true,
+	}).Run(ctx)
+	require.NoError(t, err)
+}
@@ -2,13 +2,23 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
+	"slices"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/bool64/ctxd"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/lock"
 )
 
 // BucketType is the type of the GoogleBucket storage.
@@ -73,23 +83,14 @@ func NewGoogleBucket(cfg GoogleBucketConfig, opts ...Option) *GoogleBucket {
 	return g
 }
 
-// Load loads the data from the Google bucket.
-func (g *GoogleBucket) Load(ctx context.Context) ([]byte, error) {
-	err := g.loadClient(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	g.logger.Debug(ctx, "creating reader", "bucket", g.cfg.Bucket, "file", g.cfg.File)
-
-	reader, err := g.client.Bucket(g.cfg.Bucket).Object(g.cfg.File).NewReader(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("creating reader: %w", err)
-	}
-
-	defer reader.Close() //nolint: errcheck
+// Open opens the file from the Google bucket to be read as a stream.
+func (g *GoogleBucket) Open(ctx context.Context) (io.ReadCloser, error) {
+	return g.OpenFile(ctx, g.cfg.File)
+}
 
-	return io.ReadAll(reader)
+// OpenFile opens the file from the Google bucket, as listed by ListFiles, to be read as a stream.
+func (g *GoogleBucket) OpenFile(ctx context.Context, file string) (io.ReadCloser, error) {
+	return g.OpenStep(ctx, file)
 }
 
 func (g *GoogleBucket) loadClient(ctx context.Context) error {
@@ -119,45 +120,91 @@ func (g *GoogleBucket) loadClient(ctx context.Context) error {
 	return err
 }
 
-// LoadStep loads the data from the Google bucket.
-func (g *GoogleBucket) LoadStep(ctx context.Context, file string) ([]byte, error) {
+// ListFiles returns the objects of the Google bucket matching the file, when it is a glob pattern or a prefix, sorted
+// by name. Otherwise, it returns the file.
+//
+// When no object matches, the error wraps fs.ErrNotExist.
+func (g *GoogleBucket) ListFiles(ctx context.Context) ([]string, error) {
+	if !isPattern(g.cfg.File) {
+		return []string{g.cfg.File}, nil
+	}
+
 	err := g.loadClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	g.logger.Debug(ctx, "creating reader", "bucket", g.cfg.Bucket, "file", file)
+	g.logger.Debug(ctx, "listing objects", "bucket", g.cfg.Bucket, "pattern", g.cfg.File)
 
-	reader, err := g.client.Bucket(g.cfg.Bucket).Object(file).NewReader(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("creating reader: %w", err)
+	var files []string
+
+	it := g.client.Bucket(g.cfg.Bucket).Objects(ctx, &storage.Query{Prefix: listPrefix(g.cfg.File)})
+
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("listing objects: %w", err)
+		}
+
+		ok, err := matchPattern(g.cfg.File, attrs.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			files = append(files, attrs.Name)
+		}
 	}
 
-	defer reader.Close() //nolint: errcheck
+	if len(files) == 0 {
+		return nil, errNoMatch(g.cfg.File)
+	}
 
-	g.logger.Debug(ctx, "reading file", "bucket", g.cfg.Bucket, "file", file)
+	slices.Sort(files)
 
-	return io.ReadAll(reader)
+	return files, nil
 }
 
-// SaveStep saves the data to the Google bucket.
-func (g *GoogleBucket) SaveStep(ctx context.Context, file string, data []byte) error {
+// OpenStep opens the file from the Google bucket to be read as a stream.
+//
+// When the file does not exist, the error wraps fs.ErrNotExist.
+func (g *GoogleBucket) OpenStep(ctx context.Context, file string) (io.ReadCloser, error) {
 	err := g.loadClient(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	g.logger.Debug(ctx, "creating writer", "bucket", g.cfg.Bucket, "file", file)
+	g.logger.Debug(ctx, "creating reader", "bucket", g.cfg.Bucket, "file", file)
 
-	writer := g.client.Bucket(g.cfg.Bucket).Object(file).NewWriter(ctx)
+	reader, err := g.client.Bucket(g.cfg.Bucket).Object(file).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("creating reader: %w: %w", fs.ErrNotExist, err)
+	}
 
-	g.logger.Debug(ctx, "writing data", "bucket", g.cfg.Bucket, "file", file)
+	if err != nil {
+		return nil, fmt.Errorf("creating reader: %w", err)
+	}
 
-	if _, err := writer.Write(data); err != nil {
-		return fmt.Errorf("writing data: %w", err)
+	return reader, nil
+}
+
+// CreateStep creates the file in the Google bucket to be written as a stream.
+//
+// The data is uploaded in chunks as it is written, and the object is created when the writer is closed.
+// When the context is canceled before closing the writer, the upload is aborted.
+func (g *GoogleBucket) CreateStep(ctx context.Context, file string) (io.WriteCloser, error) {
+	err := g.loadClient(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return writer.Close()
+	g.logger.Debug(ctx, "creating writer", "bucket", g.cfg.Bucket, "file", file)
+
+	return g.client.Bucket(g.cfg.Bucket).Object(file).NewWriter(ctx), nil
 }
 
 // Close closes the Google Cloud Storage client.
@@ -168,3 +215,160 @@ func (g *GoogleBucket) Close() error {
 
 	return g.client.Close()
 }
+
+// Acquire acquires the lease of the bucket, creating the lock object with the PID and the expiry of the lease.
+//
+// The lock object is created on condition it does not exist, and the lock object whose lease expired is taken over on
+// condition its generation did not change, so two runs never hold the lock at the same time.
+func (g *GoogleBucket) Acquire(ctx context.Context, ttl time.Duration) (lock.Lease, error) {
+	err := g.loadClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := g.client.Bucket(g.cfg.Bucket).Object(lock.File)
+	info := lock.NewInfo(ttl)
+
+	for range maxLockAttempts {
+		generation, err := writeLockObject(ctx, obj.If(storage.Conditions{DoesNotExist: true}), info)
+		if err == nil {
+			return &bucketLease{obj: obj, info: info, generation: generation}, nil
+		}
+
+		if !isPreconditionFailed(err) {
+			return nil, err
+		}
+
+		held, heldGeneration, err := readLockObject(ctx, obj)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			// Released meanwhile.
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !held.Expired(time.Now()) {
+			return nil, lock.Locked(held)
+		}
+
+		g.logger.Debug(ctx, "taking over expired lock", "bucket", g.cfg.Bucket, "host", held.Host, "pid", held.PID)
+
+		generation, err = writeLockObject(ctx, obj.If(storage.Conditions{GenerationMatch: heldGeneration}), info)
+		if err == nil {
+			return &bucketLease{obj: obj, info: info, generation: generation}, nil
+		}
+
+		if !isPreconditionFailed(err) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("acquiring lock: %w", lock.ErrLocked)
+}
+
+// ForceUnlock deletes the lock object regardless of its holder.
+func (g *GoogleBucket) ForceUnlock(ctx context.Context) error {
+	err := g.loadClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = g.client.Bucket(g.cfg.Bucket).Object(lock.File).Delete(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("deleting lock: %w", err)
+	}
+
+	return nil
+}
+
+// writeLockObject writes the info into the lock object, and returns the generation of the object written.
+func writeLockObject(ctx context.Context, obj *storage.ObjectHandle, info lock.Info) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := obj.NewWriter(ctx)
+	w.ContentType = "application/json"
+
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		// Cancel the context to abort the upload.
+		cancel()
+
+		_ = w.Close() //nolint:errcheck
+
+		return 0, fmt.Errorf("writing lock: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return 0, fmt.Errorf("writing lock: %w", err)
+	}
+
+	return w.Attrs().Generation, nil
+}
+
+// readLockObject reads the info of the lock object, along with its generation.
+func readLockObject(ctx context.Context, obj *storage.ObjectHandle) (lock.Info, int64, error) {
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		return lock.Info{}, 0, fmt.Errorf("reading lock: %w", err)
+	}
+
+	defer r.Close() //nolint:errcheck
+
+	var info lock.Info
+
+	if err := json.NewDecoder(r).Decode(&info); err != nil {
+		return lock.Info{}, 0, fmt.Errorf("decoding lock: %w", err)
+	}
+
+	return info, r.Attrs.Generation, nil
+}
+
+// isPreconditionFailed returns whether the error is caused by a failed precondition of the request.
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
+
+// bucketLease is the lease of the lock object.
+type bucketLease struct {
+	obj        *storage.ObjectHandle
+	info       lock.Info
+	generation int64
+}
+
+// Renew rewrites the lock object with the renewed expiry on condition its generation did not change, failing with
+// lock.ErrLost otherwise.
+func (l *bucketLease) Renew(ctx context.Context) error {
+	info := l.info.Renewed(time.Now())
+
+	generation, err := writeLockObject(ctx, l.obj.If(storage.Conditions{GenerationMatch: l.generation}), info)
+	if isPreconditionFailed(err) {
+		return fmt.Errorf("%w: %w", lock.ErrLost, err)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	l.info = info
+	l.generation = generation
+
+	return nil
+}
+
+// Release deletes the lock object on condition its generation did not change, failing with lock.ErrLost otherwise.
+func (l *bucketLease) Release(ctx context.Context) error {
+	err := l.obj.If(storage.Conditions{GenerationMatch: l.generation}).Delete(ctx)
+	if isPreconditionFailed(err) || errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%w: %w", lock.ErrLost, err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("deleting lock: %w", err)
+	}
+
+	return nil
+}
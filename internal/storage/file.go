@@ -2,9 +2,16 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path"
+	"time"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/lock"
 )
 
 // FileSystemType is the type of the FileSystem storage.
@@ -25,40 +32,368 @@ func NewFileSystem(dir, file string) *FileSystem {
 	}
 }
 
-// Load loads the data from the file.
-func (f *FileSystem) Load(_ context.Context) ([]byte, error) {
-	data, err := os.ReadFile(path.Join(f.dir, f.file))
+// Open opens the file to be read as a stream.
+func (f *FileSystem) Open(ctx context.Context) (io.ReadCloser, error) {
+	return f.OpenFile(ctx, f.file)
+}
+
+// OpenFile opens the file, as listed by ListFiles, to be read as a stream.
+func (f *FileSystem) OpenFile(ctx context.Context, file string) (io.ReadCloser, error) {
+	return f.OpenStep(ctx, file)
+}
+
+// ListFiles returns the files of the directory matching the file, when it is a glob pattern or a prefix, sorted by
+// name. Otherwise, it returns the file.
+//
+// When no file matches, the error wraps fs.ErrNotExist.
+func (f *FileSystem) ListFiles(_ context.Context) ([]string, error) {
+	if !isPattern(f.file) {
+		return []string{f.file}, nil
+	}
+
+	var files []string
+
+	err := fs.WalkDir(os.DirFS(f.dir), path.Dir(listPrefix(f.file)), func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		ok, err := matchPattern(f.file, name)
+		if ok {
+			files = append(files, name)
+		}
+
+		return err
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("listing files: %w", err)
+	}
+
+	if len(files) == 0 {
+		return nil, errNoMatch(f.file)
+	}
+
+	return files, nil
+}
+
+// OpenStep opens the step file to be read as a stream.
+//
+// When the file does not exist, the error wraps fs.ErrNotExist.
+func (f *FileSystem) OpenStep(_ context.Context, file string) (io.ReadCloser, error) {
+	st, err := os.Open(path.Join(f.dir, file)) //nolint:gosec
 	if err != nil {
 		return nil, fmt.Errorf("opening file: %w", err)
 	}
 
-	return data, nil
+	return st, nil
 }
 
-// LoadStep loads the data from the file.
-func (f *FileSystem) LoadStep(_ context.Context, file string) ([]byte, error) {
-	data, err := os.ReadFile(path.Join(f.dir, file)) //nolint:gosec
+// CreateStep creates the step file to be written as a stream.
+//
+// The data is written into a temporary file in the same directory, which is renamed to the step file when the writer
+// is closed, so the step file is never left partially written. When the context is canceled before closing the writer,
+// the temporary file is removed. The directories of the step file are created when they do not exist.
+func (f *FileSystem) CreateStep(ctx context.Context, file string) (io.WriteCloser, error) {
+	stepPath := path.Join(f.dir, file)
+
+	if err := os.MkdirAll(path.Dir(stepPath), 0o750); err != nil {
+		return nil, fmt.Errorf("creating directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(path.Dir(stepPath), path.Base(file)+".*.tmp")
 	if err != nil {
-		return nil, fmt.Errorf("opening file: %w", err)
+		return nil, fmt.Errorf("creating temporary file: %w", err)
 	}
 
-	return data, nil
+	return &fileStepWriter{
+		ctx:  ctx,
+		tmp:  tmp,
+		path: stepPath,
+	}, nil
 }
 
-// SaveStep saves the data to the file.
-func (f *FileSystem) SaveStep(_ context.Context, file string, data []byte) error {
-	st, err := os.Create(path.Join(f.dir, file)) //nolint:gosec
+// fileStepWriter writes the step data into a temporary file and renames it to the step file on close.
+type fileStepWriter struct {
+	ctx context.Context //nolint:containedctx
+
+	tmp  *os.File
+	path string
+}
+
+// Write writes the data into the temporary file.
+func (w *fileStepWriter) Write(p []byte) (int, error) {
+	return w.tmp.Write(p)
+}
+
+// Close flushes the temporary file and renames it to the step file.
+//
+// When the context is canceled, the temporary file is removed instead.
+func (w *fileStepWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		_ = w.tmp.Close()           //nolint:errcheck
+		_ = os.Remove(w.tmp.Name()) //nolint:errcheck
+
+		return err
+	}
+
+	// Flush and ensure data is written to disk.
+	if err := w.tmp.Sync(); err != nil {
+		_ = w.tmp.Close()           //nolint:errcheck
+		_ = os.Remove(w.tmp.Name()) //nolint:errcheck
+
+		return err
+	}
+
+	if err := w.tmp.Close(); err != nil {
+		_ = os.Remove(w.tmp.Name()) //nolint:errcheck
+
+		return err
+	}
+
+	if err := os.Rename(w.tmp.Name(), w.path); err != nil {
+		_ = os.Remove(w.tmp.Name()) //nolint:errcheck
+
+		return fmt.Errorf("renaming temporary file: %w", err)
+	}
+
+	return nil
+}
+
+// maxLockAttempts is the number of attempts to acquire the lock, when the lock file is released or taken over
+// concurrently.
+const maxLockAttempts = 3
+
+// Acquire acquires the lease of the dir, creating the lock file with the PID and the expiry of the lease.
+//
+// The lock file is written into a temporary file, and linked to the lock file, which fails when it exists, so the lock
+// file is never read partially written. The lock file whose lease expired is taken over: it is moved aside, and checked
+// to be the expired one, so two runs taking over the same lock do not both hold it.
+func (f *FileSystem) Acquire(_ context.Context, ttl time.Duration) (lock.Lease, error) {
+	if err := os.MkdirAll(f.dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating directory: %w", err)
+	}
+
+	lockPath := path.Join(f.dir, lock.File)
+	info := lock.NewInfo(ttl)
+
+	for range maxLockAttempts {
+		err := f.createLockFile(lockPath, info)
+		if err == nil {
+			return &fileLease{dir: f.dir, path: lockPath, info: info}, nil
+		}
+
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		held, err := readLockFile(lockPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Released meanwhile.
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !held.Expired(time.Now()) {
+			return nil, lock.Locked(held)
+		}
+
+		if err := takeOverLockFile(lockPath, held, info.Token); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("acquiring lock: %w", lock.ErrLocked)
+}
+
+// ForceUnlock removes the lock file regardless of its holder.
+func (f *FileSystem) ForceUnlock(_ context.Context) error {
+	err := os.Remove(path.Join(f.dir, lock.File))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing lock file: %w", err)
+	}
+
+	return nil
+}
+
+// createLockFile creates the lock file with the info, failing with fs.ErrExist when it exists.
+func (f *FileSystem) createLockFile(lockPath string, info lock.Info) error {
+	tmp, err := writeLockTemp(f.dir, info)
 	if err != nil {
 		return err
 	}
 
-	defer st.Close() //nolint:errcheck
+	defer os.Remove(tmp) //nolint:errcheck
+
+	if err := os.Link(tmp, lockPath); err != nil {
+		return fmt.Errorf("creating lock file: %w", err)
+	}
+
+	return nil
+}
 
-	_, err = st.Write(data)
+// writeLockTemp writes the info into a temporary file of the dir, and returns its path.
+func writeLockTemp(dir string, info lock.Info) (string, error) {
+	tmp, err := os.CreateTemp(dir, lock.File+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary lock file: %w", err)
+	}
+
+	if err := json.NewEncoder(tmp).Encode(info); err != nil {
+		_ = tmp.Close()           //nolint:errcheck
+		_ = os.Remove(tmp.Name()) //nolint:errcheck
+
+		return "", fmt.Errorf("writing temporary lock file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name()) //nolint:errcheck
+
+		return "", fmt.Errorf("writing temporary lock file: %w", err)
+	}
+
+	return tmp.Name(), nil
+}
+
+// readLockFile reads the info of the lock file.
+func readLockFile(lockPath string) (lock.Info, error) {
+	data, err := os.ReadFile(lockPath) //nolint:gosec
+	if err != nil {
+		return lock.Info{}, fmt.Errorf("reading lock file: %w", err)
+	}
+
+	var info lock.Info
+
+	if err := json.Unmarshal(data, &info); err != nil {
+		return lock.Info{}, fmt.Errorf("decoding lock file: %w", err)
+	}
+
+	return info, nil
+}
+
+// takeOverLockFile removes the expired lock file, moving it aside first, and restoring it when it is not the expired
+// one, i.e. it was taken over by another run meanwhile.
+func takeOverLockFile(lockPath string, expired lock.Info, token string) error {
+	aside := lockPath + "." + token + ".expired"
+
+	if err := os.Rename(lockPath, aside); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Released meanwhile.
+			return nil
+		}
+
+		return fmt.Errorf("moving expired lock file: %w", err)
+	}
+
+	defer os.Remove(aside) //nolint:errcheck
+
+	moved, err := readLockFile(aside)
 	if err != nil {
 		return err
 	}
 
-	// Flush and ensure data is written to disk.
-	return st.Sync()
+	if moved.Token != expired.Token {
+		// The lock file is held by the run which took it over meanwhile, restore it. The restore fails when a third
+		// run created the lock file meanwhile, the run holding the moved lock file then loses it.
+		_ = os.Link(aside, lockPath) //nolint:errcheck
+	}
+
+	return nil
+}
+
+// fileLease is the lease of the lock file.
+type fileLease struct {
+	dir  string
+	path string
+	info lock.Info
+}
+
+// Renew rewrites the lock file with the renewed expiry, failing with lock.ErrLost when it is not held by the lease.
+//
+// The lock file is moved aside and checked to be held by the lease before it is replaced, as it is taken over by
+// Acquire, so the lock file taken over by another run meanwhile is never overwritten.
+func (l *fileLease) Renew(_ context.Context) error {
+	info := l.info.Renewed(time.Now())
+
+	tmp, err := writeLockTemp(l.dir, info)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp) //nolint:errcheck
+
+	aside, err := l.moveAside()
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(aside) //nolint:errcheck
+
+	if err := os.Link(tmp, l.path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			// Another run acquired the lock while it was moved aside.
+			return fmt.Errorf("%w, acquired while renewing", lock.ErrLost)
+		}
+
+		// Restore the lock file of the lease.
+		_ = os.Link(aside, l.path) //nolint:errcheck
+
+		return fmt.Errorf("renewing lock file: %w", err)
+	}
+
+	l.info = info
+
+	return nil
+}
+
+// Release removes the lock file, failing with lock.ErrLost when it is not held by the lease.
+func (l *fileLease) Release(_ context.Context) error {
+	aside, err := l.moveAside()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(aside); err != nil {
+		return fmt.Errorf("removing lock file: %w", err)
+	}
+
+	return nil
+}
+
+// moveAside moves the lock file aside, and returns its path once checked to be held by the lease. The lock file is
+// restored when it is not held by the lease, failing with lock.ErrLost.
+//
+// Moving the lock file is atomic, so the lease checks the lock file no other run can take over meanwhile.
+func (l *fileLease) moveAside() (string, error) {
+	aside := l.path + "." + l.info.Token + ".held"
+
+	if err := os.Rename(l.path, aside); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", lock.ErrLost
+		}
+
+		return "", fmt.Errorf("moving lock file: %w", err)
+	}
+
+	held, err := readLockFile(aside)
+	if err == nil && held.Token != l.info.Token {
+		err = fmt.Errorf("%w, taken over by %s pid %d", lock.ErrLost, held.Host, held.PID)
+	}
+
+	if err != nil {
+		// The restore fails when another run created the lock file meanwhile, the run holding the moved lock file then
+		// loses it, as when it is taken over.
+		_ = os.Link(aside, l.path) //nolint:errcheck
+		_ = os.Remove(aside)       //nolint:errcheck
+
+		return "", err
+	}
+
+	return aside, nil
 }
@@ -2,51 +2,253 @@ package storage_test
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/dohernandez/horizon-blockchain-games/internal/lock"
 	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
 )
 
-func TestFile_Load(t *testing.T) {
+func TestFile_Open(t *testing.T) {
 	t.Parallel()
 
 	ctx := context.Background()
 
-	data, err := storage.NewFileSystem("../../resources/sample-bucket/", "sample_data.csv").Load(ctx)
+	reader, err := storage.NewFileSystem("../../resources/sample-bucket/", "sample_data.csv").Open(ctx)
 	require.NoError(t, err)
 
-	require.NotNil(t, data)
+	t.Cleanup(func() {
+		require.NoError(t, reader.Close())
+	})
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	require.NotEmpty(t, data)
 }
 
-func TestFile_LoadStep(t *testing.T) {
+func TestFile_OpenStep(t *testing.T) {
 	t.Parallel()
 
 	ctx := context.Background()
 
-	data, err := storage.NewFileSystem("testdata", "").LoadStep(ctx, "extraction.csv")
+	reader, err := storage.NewFileSystem("testdata", "").OpenStep(ctx, "extraction.csv")
 	require.NoError(t, err)
 
-	require.NotNil(t, data)
+	t.Cleanup(func() {
+		require.NoError(t, reader.Close())
+	})
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	require.NotEmpty(t, data)
 }
 
-func TestFile_SaveStep(t *testing.T) {
+func TestFile_CreateStep(t *testing.T) {
 	t.Parallel()
 
 	ctx := context.Background()
 
-	data := []byte("sample data")
+	dir := t.TempDir()
+
+	f := storage.NewFileSystem(dir, "")
 
-	f := storage.NewFileSystem("testdata", "")
-	err := f.SaveStep(ctx, "calculation.csv", data)
+	w, err := f.CreateStep(ctx, "calculation.csv")
 	require.NoError(t, err)
 
-	t.Cleanup(func() {
-		err := os.Remove("testdata/calculation.csv")
-		require.NoError(t, err)
-	})
+	_, err = w.Write([]byte("sample data"))
+	require.NoError(t, err)
+
+	// The step file is not visible until the writer is closed.
+	require.NoFileExists(t, dir+"/calculation.csv")
+
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(dir + "/calculation.csv")
+	require.NoError(t, err)
+	require.Equal(t, "sample data", string(data))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestFile_CreateStep_nested(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir := t.TempDir()
+
+	f := storage.NewFileSystem(dir, "")
+
+	w, err := f.CreateStep(ctx, "date=2024-04-15/part-0.csv")
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("sample data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(dir + "/date=2024-04-15/part-0.csv")
+	require.NoError(t, err)
+	require.Equal(t, "sample data", string(data))
+}
+
+func TestFile_CreateStep_canceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	dir := t.TempDir()
+
+	f := storage.NewFileSystem(dir, "")
+
+	err := os.WriteFile(dir+"/calculation.csv", []byte("previous data"), 0o600)
+	require.NoError(t, err)
+
+	w, err := f.CreateStep(ctx, "calculation.csv")
+	require.NoError(t, err)
 
-	require.FileExists(t, "testdata/calculation.csv")
+	_, err = w.Write([]byte("partial data"))
+	require.NoError(t, err)
+
+	cancel()
+
+	require.ErrorIs(t, w.Close(), context.Canceled)
+
+	// The previous step data is kept and the temporary file is removed.
+	data, err := os.ReadFile(dir + "/calculation.csv")
+	require.NoError(t, err)
+	require.Equal(t, "previous data", string(data))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestFile_ListFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	for _, file := range []string{
+		"events/2024-04-15/00.csv",
+		"events/2024-04-15/01.csv",
+		"events/2024-04-15/manifest.json",
+		"events/2024-04-16/00.csv",
+	} {
+		require.NoError(t, os.MkdirAll(path.Dir(path.Join(dir, file)), 0o750))
+		require.NoError(t, os.WriteFile(path.Join(dir, file), nil, 0o600))
+	}
+
+	tests := []struct {
+		file     string
+		expected []string
+		err      error
+	}{
+		{
+			file:     "events/2024-04-15/*.csv",
+			expected: []string{"events/2024-04-15/00.csv", "events/2024-04-15/01.csv"},
+		},
+		{
+			file:     "events/*/00.csv",
+			expected: []string{"events/2024-04-15/00.csv", "events/2024-04-16/00.csv"},
+		},
+		{
+			file:     "events/2024-04-15/",
+			expected: []string{"events/2024-04-15/00.csv", "events/2024-04-15/01.csv", "events/2024-04-15/manifest.json"},
+		},
+		{
+			// The file is not listed when it is not a pattern.
+			file:     "sample_data.csv",
+			expected: []string{"sample_data.csv"},
+		},
+		{
+			file: "events/2024-04-17/*.csv",
+			err:  fs.ErrNotExist,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			t.Parallel()
+
+			files, err := storage.NewFileSystem(dir, tt.file).ListFiles(context.Background())
+			require.ErrorIs(t, err, tt.err)
+			require.Equal(t, tt.expected, files)
+		})
+	}
+}
+
+func TestFile_Acquire(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	st := storage.NewFileSystem(t.TempDir(), "")
+
+	lease, err := st.Acquire(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// The lock is held by the lease.
+	_, err = st.Acquire(ctx, time.Minute)
+	require.ErrorIs(t, err, lock.ErrLocked)
+	require.ErrorContains(t, err, fmt.Sprintf("pid %d", os.Getpid()))
+
+	require.NoError(t, lease.Renew(ctx))
+	require.NoError(t, lease.Release(ctx))
+
+	// The lock is acquired again once released.
+	lease, err = st.Acquire(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// The lock is acquired regardless of its holder once forced to unlock.
+	require.NoError(t, st.ForceUnlock(ctx))
+	require.NoError(t, st.ForceUnlock(ctx))
+
+	_, err = st.Acquire(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// The lease whose lock was taken over is lost.
+	require.ErrorIs(t, lease.Renew(ctx), lock.ErrLost)
+	require.ErrorIs(t, lease.Release(ctx), lock.ErrLost)
+}
+
+func TestFile_Acquire_expired(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	st := storage.NewFileSystem(dir, "")
+
+	expired, err := st.Acquire(ctx, -time.Second)
+	require.NoError(t, err)
+
+	// The lock whose lease expired is taken over.
+	lease, err := st.Acquire(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// The lease taken over is lost, and the lock file of the run which took it over is kept.
+	require.ErrorIs(t, expired.Renew(ctx), lock.ErrLost)
+	require.ErrorIs(t, expired.Release(ctx), lock.ErrLost)
+
+	_, err = st.Acquire(ctx, time.Minute)
+	require.ErrorIs(t, err, lock.ErrLocked)
+
+	require.NoError(t, lease.Renew(ctx))
+	require.NoError(t, lease.Release(ctx))
+
+	// No file is left behind.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
 }
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// globMeta are the meta characters of the glob patterns, see path.Match.
+const globMeta = `*?[\`
+
+// isPattern reports whether the file is a glob pattern, events/2024-04-15/*.csv, or a prefix, events/2024-04-15/,
+// matching several files.
+func isPattern(file string) bool {
+	return strings.ContainsAny(file, globMeta) || strings.HasSuffix(file, "/")
+}
+
+// listPrefix returns the prefix to list the files matching the pattern, the part of the pattern before the first
+// meta character.
+func listPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, globMeta); i >= 0 {
+		return pattern[:i]
+	}
+
+	return pattern
+}
+
+// matchPattern reports whether the name matches the glob pattern, or starts with the prefix.
+//
+// The names ending with a slash, used as directory placeholders in the buckets, never match.
+func matchPattern(pattern, name string) (bool, error) {
+	if strings.HasSuffix(name, "/") {
+		return false, nil
+	}
+
+	if !strings.ContainsAny(pattern, globMeta) {
+		return strings.HasPrefix(name, pattern), nil
+	}
+
+	ok, err := path.Match(pattern, name)
+	if err != nil {
+		return false, fmt.Errorf("matching %s: %w", pattern, err)
+	}
+
+	return ok, nil
+}
+
+// errNoMatch returns the error when no file matches the pattern, wrapping fs.ErrNotExist.
+func errNoMatch(pattern string) error {
+	return fmt.Errorf("listing files: no file matches %s: %w", pattern, fs.ErrNotExist)
+}
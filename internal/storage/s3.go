@@ -0,0 +1,488 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bool64/ctxd"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/lock"
+)
+
+// S3Type is the type of the S3 storage.
+const S3Type = "s3"
+
+// S3Config holds the configuration for the Amazon S3, or S3-compatible such as MinIO, bucket.
+type S3Config struct {
+	Bucket string
+	File   string
+
+	// Region is the region of the bucket.
+	// If it is empty, the region is resolved from the AWS shared configuration or the AWS_REGION environment variable.
+	Region string
+	// Endpoint is the endpoint of the S3-compatible service, such as http://localhost:9000 for a local MinIO.
+	// If it is empty, the Amazon S3 endpoint of the region is used.
+	Endpoint string
+	// UsePathStyle is to address the bucket in the path of the URL, http://localhost:9000/bucket/file, instead of the
+	// host, as required by most S3-compatible services.
+	UsePathStyle bool
+}
+
+// S3 is a storage that save/loads data to/from an Amazon S3, or S3-compatible, bucket.
+//
+// The credentials are resolved from the AWS default credential chain, such as the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables.
+//
+// The step data is locked by the lock object of the bucket, written with the S3 conditional writes.
+type S3 struct {
+	cfg S3Config
+
+	// client is the S3 client.
+	client *s3.Client
+
+	once sync.Once
+
+	logger ctxd.Logger
+
+	clientOpts []func(*s3.Options)
+}
+
+// S3Option is a convenience type which will be used to modify S3 private fields.
+type S3Option func(s *S3)
+
+// WithS3Logger configures the logger of a S3.
+func WithS3Logger(logger ctxd.Logger) S3Option {
+	return func(s *S3) {
+		if logger == nil {
+			return
+		}
+
+		s.logger = logger
+	}
+}
+
+// WithS3ClientOptions configures the options of the S3 client, such as the credentials.
+// It is mainly used for testing purposes.
+func WithS3ClientOptions(opts ...func(*s3.Options)) S3Option {
+	return func(s *S3) {
+		s.clientOpts = append(s.clientOpts, opts...)
+	}
+}
+
+// NewS3 creates a new S3 source.
+func NewS3(cfg S3Config, opts ...S3Option) *S3 {
+	s := &S3{
+		cfg:    cfg,
+		logger: ctxd.NoOpLogger{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Open opens the file from the S3 bucket to be read as a stream.
+func (s *S3) Open(ctx context.Context) (io.ReadCloser, error) {
+	return s.OpenFile(ctx, s.cfg.File)
+}
+
+// OpenFile opens the file from the S3 bucket, as listed by ListFiles, to be read as a stream.
+func (s *S3) OpenFile(ctx context.Context, file string) (io.ReadCloser, error) {
+	return s.OpenStep(ctx, file)
+}
+
+func (s *S3) loadClient(ctx context.Context) error {
+	var err error
+
+	s.once.Do(func() {
+		s.logger.Debug(ctx, "creating S3 client")
+
+		var awsCfg aws.Config
+
+		awsCfg, err = config.LoadDefaultConfig(ctx)
+		if err != nil {
+			err = fmt.Errorf("loading AWS configuration: %w", err)
+
+			return
+		}
+
+		opts := []func(*s3.Options){
+			func(o *s3.Options) {
+				o.UsePathStyle = s.cfg.UsePathStyle
+
+				if s.cfg.Region != "" {
+					o.Region = s.cfg.Region
+				}
+
+				if s.cfg.Endpoint != "" {
+					s.logger.Debug(ctx, "using custom endpoint", "endpoint", s.cfg.Endpoint)
+
+					o.BaseEndpoint = aws.String(s.cfg.Endpoint)
+				}
+			},
+		}
+
+		s.client = s3.NewFromConfig(awsCfg, append(opts, s.clientOpts...)...)
+
+		s.logger.Debug(ctx, "S3 client created")
+	})
+
+	return err
+}
+
+// ListFiles returns the objects of the S3 bucket matching the file, when it is a glob pattern or a prefix, sorted by
+// name. Otherwise, it returns the file.
+//
+// When no object matches, the error wraps fs.ErrNotExist.
+func (s *S3) ListFiles(ctx context.Context) ([]string, error) {
+	if !isPattern(s.cfg.File) {
+		return []string{s.cfg.File}, nil
+	}
+
+	err := s.loadClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Debug(ctx, "listing objects", "bucket", s.cfg.Bucket, "pattern", s.cfg.File)
+
+	var files []string
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.cfg.Bucket),
+		Prefix: aws.String(listPrefix(s.cfg.File)),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing objects: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			ok, err := matchPattern(s.cfg.File, aws.ToString(obj.Key))
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				files = append(files, aws.ToString(obj.Key))
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errNoMatch(s.cfg.File)
+	}
+
+	slices.Sort(files)
+
+	return files, nil
+}
+
+// OpenStep opens the file from the S3 bucket to be read as a stream.
+//
+// When the file does not exist, the error wraps fs.ErrNotExist.
+func (s *S3) OpenStep(ctx context.Context, file string) (io.ReadCloser, error) {
+	err := s.loadClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Debug(ctx, "creating reader", "bucket", s.cfg.Bucket, "file", file)
+
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(file),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("creating reader: %w: %w", fs.ErrNotExist, err)
+		}
+
+		return nil, fmt.Errorf("creating reader: %w", err)
+	}
+
+	return out.Body, nil
+}
+
+// CreateStep creates the file in the S3 bucket to be written as a stream.
+//
+// The data is uploaded in parts as it is written, and the object is created when the writer is closed.
+// When the context is canceled before closing the writer, the upload is aborted.
+func (s *S3) CreateStep(ctx context.Context, file string) (io.WriteCloser, error) {
+	err := s.loadClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Debug(ctx, "creating writer", "bucket", s.cfg.Bucket, "file", file)
+
+	pr, pw := io.Pipe()
+
+	w := &s3StepWriter{
+		ctx:  ctx,
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+
+	go func() {
+		_, err := manager.NewUploader(s.client).Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.cfg.Bucket),
+			Key:    aws.String(file),
+			Body:   pr,
+		})
+		if err != nil {
+			err = fmt.Errorf("uploading file: %w", err)
+		}
+
+		// Unblock the writes when the upload fails.
+		_ = pr.CloseWithError(err) //nolint:errcheck
+
+		w.done <- err
+	}()
+
+	return w, nil
+}
+
+// s3StepWriter writes the step data into the upload of the S3 object.
+type s3StepWriter struct {
+	ctx context.Context //nolint:containedctx
+
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// Write writes the data into the upload.
+func (w *s3StepWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close completes the upload and waits for the object to be created.
+//
+// When the context is canceled, the upload is aborted instead.
+func (w *s3StepWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		_ = w.pw.CloseWithError(err) //nolint:errcheck
+
+		<-w.done
+
+		return err
+	}
+
+	_ = w.pw.Close() //nolint:errcheck
+
+	return <-w.done
+}
+
+// Acquire acquires the lease of the bucket, creating the lock object with the PID and the expiry of the lease.
+//
+// The lock object is created on condition it does not exist, and the lock object whose lease expired is taken over on
+// condition its ETag did not change, so two runs never hold the lock at the same time.
+func (s *S3) Acquire(ctx context.Context, ttl time.Duration) (lock.Lease, error) {
+	err := s.loadClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := lock.NewInfo(ttl)
+
+	for range maxLockAttempts {
+		etag, err := s.writeLockObject(ctx, info, func(in *s3.PutObjectInput) {
+			in.IfNoneMatch = aws.String("*")
+		})
+		if err == nil {
+			return &s3Lease{s: s, info: info, etag: etag}, nil
+		}
+
+		if !isS3PreconditionFailed(err) {
+			return nil, err
+		}
+
+		held, heldETag, err := s.readLockObject(ctx)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Released meanwhile.
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !held.Expired(time.Now()) {
+			return nil, lock.Locked(held)
+		}
+
+		s.logger.Debug(ctx, "taking over expired lock", "bucket", s.cfg.Bucket, "host", held.Host, "pid", held.PID)
+
+		etag, err = s.writeLockObject(ctx, info, func(in *s3.PutObjectInput) {
+			in.IfMatch = aws.String(heldETag)
+		})
+		if err == nil {
+			return &s3Lease{s: s, info: info, etag: etag}, nil
+		}
+
+		if !isS3PreconditionFailed(err) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("acquiring lock: %w", lock.ErrLocked)
+}
+
+// ForceUnlock deletes the lock object regardless of its holder.
+func (s *S3) ForceUnlock(ctx context.Context) error {
+	err := s.loadClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(lock.File),
+	})
+	if err != nil {
+		return fmt.Errorf("deleting lock: %w", err)
+	}
+
+	return nil
+}
+
+// writeLockObject writes the info into the lock object on the condition set by cond, and returns the ETag of the
+// object written.
+func (s *S3) writeLockObject(ctx context.Context, info lock.Info, cond func(in *s3.PutObjectInput)) (string, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return "", fmt.Errorf("encoding lock: %w", err)
+	}
+
+	in := &s3.PutObjectInput{
+		Bucket:      aws.String(s.cfg.Bucket),
+		Key:         aws.String(lock.File),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}
+
+	cond(in)
+
+	out, err := s.client.PutObject(ctx, in)
+	if err != nil {
+		return "", fmt.Errorf("writing lock: %w", err)
+	}
+
+	return aws.ToString(out.ETag), nil
+}
+
+// readLockObject reads the info of the lock object, along with its ETag.
+//
+// When the lock object does not exist, the error wraps fs.ErrNotExist.
+func (s *S3) readLockObject(ctx context.Context) (lock.Info, string, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(lock.File),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+
+		if errors.As(err, &noSuchKey) {
+			return lock.Info{}, "", fmt.Errorf("reading lock: %w: %w", fs.ErrNotExist, err)
+		}
+
+		return lock.Info{}, "", fmt.Errorf("reading lock: %w", err)
+	}
+
+	defer out.Body.Close() //nolint:errcheck
+
+	var info lock.Info
+
+	if err := json.NewDecoder(out.Body).Decode(&info); err != nil {
+		return lock.Info{}, "", fmt.Errorf("decoding lock: %w", err)
+	}
+
+	return info, aws.ToString(out.ETag), nil
+}
+
+// isS3PreconditionFailed returns whether the error is caused by a failed condition of the request, or by a conflicting
+// conditional request on the same object.
+func isS3PreconditionFailed(err error) bool {
+	var respErr *awshttp.ResponseError
+
+	if !errors.As(err, &respErr) {
+		return false
+	}
+
+	return respErr.HTTPStatusCode() == http.StatusPreconditionFailed || respErr.HTTPStatusCode() == http.StatusConflict
+}
+
+// s3Lease is the lease of the lock object.
+type s3Lease struct {
+	s    *S3
+	info lock.Info
+	etag string
+}
+
+// Renew rewrites the lock object with the renewed expiry on condition its ETag did not change, failing with
+// lock.ErrLost otherwise.
+func (l *s3Lease) Renew(ctx context.Context) error {
+	info := l.info.Renewed(time.Now())
+
+	etag, err := l.s.writeLockObject(ctx, info, func(in *s3.PutObjectInput) {
+		in.IfMatch = aws.String(l.etag)
+	})
+	if isS3PreconditionFailed(err) || isS3NotFound(err) {
+		return fmt.Errorf("%w: %w", lock.ErrLost, err)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	l.info = info
+	l.etag = etag
+
+	return nil
+}
+
+// Release deletes the lock object on condition its ETag did not change, failing with lock.ErrLost otherwise.
+func (l *s3Lease) Release(ctx context.Context) error {
+	_, err := l.s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:  aws.String(l.s.cfg.Bucket),
+		Key:     aws.String(lock.File),
+		IfMatch: aws.String(l.etag),
+	})
+	if isS3PreconditionFailed(err) || isS3NotFound(err) {
+		return fmt.Errorf("%w: %w", lock.ErrLost, err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("deleting lock: %w", err)
+	}
+
+	return nil
+}
+
+// isS3NotFound returns whether the error is caused by the object of the conditional request not existing.
+func isS3NotFound(err error) bool {
+	var respErr *awshttp.ResponseError
+
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
+}
@@ -0,0 +1,334 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/lock"
+	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
+)
+
+// fakeS3 is a fake path-style S3 API storing the objects in memory by path, honoring the If-Match and If-None-Match
+// conditions against the ETag of the objects, which is the number of the write of the object.
+type fakeS3 struct {
+	objects map[string][]byte
+	etags   map[string]string
+	writes  int
+	sm      sync.Mutex
+}
+
+// etag returns the ETag of the object, set when it was written.
+func (f *fakeS3) etag(p string) string {
+	if etag, ok := f.etags[p]; ok {
+		return etag
+	}
+
+	return `"0"`
+}
+
+// preconditionFailed returns whether the If-Match or If-None-Match condition of the request does not hold.
+func (f *fakeS3) preconditionFailed(r *http.Request) bool {
+	_, exists := f.objects[r.URL.Path]
+
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		return true
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && (!exists || f.etag(r.URL.Path) != ifMatch) {
+		return true
+	}
+
+	return false
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.sm.Lock()
+	defer f.sm.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if f.preconditionFailed(r) {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+
+			return
+		}
+
+		if f.etags == nil {
+			f.etags = map[string]string{}
+		}
+
+		f.writes++
+
+		f.objects[r.URL.Path] = data
+		f.etags[r.URL.Path] = `"` + strconv.Itoa(f.writes) + `"`
+
+		w.Header().Set("ETag", f.etags[r.URL.Path])
+	case r.Method == http.MethodDelete:
+		if f.preconditionFailed(r) {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+
+			return
+		}
+
+		delete(f.objects, r.URL.Path)
+		delete(f.etags, r.URL.Path)
+
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Query().Has("list-type"):
+		f.list(w, r)
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+
+			return
+		}
+
+		w.Header().Set("ETag", f.etag(r.URL.Path))
+
+		_, _ = w.Write(data) //nolint:errcheck
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeS3Error writes the error of the S3 API with the status and the code.
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>` + code + `</Code></Error>`)) //nolint:errcheck
+}
+
+// list lists the objects of the bucket with the prefix, in a single page.
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	bucket := strings.Trim(r.URL.Path, "/")
+	prefix := r.URL.Query().Get("prefix")
+
+	var keys []string
+
+	for p := range f.objects {
+		key := strings.TrimPrefix(p, "/"+bucket+"/")
+
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+
+	var contents strings.Builder
+
+	for _, key := range keys {
+		contents.WriteString("<Contents><Key>" + key + "</Key></Contents>")
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+
+	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>` + bucket + //nolint:errcheck
+		`</Name><IsTruncated>false</IsTruncated>` + contents.String() + `</ListBucketResult>`))
+}
+
+// newTestS3 creates a S3 storage of the bucket sequence against the fake S3 API.
+func newTestS3(t *testing.T, fake *fakeS3, file string) *storage.S3 {
+	t.Helper()
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	return storage.NewS3(
+		storage.S3Config{
+			Bucket:       "sequence",
+			File:         file,
+			Region:       "us-east-1",
+			Endpoint:     srv.URL,
+			UsePathStyle: true,
+		},
+		storage.WithS3ClientOptions(func(o *s3.Options) {
+			o.Credentials = aws.AnonymousCredentials{}
+		}),
+	)
+}
+
+func TestS3_CreateStep(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fake := &fakeS3{objects: map[string][]byte{
+		"/sequence/sample_data.csv": []byte("sample data"),
+	}}
+
+	s := newTestS3(t, fake, "sample_data.csv")
+
+	// The extract file is read from the bucket.
+	reader, err := s.Open(ctx)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "sample data", string(data))
+
+	// The step file is uploaded when the writer is closed.
+	w, err := s.CreateStep(ctx, "extraction.csv")
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("step data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	reader, err = s.OpenStep(ctx, "extraction.csv")
+	require.NoError(t, err)
+
+	data, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "step data", string(data))
+	require.Equal(t, []byte("step data"), fake.objects["/sequence/extraction.csv"])
+}
+
+func TestS3_OpenStep_notExist(t *testing.T) {
+	t.Parallel()
+
+	s := newTestS3(t, &fakeS3{objects: map[string][]byte{}}, "sample_data.csv")
+
+	_, err := s.OpenStep(context.Background(), "extraction.csv")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestS3_CreateStep_canceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	fake := &fakeS3{objects: map[string][]byte{}}
+
+	s := newTestS3(t, fake, "sample_data.csv")
+
+	w, err := s.CreateStep(ctx, "extraction.csv")
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte(strings.Repeat("partial data", 10)))
+	require.NoError(t, err)
+
+	cancel()
+
+	require.ErrorIs(t, w.Close(), context.Canceled)
+	require.Empty(t, fake.objects)
+}
+
+func TestS3_ListFiles(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeS3{objects: map[string][]byte{
+		"/sequence/events/2024-04-15/01.csv":        nil,
+		"/sequence/events/2024-04-15/00.csv":        nil,
+		"/sequence/events/2024-04-15/":              nil,
+		"/sequence/events/2024-04-15/sub/00.csv":    nil,
+		"/sequence/events/2024-04-16/00.csv":        nil,
+		"/sequence/events/2024-04-15/manifest.json": nil,
+	}}
+
+	files, err := newTestS3(t, fake, "events/2024-04-15/*.csv").ListFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"events/2024-04-15/00.csv", "events/2024-04-15/01.csv"}, files)
+
+	files, err = newTestS3(t, fake, "events/2024-04-15/").ListFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"events/2024-04-15/00.csv",
+		"events/2024-04-15/01.csv",
+		"events/2024-04-15/manifest.json",
+		"events/2024-04-15/sub/00.csv",
+	}, files)
+
+	_, err = newTestS3(t, fake, "events/2024-04-17/").ListFiles(context.Background())
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestS3_Acquire(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fake := &fakeS3{objects: map[string][]byte{}}
+	s := newTestS3(t, fake, "sample_data.csv")
+
+	lease, err := s.Acquire(ctx, time.Minute)
+	require.NoError(t, err)
+	require.Contains(t, fake.objects, "/sequence/"+lock.File)
+
+	// The lock is held by the lease.
+	_, err = s.Acquire(ctx, time.Minute)
+	require.ErrorIs(t, err, lock.ErrLocked)
+	require.ErrorContains(t, err, fmt.Sprintf("pid %d", os.Getpid()))
+
+	require.NoError(t, lease.Renew(ctx))
+	require.NoError(t, lease.Release(ctx))
+	require.Empty(t, fake.objects)
+
+	// The lock is acquired again once released.
+	lease, err = s.Acquire(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// The lock is acquired regardless of its holder once forced to unlock.
+	require.NoError(t, s.ForceUnlock(ctx))
+	require.NoError(t, s.ForceUnlock(ctx))
+
+	_, err = s.Acquire(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// The lease whose lock was taken over is lost.
+	require.ErrorIs(t, lease.Renew(ctx), lock.ErrLost)
+	require.ErrorIs(t, lease.Release(ctx), lock.ErrLost)
+}
+
+func TestS3_Acquire_expired(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fake := &fakeS3{objects: map[string][]byte{}}
+	s := newTestS3(t, fake, "sample_data.csv")
+
+	expired, err := s.Acquire(ctx, -time.Second)
+	require.NoError(t, err)
+
+	// The lock whose lease expired is taken over.
+	lease, err := s.Acquire(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// The lease taken over is lost, and the lock object of the run which took it over is kept.
+	require.ErrorIs(t, expired.Renew(ctx), lock.ErrLost)
+	require.ErrorIs(t, expired.Release(ctx), lock.ErrLost)
+
+	_, err = s.Acquire(ctx, time.Minute)
+	require.ErrorIs(t, err, lock.ErrLocked)
+
+	require.NoError(t, lease.Renew(ctx))
+	require.NoError(t, lease.Release(ctx))
+	require.Empty(t, fake.objects)
+}
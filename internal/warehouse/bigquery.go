@@ -1,11 +1,17 @@
 package warehouse
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/option"
 
 	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
 )
@@ -13,51 +19,426 @@ import (
 // BigQueryType is the type of the BigQuery warehouse.
 const BigQueryType = "bigquery"
 
+const (
+	// StreamInsertMode inserts the rows in batches using the streaming API.
+	StreamInsertMode = "stream"
+	// LoadInsertMode writes the rows into a CSV file in a Google Cloud Storage bucket and loads it with a load job.
+	LoadInsertMode = "load"
+)
+
 // BigQueryConfig is the configuration for BigQuery.
 type BigQueryConfig struct {
 	ProjectID string
 	Dataset   string
 	Table     string
+
+	// InsertMode is the mode to insert the rows, either StreamInsertMode or LoadInsertMode.
+	// If it is empty, it will be set to StreamInsertMode.
+	InsertMode string
+
+	// BatchSize is the number of rows inserted per streaming request in StreamInsertMode.
+	// If it is 0, it will be set to 500.
+	BatchSize int
+
+	// LoadBucket is the Google Cloud Storage bucket where the rows are written in LoadInsertMode.
+	LoadBucket string
+	// LoadFile is the file in the LoadBucket where the rows are written in LoadInsertMode. The runs sharing the
+	// LoadBucket must write different files, e.g. <run-id>/insertion.csv.
+	// If it is empty, it will be set to insertion.csv.
+	LoadFile string
+
+	// WriteMode is the mode to write the rows into the table, either AppendWriteMode, UpsertWriteMode or
+	// ReplacePartitionWriteMode. If it is empty, it will be set to AppendWriteMode.
+	//
+	// In UpsertWriteMode and ReplacePartitionWriteMode, the rows are loaded into a staging table on Flush and then
+	// merged into the table, so re-runs are idempotent. The InsertMode is ignored.
+	WriteMode string
+
+	// AutoMigrate is to create the table, or add the missing columns, before the first insert, see Migrate.
+	AutoMigrate bool
+}
+
+// LoadStorage is the storage to write the rows loaded by the load jobs, such as storage.GoogleBucket.
+type LoadStorage interface {
+	// CreateStep creates the file to be written as a stream.
+	CreateStep(ctx context.Context, file string) (io.WriteCloser, error)
 }
 
 // BigQuery is a target for BigQuery.
+//
+// The rows are buffered by Save and written by Flush, or in StreamInsertMode, every time the batch is full.
 type BigQuery struct {
 	cfg BigQueryConfig
 
 	client *bigquery.Client
+	schema bigquery.Schema
+
+	clientOpts  []option.ClientOption
+	loadStorage LoadStorage
+
+	// batch holds the rows not yet inserted in StreamInsertMode.
+	batch []bigquery.ValueSaver
 
-	once sync.Once
+	// staged holds the rows to be merged in UpsertWriteMode and ReplacePartitionWriteMode.
+	staged []entities.Flatten
+
+	// loadWriter and csvWriter write the rows into the load file in LoadInsertMode.
+	loadWriter io.WriteCloser
+	csvWriter  *csv.Writer
+	loadCancel context.CancelFunc
+
+	sm          sync.Mutex
+	once        sync.Once
+	migrateOnce sync.Once
+}
+
+// BigQueryOption is a convenience type which will be used to modify BigQuery private fields.
+type BigQueryOption func(b *BigQuery)
+
+// WithClientOptions configures the options of the BigQuery client.
+func WithClientOptions(opts ...option.ClientOption) BigQueryOption {
+	return func(b *BigQuery) {
+		b.clientOpts = append(b.clientOpts, opts...)
+	}
+}
+
+// WithLoadStorage configures the storage to write the rows loaded in LoadInsertMode.
+func WithLoadStorage(st LoadStorage) BigQueryOption {
+	return func(b *BigQuery) {
+		if st == nil {
+			return
+		}
+
+		b.loadStorage = st
+	}
 }
 
 // NewBigQuery creates a new BigQuery target.
-func NewBigQuery(cfg BigQueryConfig) *BigQuery {
-	return &BigQuery{
+func NewBigQuery(cfg BigQueryConfig, opts ...BigQueryOption) *BigQuery {
+	b := &BigQuery{
 		cfg: cfg,
 	}
+
+	if b.cfg.InsertMode == "" {
+		b.cfg.InsertMode = StreamInsertMode
+	}
+
+	if b.cfg.BatchSize == 0 {
+		b.cfg.BatchSize = 500
+	}
+
+	if b.cfg.LoadFile == "" {
+		b.cfg.LoadFile = "insertion.csv"
+	}
+
+	if b.cfg.WriteMode == "" {
+		b.cfg.WriteMode = AppendWriteMode
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
 }
 
-// Save saves the flatten entity into BigQuery.
-func (b *BigQuery) Save(ctx context.Context, f entities.Flatten) error {
+// loadClient creates the BigQuery client the first time it is called.
+func (b *BigQuery) loadClient(ctx context.Context) error {
 	var err error
 
 	b.once.Do(func() {
-		b.client, err = bigquery.NewClient(ctx, b.cfg.ProjectID)
+		b.client, err = bigquery.NewClient(ctx, b.cfg.ProjectID, b.clientOpts...)
 		if err != nil {
 			err = fmt.Errorf("creating BigQuery client: %w", err)
 
 			return
 		}
+
+		b.schema, err = flattenSchema()
 	})
 
-	if err != nil {
+	return err
+}
+
+// prepare creates the BigQuery client and, when AutoMigrate is enabled, migrates the table the first time it is called.
+func (b *BigQuery) prepare(ctx context.Context) error {
+	if err := b.loadClient(ctx); err != nil {
 		return err
 	}
 
+	if !b.cfg.AutoMigrate {
+		return nil
+	}
+
+	var err error
+
+	b.migrateOnce.Do(func() {
+		_, err = b.Migrate(ctx)
+	})
+
+	return err
+}
+
+// Save buffers the flatten entity to be saved into BigQuery.
+func (b *BigQuery) Save(ctx context.Context, f entities.Flatten) error {
+	if err := b.prepare(ctx); err != nil {
+		return err
+	}
+
+	b.sm.Lock()
+	defer b.sm.Unlock()
+
+	if b.cfg.WriteMode != AppendWriteMode {
+		b.staged = append(b.staged, f)
+
+		return nil
+	}
+
+	if b.cfg.InsertMode == LoadInsertMode {
+		return b.write(ctx, f)
+	}
+
+	b.batch = append(b.batch, &bigquery.StructSaver{Schema: b.schema, Struct: f})
+
+	if len(b.batch) < b.cfg.BatchSize {
+		return nil
+	}
+
+	return b.put(ctx)
+}
+
+// Flush saves the buffered flatten entities into BigQuery.
+func (b *BigQuery) Flush(ctx context.Context) error {
+	b.sm.Lock()
+	defer b.sm.Unlock()
+
+	if b.cfg.WriteMode != AppendWriteMode {
+		return b.merge(ctx)
+	}
+
+	if b.cfg.InsertMode == LoadInsertMode {
+		return b.load(ctx)
+	}
+
+	return b.put(ctx)
+}
+
+// Close closes the BigQuery client.
+func (b *BigQuery) Close() error {
+	if b.client == nil {
+		return nil
+	}
+
+	return b.client.Close()
+}
+
+// put inserts the batch of rows using the streaming API.
+func (b *BigQuery) put(ctx context.Context) error {
+	if len(b.batch) == 0 {
+		return nil
+	}
+
 	inserter := b.client.Dataset(b.cfg.Dataset).Table(b.cfg.Table).Inserter()
 
-	if err = inserter.Put(ctx, f); err != nil {
+	if err := inserter.Put(ctx, b.batch); err != nil {
 		return fmt.Errorf("inserting data: %w", err)
 	}
 
+	b.batch = b.batch[:0]
+
 	return nil
 }
+
+// write writes the row into the load file, creating it the first time it is called.
+func (b *BigQuery) write(ctx context.Context, f entities.Flatten) error {
+	if b.csvWriter == nil {
+		if b.loadStorage == nil {
+			return fmt.Errorf("load storage is required in %s insert mode", LoadInsertMode)
+		}
+
+		// The context is canceled to discard the load file when the rows can not be written.
+		var wctx context.Context
+
+		wctx, b.loadCancel = context.WithCancel(ctx)
+
+		w, err := b.loadStorage.CreateStep(wctx, b.cfg.LoadFile)
+		if err != nil {
+			b.loadCancel()
+
+			return fmt.Errorf("creating load file: %w", err)
+		}
+
+		b.loadWriter = w
+		b.csvWriter = csv.NewWriter(w)
+	}
+
+	if err := b.csvWriter.Write(f.Encode()); err != nil {
+		b.loadCancel()
+
+		return fmt.Errorf("writing load file: %w", err)
+	}
+
+	return nil
+}
+
+// load closes the load file and loads it into BigQuery with a load job.
+func (b *BigQuery) load(ctx context.Context) error {
+	if b.csvWriter == nil {
+		return nil
+	}
+
+	defer b.loadCancel()
+
+	b.csvWriter.Flush()
+
+	if err := b.csvWriter.Error(); err != nil {
+		b.loadCancel()
+
+		_ = b.loadWriter.Close() //nolint:errcheck
+
+		return fmt.Errorf("writing load file: %w", err)
+	}
+
+	if err := b.loadWriter.Close(); err != nil {
+		return fmt.Errorf("closing load file: %w", err)
+	}
+
+	b.csvWriter = nil
+	b.loadWriter = nil
+
+	ref := bigquery.NewGCSReference(fmt.Sprintf("gs://%s/%s", b.cfg.LoadBucket, b.cfg.LoadFile))
+	ref.SourceFormat = bigquery.CSV
+	// The CSV columns are matched by position, the schema of the rows maps them to the columns of the table, and the
+	// columns added to the table after them, e.g. by AutoMigrate, are loaded as null.
+	ref.Schema = b.schema
+	ref.AllowJaggedRows = true
+
+	loader := b.client.Dataset(b.cfg.Dataset).Table(b.cfg.Table).LoaderFrom(ref)
+	loader.WriteDisposition = bigquery.WriteAppend
+
+	if err := b.runJob(ctx, loader); err != nil {
+		return fmt.Errorf("loading data: %w", err)
+	}
+
+	return nil
+}
+
+// merge loads the staged rows into a staging table and merges them into the table according to the write mode.
+//
+// The staging table expires after a day, in case it can not be deleted.
+func (b *BigQuery) merge(ctx context.Context) error {
+	if len(b.staged) == 0 {
+		return nil
+	}
+
+	dataset := b.client.Dataset(b.cfg.Dataset)
+
+	staging := dataset.Table(fmt.Sprintf("%s_staging_%d", b.cfg.Table, time.Now().UnixNano()))
+
+	err := staging.Create(ctx, &bigquery.TableMetadata{
+		Schema:         b.schema,
+		ExpirationTime: time.Now().Add(24 * time.Hour),
+	})
+	if err != nil {
+		return fmt.Errorf("creating staging table: %w", err)
+	}
+
+	defer staging.Delete(context.WithoutCancel(ctx)) //nolint:errcheck
+
+	var buf bytes.Buffer
+
+	w := csv.NewWriter(&buf)
+
+	for _, f := range b.staged {
+		if err = w.Write(f.Encode()); err != nil {
+			return fmt.Errorf("writing staging rows: %w", err)
+		}
+	}
+
+	w.Flush()
+
+	if err = w.Error(); err != nil {
+		return fmt.Errorf("writing staging rows: %w", err)
+	}
+
+	source := bigquery.NewReaderSource(&buf)
+	source.SourceFormat = bigquery.CSV
+
+	if err = b.runJob(ctx, staging.LoaderFrom(source)); err != nil {
+		return fmt.Errorf("loading staging table: %w", err)
+	}
+
+	q := b.client.Query(mergeQuery(b.cfg.WriteMode, b.table(b.cfg.Table), b.table(staging.TableID), b.schema))
+
+	if err = b.runJob(ctx, q); err != nil {
+		return fmt.Errorf("merging staging table: %w", err)
+	}
+
+	b.staged = b.staged[:0]
+
+	return nil
+}
+
+// jobRunner runs a BigQuery job, such as a bigquery.Loader or a bigquery.Query.
+type jobRunner interface {
+	Run(ctx context.Context) (*bigquery.Job, error)
+}
+
+// runJob runs the job and waits for its completion.
+func (b *BigQuery) runJob(ctx context.Context, r jobRunner) error {
+	job, err := r.Run(ctx)
+	if err != nil {
+		return fmt.Errorf("running job: %w", err)
+	}
+
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting job %s: %w", job.ID(), err)
+	}
+
+	if err = status.Err(); err != nil {
+		return fmt.Errorf("job %s: %w", job.ID(), err)
+	}
+
+	return nil
+}
+
+// table returns the fully qualified name of the table in the dataset.
+func (b *BigQuery) table(name string) string {
+	return fmt.Sprintf("`%s.%s.%s`", b.cfg.ProjectID, b.cfg.Dataset, name)
+}
+
+// mergeQuery returns the query merging the staging table into the target table according to the write mode.
+//
+// The rows are keyed by date and project id, the columns are the ones of the schema.
+func mergeQuery(writeMode, target, staging string, schema bigquery.Schema) string {
+	columns := make([]string, 0, len(schema))
+	values := make([]string, 0, len(schema))
+	updates := make([]string, 0, len(schema))
+
+	for _, field := range schema {
+		columns = append(columns, field.Name)
+		values = append(values, "S."+field.Name)
+
+		if field.Name != "date" && field.Name != "project_id" {
+			updates = append(updates, fmt.Sprintf("%[1]s = S.%[1]s", field.Name))
+		}
+	}
+
+	if writeMode == ReplacePartitionWriteMode {
+		return fmt.Sprintf(`BEGIN TRANSACTION;
+DELETE FROM %[1]s WHERE date IN (SELECT DISTINCT date FROM %[2]s);
+INSERT INTO %[1]s (%[3]s)
+SELECT %[3]s FROM %[2]s;
+COMMIT TRANSACTION;`, target, staging, strings.Join(columns, ", "))
+	}
+
+	return fmt.Sprintf(`MERGE %s T
+USING %s S
+ON T.date = S.date AND T.project_id = S.project_id
+WHEN MATCHED THEN
+  UPDATE SET %s
+WHEN NOT MATCHED THEN
+  INSERT (%s)
+  VALUES (%s)`, target, staging, strings.Join(updates, ", "), strings.Join(columns, ", "), strings.Join(values, ", "))
+}
@@ -0,0 +1,122 @@
+package warehouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/googleapi"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+)
+
+// PlanMigration returns the DDL statements to create the table, or to add the columns missing in the table, so it
+// matches the schema inferred from the bigquery struct tags of entities.Flatten.
+//
+// The table is created partitioned by date and clustered by project id. The columns are always added as nullable,
+// the columns removed from or changed in entities.Flatten are not migrated.
+func (b *BigQuery) PlanMigration(ctx context.Context) ([]string, error) {
+	if err := b.loadClient(ctx); err != nil {
+		return nil, err
+	}
+
+	md, err := b.client.Dataset(b.cfg.Dataset).Table(b.cfg.Table).Metadata(ctx)
+	if err != nil {
+		var gErr *googleapi.Error
+
+		if errors.As(err, &gErr) && gErr.Code == http.StatusNotFound {
+			return []string{createTableDDL(b.table(b.cfg.Table), b.schema)}, nil
+		}
+
+		return nil, fmt.Errorf("getting table metadata: %w", err)
+	}
+
+	existing := make(map[string]bool, len(md.Schema))
+
+	for _, field := range md.Schema {
+		existing[strings.ToLower(field.Name)] = true
+	}
+
+	var ddl []string
+
+	for _, field := range b.schema {
+		if existing[strings.ToLower(field.Name)] {
+			continue
+		}
+
+		ddl = append(ddl, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s",
+			b.table(b.cfg.Table), field.Name, ddlType(field.Type)))
+	}
+
+	return ddl, nil
+}
+
+// Migrate creates the table, or adds the columns missing in the table, and returns the DDL statements applied.
+//
+// See PlanMigration.
+func (b *BigQuery) Migrate(ctx context.Context) ([]string, error) {
+	ddl, err := b.PlanMigration(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range ddl {
+		if err = b.runJob(ctx, b.client.Query(stmt)); err != nil {
+			return nil, fmt.Errorf("migrating table: %w", err)
+		}
+	}
+
+	return ddl, nil
+}
+
+// createTableDDL returns the DDL statement to create the table with the given schema, partitioned by date and
+// clustered by project id.
+func createTableDDL(table string, schema bigquery.Schema) string {
+	columns := make([]string, 0, len(schema))
+
+	for _, field := range schema {
+		columns = append(columns, fmt.Sprintf("    %s %s", field.Name, ddlType(field.Type)))
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n)\nPARTITION BY date\nCLUSTER BY project_id",
+		table, strings.Join(columns, ",\n"))
+}
+
+// ddlType returns the GoogleSQL type of the field type, which differs from the legacy name for some types.
+func ddlType(ft bigquery.FieldType) string {
+	switch ft { //nolint:exhaustive
+	case bigquery.IntegerFieldType:
+		return "INT64"
+	case bigquery.FloatFieldType:
+		return "FLOAT64"
+	case bigquery.BooleanFieldType:
+		return "BOOL"
+	default:
+		return string(ft)
+	}
+}
+
+// flattenSchema infers the BigQuery schema of the flatten entity.
+//
+// BigQuery infers *big.Rat fields as NUMERIC, which only holds 9 decimal digits. The total volume is stored as
+// BIGNUMERIC to keep the precision of the tokens with up to 18 decimals. The date is stored as DATE.
+func flattenSchema() (bigquery.Schema, error) {
+	schema, err := bigquery.InferSchema(entities.Flatten{})
+	if err != nil {
+		return nil, fmt.Errorf("inferring schema: %w", err)
+	}
+
+	for _, field := range schema {
+		switch field.Name {
+		case "date":
+			field.Type = bigquery.DateFieldType
+		case "total_volume_usd":
+			field.Type = bigquery.BigNumericFieldType
+		}
+	}
+
+	return schema, nil
+}
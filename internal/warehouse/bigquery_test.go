@@ -0,0 +1,468 @@
+package warehouse_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
+	"github.com/dohernandez/horizon-blockchain-games/internal/warehouse"
+)
+
+func TestBigQuery_Save_batch(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Fake BigQuery API recording the rows of each insert request.
+	var (
+		batches [][]map[string]any
+		sm      sync.Mutex
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/projects/project/datasets/sequence/tables/sample_data/insertAll") {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		var req struct {
+			Rows []struct {
+				JSON map[string]any `json:"json"`
+			} `json:"rows"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		rows := make([]map[string]any, 0, len(req.Rows))
+
+		for _, row := range req.Rows {
+			rows = append(rows, row.JSON)
+		}
+
+		sm.Lock()
+		batches = append(batches, rows)
+		sm.Unlock()
+
+		_, _ = w.Write([]byte(`{"kind":"bigquery#tableDataInsertAllResponse"}`)) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	b := warehouse.NewBigQuery(
+		warehouse.BigQueryConfig{
+			ProjectID: "project",
+			Dataset:   "sequence",
+			Table:     "sample_data",
+			BatchSize: 2,
+		},
+		warehouse.WithClientOptions(option.WithEndpoint(srv.URL), option.WithoutAuthentication()),
+	)
+
+	for _, projectID := range []string{"4974", "1660", "0"} {
+		err := b.Save(ctx, entities.Flatten{
+			Date:        "2024-04-15",
+			ProjectID:   projectID,
+			NumTxs:      1,
+			TotalVolume: big.NewRat(1, 10),
+		})
+		require.NoError(t, err)
+	}
+
+	// The first batch is inserted when it is full.
+	require.Len(t, batches, 1)
+	require.Len(t, batches[0], 2)
+
+	// The remaining rows are inserted on flush.
+	require.NoError(t, b.Flush(ctx))
+	require.Len(t, batches, 2)
+	require.Equal(t, []map[string]any{
+		{
+			"date":             "2024-04-15",
+			"project_id":       "0",
+			"num_transactions": float64(1),
+			"total_volume_usd": "0.10000000000000000000000000000000000000",
+		},
+	}, batches[1])
+
+	// Nothing is inserted when there are no buffered rows.
+	require.NoError(t, b.Flush(ctx))
+	require.Len(t, batches, 2)
+
+	require.NoError(t, b.Close())
+}
+
+// fakeBigQueryJobs is a fake BigQuery API running the jobs instantly, recording the data loaded and the queries run.
+type fakeBigQueryJobs struct {
+	loads   []string
+	queries []string
+	tables  []string
+	deleted []string
+
+	// loadJobs is the configuration of the load jobs from a Google Cloud Storage file.
+	loadJobs []map[string]any
+
+	// metadata is the metadata of the existing tables by name.
+	metadata map[string]map[string]any
+
+	jobs map[string]map[string]any
+	sm   sync.Mutex
+}
+
+func (f *fakeBigQueryJobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.sm.Lock()
+	defer f.sm.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/datasets/sequence/tables"):
+		body, _ := io.ReadAll(r.Body) //nolint:errcheck
+
+		var table struct {
+			TableReference struct {
+				TableID string `json:"tableId"`
+			} `json:"tableReference"`
+		}
+
+		_ = json.Unmarshal(body, &table) //nolint:errcheck
+
+		f.tables = append(f.tables, table.TableReference.TableID)
+
+		_, _ = w.Write(body) //nolint:errcheck
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/datasets/sequence/tables/"):
+		md, ok := f.metadata[path.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(md) //nolint:errcheck
+	case r.Method == http.MethodDelete && strings.Contains(r.URL.Path, "/datasets/sequence/tables/"):
+		f.deleted = append(f.deleted, path.Base(r.URL.Path))
+
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/projects/project/jobs"):
+		job := f.insertJob(r)
+
+		_ = json.NewEncoder(w).Encode(job) //nolint:errcheck
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/projects/project/jobs/"):
+		_ = json.NewEncoder(w).Encode(f.jobs[path.Base(r.URL.Path)]) //nolint:errcheck
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/projects/project/queries/"):
+		_ = json.NewEncoder(w).Encode(map[string]any{ //nolint:errcheck
+			"jobComplete":  true,
+			"jobReference": f.jobs[path.Base(r.URL.Path)]["jobReference"],
+			"schema":       map[string]any{"fields": []any{}},
+			"totalRows":    "0",
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// insertJob records the job, reading the data of the load jobs from the multipart body.
+func (f *fakeBigQueryJobs) insertJob(r *http.Request) map[string]any {
+	var job map[string]any
+
+	_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type")) //nolint:errcheck
+
+	if boundary, ok := params["boundary"]; ok {
+		mr := multipart.NewReader(r.Body, boundary)
+
+		part, _ := mr.NextPart()               //nolint:errcheck
+		_ = json.NewDecoder(part).Decode(&job) //nolint:errcheck
+		part, _ = mr.NextPart()                //nolint:errcheck
+		data, _ := io.ReadAll(part)            //nolint:errcheck
+		f.loads = append(f.loads, string(data))
+	} else {
+		_ = json.NewDecoder(r.Body).Decode(&job) //nolint:errcheck
+
+		cfg := job["configuration"].(map[string]any) //nolint:forcetypeassert
+
+		if load, ok := cfg["load"].(map[string]any); ok {
+			f.loadJobs = append(f.loadJobs, load)
+		} else {
+			f.queries = append(f.queries, cfg["query"].(map[string]any)["query"].(string)) //nolint:forcetypeassert
+		}
+	}
+
+	job["status"] = map[string]any{"state": "DONE"}
+
+	if f.jobs == nil {
+		f.jobs = make(map[string]map[string]any)
+	}
+
+	f.jobs[job["jobReference"].(map[string]any)["jobId"].(string)] = job //nolint:forcetypeassert
+
+	return job
+}
+
+func TestBigQuery_Flush_load(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fake := &fakeBigQueryJobs{}
+
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dir := t.TempDir()
+
+	b := warehouse.NewBigQuery(
+		warehouse.BigQueryConfig{
+			ProjectID:  "project",
+			Dataset:    "sequence",
+			Table:      "sample_data",
+			InsertMode: warehouse.LoadInsertMode,
+			LoadBucket: "bucket",
+			LoadFile:   "run/insertion.csv",
+		},
+		warehouse.WithClientOptions(option.WithEndpoint(srv.URL), option.WithoutAuthentication()),
+		warehouse.WithLoadStorage(storage.NewFileSystem(dir, "")),
+	)
+
+	for _, projectID := range []string{"4974", "1660"} {
+		err := b.Save(ctx, entities.Flatten{
+			Date:        "2024-04-15",
+			ProjectID:   projectID,
+			NumTxs:      1,
+			TotalVolume: big.NewRat(1, 10),
+		})
+		require.NoError(t, err)
+	}
+
+	// Nothing is loaded until flush.
+	require.Empty(t, fake.loadJobs)
+
+	require.NoError(t, b.Flush(ctx))
+
+	// The rows are staged into the load file.
+	data, err := os.ReadFile(filepath.Join(dir, "run", "insertion.csv"))
+	require.NoError(t, err)
+	require.Equal(t, "2024-04-15,4974,1,0.1\n2024-04-15,1660,1,0.1\n", string(data))
+
+	// The load file is appended to the table with a load job.
+	require.Len(t, fake.loadJobs, 1)
+
+	load := fake.loadJobs[0]
+
+	require.Equal(t, []any{"gs://bucket/run/insertion.csv"}, load["sourceUris"])
+	require.Equal(t, "CSV", load["sourceFormat"])
+	require.Equal(t, true, load["allowJaggedRows"])
+
+	// The columns of the file are mapped to the columns of the table by the schema of the rows.
+	var columns []string
+
+	for _, field := range load["schema"].(map[string]any)["fields"].([]any) { //nolint:forcetypeassert
+		columns = append(columns, field.(map[string]any)["name"].(string)) //nolint:forcetypeassert
+	}
+
+	require.Equal(t, []string{"date", "project_id", "num_transactions", "total_volume_usd"}, columns)
+	require.Equal(t, "WRITE_APPEND", load["writeDisposition"])
+	require.Equal(t, map[string]any{
+		"projectId": "project",
+		"datasetId": "sequence",
+		"tableId":   "sample_data",
+	}, load["destinationTable"])
+	require.Empty(t, fake.loads)
+	require.Empty(t, fake.queries)
+
+	// Nothing is loaded when there are no buffered rows.
+	require.NoError(t, b.Flush(ctx))
+	require.Len(t, fake.loadJobs, 1)
+}
+
+func TestBigQuery_Flush_write_mode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		writeMode string
+		query     string
+	}{
+		{
+			writeMode: warehouse.UpsertWriteMode,
+			query:     "MERGE `project.sequence.sample_data` T\nUSING `project.sequence.%s` S\nON T.date = S.date AND T.project_id = S.project_id",
+		},
+		{
+			writeMode: warehouse.ReplacePartitionWriteMode,
+			query:     "BEGIN TRANSACTION;\nDELETE FROM `project.sequence.sample_data` WHERE date IN (SELECT DISTINCT date FROM `project.sequence.%s`);",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.writeMode, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			fake := &fakeBigQueryJobs{}
+
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			b := warehouse.NewBigQuery(
+				warehouse.BigQueryConfig{
+					ProjectID: "project",
+					Dataset:   "sequence",
+					Table:     "sample_data",
+					WriteMode: tt.writeMode,
+				},
+				warehouse.WithClientOptions(option.WithEndpoint(srv.URL), option.WithoutAuthentication()),
+			)
+
+			for _, projectID := range []string{"4974", "1660"} {
+				err := b.Save(ctx, entities.Flatten{
+					Date:        "2024-04-15",
+					ProjectID:   projectID,
+					NumTxs:      1,
+					TotalVolume: big.NewRat(1, 10),
+				})
+				require.NoError(t, err)
+			}
+
+			// Nothing is written until flush.
+			require.Empty(t, fake.tables)
+
+			require.NoError(t, b.Flush(ctx))
+
+			// The rows are loaded into a staging table, merged into the table and the staging table is deleted.
+			require.Len(t, fake.tables, 1)
+
+			staging := fake.tables[0]
+
+			require.True(t, strings.HasPrefix(staging, "sample_data_staging_"))
+			require.Equal(t, []string{"2024-04-15,4974,1,0.1\n2024-04-15,1660,1,0.1\n"}, fake.loads)
+			require.Len(t, fake.queries, 1)
+			require.True(t, strings.HasPrefix(fake.queries[0], fmt.Sprintf(tt.query, staging)), fake.queries[0])
+			require.Equal(t, []string{staging}, fake.deleted)
+		})
+	}
+}
+
+func TestBigQuery_PlanMigration(t *testing.T) {
+	t.Parallel()
+
+	tableMetadata := func(fields ...string) map[string]map[string]any {
+		schemaFields := make([]map[string]any, 0, len(fields))
+
+		for _, field := range fields {
+			name, typ, _ := strings.Cut(field, " ")
+
+			schemaFields = append(schemaFields, map[string]any{"name": name, "type": typ, "mode": "NULLABLE"})
+		}
+
+		return map[string]map[string]any{
+			"sample_data": {
+				"tableReference": map[string]any{"projectId": "project", "datasetId": "sequence", "tableId": "sample_data"},
+				"schema":         map[string]any{"fields": schemaFields},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[string]map[string]any
+		expected []string
+	}{
+		{
+			name: "missing table",
+			expected: []string{
+				"CREATE TABLE IF NOT EXISTS `project.sequence.sample_data` (\n" +
+					"    date DATE,\n" +
+					"    project_id STRING,\n" +
+					"    num_transactions INT64,\n" +
+					"    total_volume_usd BIGNUMERIC\n" +
+					")\n" +
+					"PARTITION BY date\n" +
+					"CLUSTER BY project_id",
+			},
+		},
+		{
+			name:     "missing column",
+			metadata: tableMetadata("date DATE", "project_id STRING", "num_transactions INTEGER"),
+			expected: []string{
+				"ALTER TABLE `project.sequence.sample_data` ADD COLUMN IF NOT EXISTS total_volume_usd BIGNUMERIC",
+			},
+		},
+		{
+			name:     "up to date",
+			metadata: tableMetadata("date DATE", "project_id STRING", "num_transactions INTEGER", "total_volume_usd BIGNUMERIC"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			fake := &fakeBigQueryJobs{metadata: tt.metadata}
+
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			b := warehouse.NewBigQuery(
+				warehouse.BigQueryConfig{
+					ProjectID: "project",
+					Dataset:   "sequence",
+					Table:     "sample_data",
+				},
+				warehouse.WithClientOptions(option.WithEndpoint(srv.URL), option.WithoutAuthentication()),
+			)
+
+			ddl, err := b.PlanMigration(ctx)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, ddl)
+
+			// The plan is not applied.
+			require.Empty(t, fake.queries)
+		})
+	}
+}
+
+func TestBigQuery_Migrate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fake := &fakeBigQueryJobs{}
+
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b := warehouse.NewBigQuery(
+		warehouse.BigQueryConfig{
+			ProjectID: "project",
+			Dataset:   "sequence",
+			Table:     "sample_data",
+		},
+		warehouse.WithClientOptions(option.WithEndpoint(srv.URL), option.WithoutAuthentication()),
+	)
+
+	ddl, err := b.Migrate(ctx)
+	require.NoError(t, err)
+	require.Len(t, ddl, 1)
+	require.Equal(t, ddl, fake.queries)
+}
@@ -0,0 +1,195 @@
+package warehouse
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+)
+
+// FileType is the type of the file warehouse.
+const FileType = "file"
+
+const (
+	// CSVFormat writes the rows as CSV with a header row.
+	CSVFormat = "csv"
+	// JSONLFormat writes the rows as JSON Lines.
+	JSONLFormat = "jsonl"
+	// ParquetFormat writes the rows as Parquet.
+	ParquetFormat = "parquet"
+)
+
+// FileStorage is the storage to write the files, such as storage.FileSystem or storage.GoogleBucket.
+type FileStorage interface {
+	// CreateStep creates the file to be written as a stream.
+	CreateStep(ctx context.Context, file string) (io.WriteCloser, error)
+}
+
+// FileConfig is the configuration for the file warehouse.
+type FileConfig struct {
+	// Format is the format of the files, either CSVFormat, JSONLFormat or ParquetFormat.
+	// If it is empty, it will be set to CSVFormat.
+	Format string
+}
+
+// File is a target writing the rows into date partitioned files, date=2024-04-15/part-0.csv, of the storage.
+//
+// The rows are buffered by Save and written by Flush. The file of each date is overwritten on re-runs, so the write
+// mode does not apply.
+type File struct {
+	cfg FileConfig
+
+	storage FileStorage
+
+	// rows holds the buffered rows by date.
+	rows map[string][]entities.Flatten
+
+	sm sync.Mutex
+}
+
+// NewFile creates a new file target.
+func NewFile(cfg FileConfig, storage FileStorage) *File {
+	f := &File{
+		cfg:     cfg,
+		storage: storage,
+		rows:    make(map[string][]entities.Flatten),
+	}
+
+	if f.cfg.Format == "" {
+		f.cfg.Format = CSVFormat
+	}
+
+	return f
+}
+
+// Save buffers the flatten entity to be written into the file of its date.
+func (f *File) Save(_ context.Context, fl entities.Flatten) error {
+	f.sm.Lock()
+	defer f.sm.Unlock()
+
+	f.rows[fl.Date] = append(f.rows[fl.Date], fl)
+
+	return nil
+}
+
+// Flush writes the buffered flatten entities into the files of their dates.
+func (f *File) Flush(ctx context.Context) error {
+	f.sm.Lock()
+	defer f.sm.Unlock()
+
+	dates := make([]string, 0, len(f.rows))
+
+	for date := range f.rows {
+		dates = append(dates, date)
+	}
+
+	slices.Sort(dates)
+
+	for _, date := range dates {
+		if err := f.write(ctx, PartitionFile(date, f.cfg.Format), f.rows[date]); err != nil {
+			return err
+		}
+
+		delete(f.rows, date)
+	}
+
+	return nil
+}
+
+// write writes the rows into the file in the configured format.
+//
+// The file is discarded when the rows can not be written, the previous file, if any, is kept.
+func (f *File) write(ctx context.Context, file string, rows []entities.Flatten) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := f.storage.CreateStep(ctx, file)
+	if err != nil {
+		return fmt.Errorf("creating file %s: %w", file, err)
+	}
+
+	switch f.cfg.Format {
+	case JSONLFormat:
+		err = writeJSONL(w, rows)
+	case ParquetFormat:
+		err = writeParquet(w, rows)
+	default:
+		err = writeCSV(w, rows)
+	}
+
+	if err != nil {
+		// Cancel the context to discard the partially written file.
+		cancel()
+
+		_ = w.Close() //nolint:errcheck
+
+		return fmt.Errorf("writing file %s: %w", file, err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("closing file %s: %w", file, err)
+	}
+
+	return nil
+}
+
+// PartitionFile returns the file of the date in the given format, date=2024-04-15/part-0.parquet.
+func PartitionFile(date, format string) string {
+	return path.Join("date="+date, "part-0."+format)
+}
+
+// newFileRows converts the flatten entities into the records written into the JSON Lines and Parquet files.
+func newFileRows(fs []entities.Flatten) []entities.FlattenRecord {
+	rows := make([]entities.FlattenRecord, 0, len(fs))
+
+	for _, f := range fs {
+		rows = append(rows, f.Record())
+	}
+
+	return rows
+}
+
+// writeCSV writes the rows as CSV with a header row.
+func writeCSV(w io.Writer, fs []entities.Flatten) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(entities.FlattenHeader()); err != nil {
+		return err
+	}
+
+	for _, f := range fs {
+		if err := cw.Write(f.Encode()); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// writeJSONL writes the rows as JSON Lines.
+func writeJSONL(w io.Writer, fs []entities.Flatten) error {
+	enc := json.NewEncoder(w)
+
+	for _, row := range newFileRows(fs) {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeParquet writes the rows as Parquet.
+func writeParquet(w io.Writer, fs []entities.Flatten) error {
+	return parquet.Write(w, newFileRows(fs))
+}
@@ -0,0 +1,148 @@
+package warehouse_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+	"github.com/dohernandez/horizon-blockchain-games/internal/storage"
+	"github.com/dohernandez/horizon-blockchain-games/internal/warehouse"
+)
+
+// saveFiles saves the rows with a file warehouse of the given format into the directory.
+func saveFiles(t *testing.T, dir, format string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	f := warehouse.NewFile(warehouse.FileConfig{Format: format}, storage.NewFileSystem(dir, ""))
+
+	for _, fl := range []entities.Flatten{
+		{Date: "2024-04-15", ProjectID: "4974", NumTxs: 2, TotalVolume: big.NewRat(1, 3)},
+		{Date: "2024-04-14", ProjectID: "1660", NumTxs: 1, TotalVolume: big.NewRat(15, 2)},
+		{Date: "2024-04-15", ProjectID: "0", NumTxs: 1, TotalVolume: big.NewRat(-1, 10)},
+	} {
+		require.NoError(t, f.Save(ctx, fl))
+	}
+
+	// Nothing is written until flush.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.NoError(t, f.Flush(ctx))
+}
+
+func TestFile_Flush(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		format   string
+		expected map[string]string
+	}{
+		{
+			format: warehouse.CSVFormat,
+			expected: map[string]string{
+				"date=2024-04-14/part-0.csv": "date,project_id,num_transactions,total_volume_usd\n" +
+					"2024-04-14,1660,1,7.5\n",
+				"date=2024-04-15/part-0.csv": "date,project_id,num_transactions,total_volume_usd\n" +
+					"2024-04-15,4974,2,0.33333333333333333333333333333333333333\n" +
+					"2024-04-15,0,1,-0.1\n",
+			},
+		},
+		{
+			format: warehouse.JSONLFormat,
+			expected: map[string]string{
+				"date=2024-04-14/part-0.jsonl": `{"date":"2024-04-14","project_id":"1660","num_transactions":1,"total_volume_usd":7.5}` + "\n",
+				"date=2024-04-15/part-0.jsonl": `{"date":"2024-04-15","project_id":"4974","num_transactions":2,"total_volume_usd":0.33333333333333333333333333333333333333}` + "\n" +
+					`{"date":"2024-04-15","project_id":"0","num_transactions":1,"total_volume_usd":-0.1}` + "\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+
+			saveFiles(t, dir, tt.format)
+
+			for file, expected := range tt.expected {
+				data, err := os.ReadFile(path.Join(dir, file))
+				require.NoError(t, err)
+				require.Equal(t, expected, string(data))
+			}
+		})
+	}
+}
+
+func TestFile_Flush_parquet(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	saveFiles(t, dir, warehouse.ParquetFormat)
+
+	type row struct {
+		Date        string `parquet:"date"`
+		ProjectID   string `parquet:"project_id"`
+		NumTxs      int64  `parquet:"num_transactions"`
+		TotalVolume string `parquet:"total_volume_usd"`
+	}
+
+	rows, err := parquet.ReadFile[row](path.Join(dir, warehouse.PartitionFile("2024-04-15", warehouse.ParquetFormat)))
+	require.NoError(t, err)
+	require.Equal(t, []row{
+		{Date: "2024-04-15", ProjectID: "4974", NumTxs: 2, TotalVolume: "0.33333333333333333333333333333333333333"},
+		{Date: "2024-04-15", ProjectID: "0", NumTxs: 1, TotalVolume: "-0.1"},
+	}, rows)
+
+	require.FileExists(t, path.Join(dir, "date=2024-04-14", "part-0.parquet"))
+}
+
+// failingStorage is the storage whose files fail to be written.
+type failingStorage struct {
+	*storage.FileSystem
+}
+
+// CreateStep creates the file whose writes fail.
+func (s failingStorage) CreateStep(ctx context.Context, file string) (io.WriteCloser, error) {
+	w, err := s.FileSystem.CreateStep(ctx, file)
+
+	return failingWriter{w}, err
+}
+
+// failingWriter is the writer whose writes fail.
+type failingWriter struct {
+	io.WriteCloser
+}
+
+// Write fails.
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestFile_Flush_failed(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir := t.TempDir()
+
+	f := warehouse.NewFile(warehouse.FileConfig{Format: warehouse.CSVFormat}, failingStorage{storage.NewFileSystem(dir, "")})
+
+	require.NoError(t, f.Save(ctx, entities.Flatten{Date: "2024-04-15", ProjectID: "4974", NumTxs: 1, TotalVolume: big.NewRat(1, 10)}))
+	require.ErrorContains(t, f.Flush(ctx), "connection reset")
+
+	// The partially written file does not replace the partition.
+	require.NoFileExists(t, path.Join(dir, warehouse.PartitionFile("2024-04-15", warehouse.CSVFormat)))
+}
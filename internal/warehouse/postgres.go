@@ -0,0 +1,297 @@
+package warehouse
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+)
+
+// PostgresType is the type of the Postgres warehouse.
+const PostgresType = "postgres"
+
+// postgresColumns are the columns of the Postgres table, in the order of the copied rows.
+var postgresColumns = []string{"date", "project_id", "num_transactions", "total_volume_usd"}
+
+// PostgresConfig is the configuration for Postgres.
+type PostgresConfig struct {
+	// URL is the connection string of the database.
+	// If it is set, the host, port, user, password, database and SSL mode are ignored.
+	URL string
+
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+
+	// SSLMode is the SSL mode of the connection.
+	// If it is empty, it will be set to prefer.
+	SSLMode string
+
+	// Table is the table to write the rows.
+	// If it is empty, it will be set to sample_data.
+	Table string
+
+	// BatchSize is the number of rows copied per batch.
+	// If it is 0, it will be set to 1000.
+	BatchSize int
+
+	// WriteMode is the mode to write the rows into the table, either AppendWriteMode, UpsertWriteMode or
+	// ReplacePartitionWriteMode. If it is empty, it will be set to AppendWriteMode.
+	//
+	// In AppendWriteMode, the rows are copied into the table, and re-runs fail on the (date, project_id) primary key.
+	// In UpsertWriteMode and ReplacePartitionWriteMode, the rows are copied into a temporary table and then inserted
+	// into the table with ON CONFLICT (date, project_id) DO UPDATE.
+	WriteMode string
+}
+
+// ConnString returns the connection string of the configuration.
+func (c PostgresConfig) ConnString() string {
+	if c.URL != "" {
+		return c.URL
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     c.Database,
+		RawQuery: url.Values{"sslmode": []string{c.SSLMode}}.Encode(),
+	}
+
+	return u.String()
+}
+
+// Postgres is a target for Postgres.
+//
+// The rows are buffered by Save and written with COPY by Flush, or every time the batch is full.
+type Postgres struct {
+	cfg PostgresConfig
+
+	conn *pgx.Conn
+
+	batch []entities.Flatten
+
+	// replaced holds the dates already replaced in ReplacePartitionWriteMode, so the rows of the same date written in
+	// previous batches are not deleted.
+	replaced map[time.Time]bool
+
+	sm   sync.Mutex
+	once sync.Once
+}
+
+// NewPostgres creates a new Postgres target.
+func NewPostgres(cfg PostgresConfig) *Postgres {
+	p := &Postgres{
+		cfg:      cfg,
+		replaced: make(map[time.Time]bool),
+	}
+
+	if p.cfg.SSLMode == "" {
+		p.cfg.SSLMode = "prefer"
+	}
+
+	if p.cfg.Table == "" {
+		p.cfg.Table = "sample_data"
+	}
+
+	if p.cfg.BatchSize == 0 {
+		p.cfg.BatchSize = 1000
+	}
+
+	if p.cfg.WriteMode == "" {
+		p.cfg.WriteMode = AppendWriteMode
+	}
+
+	return p
+}
+
+// connect connects to Postgres and creates the table if it does not exist the first time it is called.
+func (p *Postgres) connect(ctx context.Context) error {
+	var err error
+
+	p.once.Do(func() {
+		p.conn, err = pgx.Connect(ctx, p.cfg.ConnString())
+		if err != nil {
+			err = fmt.Errorf("connecting to Postgres: %w", err)
+
+			return
+		}
+
+		_, err = p.conn.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    date DATE NOT NULL,
+    project_id TEXT NOT NULL,
+    num_transactions BIGINT,
+    total_volume_usd NUMERIC,
+    PRIMARY KEY (date, project_id)
+)`, p.table()))
+		if err != nil {
+			err = fmt.Errorf("creating table: %w", err)
+		}
+	})
+
+	return err
+}
+
+// Save buffers the flatten entity to be saved into Postgres.
+func (p *Postgres) Save(ctx context.Context, f entities.Flatten) error {
+	if err := p.connect(ctx); err != nil {
+		return err
+	}
+
+	p.sm.Lock()
+	defer p.sm.Unlock()
+
+	p.batch = append(p.batch, f)
+
+	if len(p.batch) < p.cfg.BatchSize {
+		return nil
+	}
+
+	return p.write(ctx)
+}
+
+// Flush saves the buffered flatten entities into Postgres.
+func (p *Postgres) Flush(ctx context.Context) error {
+	p.sm.Lock()
+	defer p.sm.Unlock()
+
+	return p.write(ctx)
+}
+
+// Close closes the connection to Postgres.
+func (p *Postgres) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+
+	return p.conn.Close(context.Background())
+}
+
+// write writes the batch of rows in a transaction according to the write mode.
+func (p *Postgres) write(ctx context.Context) error {
+	if len(p.batch) == 0 {
+		return nil
+	}
+
+	rows, dates, err := postgresRows(p.batch)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+
+	defer tx.Rollback(context.WithoutCancel(ctx)) //nolint:errcheck
+
+	if p.cfg.WriteMode == AppendWriteMode {
+		if _, err = tx.CopyFrom(ctx, pgx.Identifier{p.cfg.Table}, postgresColumns, pgx.CopyFromRows(rows)); err != nil {
+			return fmt.Errorf("copying rows: %w", err)
+		}
+	} else if err = p.upsert(ctx, tx, rows, dates); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+
+	if p.cfg.WriteMode == ReplacePartitionWriteMode {
+		for _, d := range dates {
+			p.replaced[d] = true
+		}
+	}
+
+	p.batch = p.batch[:0]
+
+	return nil
+}
+
+// upsert copies the rows into a temporary table and inserts them into the table, updating the existing rows with
+// the same date and project id.
+//
+// In ReplacePartitionWriteMode, the rows of the dates not replaced yet are deleted before.
+func (p *Postgres) upsert(ctx context.Context, tx pgx.Tx, rows [][]any, dates []time.Time) error {
+	_, err := tx.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE staging (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP", p.table()))
+	if err != nil {
+		return fmt.Errorf("creating staging table: %w", err)
+	}
+
+	if _, err = tx.CopyFrom(ctx, pgx.Identifier{"staging"}, postgresColumns, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("copying rows: %w", err)
+	}
+
+	if p.cfg.WriteMode == ReplacePartitionWriteMode {
+		var toReplace []time.Time
+
+		for _, d := range dates {
+			if !p.replaced[d] {
+				toReplace = append(toReplace, d)
+			}
+		}
+
+		if len(toReplace) > 0 {
+			_, err = tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE date = ANY($1::date[])", p.table()), toReplace)
+			if err != nil {
+				return fmt.Errorf("deleting rows: %w", err)
+			}
+		}
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(`INSERT INTO %s (date, project_id, num_transactions, total_volume_usd)
+SELECT date, project_id, num_transactions, total_volume_usd FROM staging
+ON CONFLICT (date, project_id) DO UPDATE
+SET num_transactions = EXCLUDED.num_transactions, total_volume_usd = EXCLUDED.total_volume_usd`, p.table()))
+	if err != nil {
+		return fmt.Errorf("inserting rows: %w", err)
+	}
+
+	return nil
+}
+
+// table returns the sanitized table name.
+func (p *Postgres) table() string {
+	return pgx.Identifier{p.cfg.Table}.Sanitize()
+}
+
+// postgresRows converts the flatten entities into the rows to copy, and returns the distinct dates of the rows.
+func postgresRows(fs []entities.Flatten) ([][]any, []time.Time, error) {
+	rows := make([][]any, 0, len(fs))
+	seen := make(map[time.Time]bool)
+
+	var dates []time.Time
+
+	for _, f := range fs {
+		date, err := time.Parse(time.DateOnly, f.Date)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing date: %w", err)
+		}
+
+		var volume pgtype.Numeric
+
+		if err = volume.Scan(entities.FormatDecimal(f.TotalVolume)); err != nil {
+			return nil, nil, fmt.Errorf("parsing total volume: %w", err)
+		}
+
+		rows = append(rows, []any{date, f.ProjectID, int64(f.NumTxs), volume})
+
+		if !seen[date] {
+			seen[date] = true
+
+			dates = append(dates, date)
+		}
+	}
+
+	return rows, dates, nil
+}
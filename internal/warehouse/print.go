@@ -1,8 +1,15 @@
 package warehouse
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"text/tabwriter"
 
 	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
 )
@@ -10,12 +17,128 @@ import (
 // PrintType is the type of the Print warehouse.
 const PrintType = "print"
 
-// Print is a target to print the flatten entity.
-type Print struct{}
+const (
+	// TablePrintFormat prints the rows as an aligned table with a header row.
+	TablePrintFormat = "table"
+	// JSONPrintFormat prints the rows as a JSON array.
+	JSONPrintFormat = "json"
+	// CSVPrintFormat prints the rows as CSV with a header row.
+	CSVPrintFormat = "csv"
+)
+
+// PrintConfig is the configuration for Print.
+type PrintConfig struct {
+	// Format is the format to print the rows, either TablePrintFormat, JSONPrintFormat or CSVPrintFormat.
+	// If it is empty, it will be set to TablePrintFormat.
+	Format string
+}
+
+// PrintOption is a function that configures Print.
+type PrintOption func(p *Print)
+
+// WithOutput sets the writer to print the rows, by default os.Stdout.
+func WithOutput(w io.Writer) PrintOption {
+	return func(p *Print) {
+		p.out = w
+	}
+}
+
+// Print is a target to print the flatten entities.
+//
+// The rows are buffered by Save and printed by Flush sorted by date and project id, so the output is deterministic.
+// The zero value prints the rows as a table into os.Stdout.
+type Print struct {
+	cfg PrintConfig
+
+	out io.Writer
+
+	batch []entities.Flatten
 
-// Save saves the flatten entity.
+	sm sync.Mutex
+}
+
+// NewPrint creates a new Print target.
+func NewPrint(cfg PrintConfig, opts ...PrintOption) *Print {
+	p := &Print{
+		cfg: cfg,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Save buffers the flatten entity to be printed.
 func (p *Print) Save(_ context.Context, f entities.Flatten) error {
-	fmt.Printf("Save: %v\n", f)
+	p.sm.Lock()
+	defer p.sm.Unlock()
+
+	p.batch = append(p.batch, f)
 
 	return nil
 }
+
+// Flush prints the buffered flatten entities sorted by date and project id.
+func (p *Print) Flush(_ context.Context) error {
+	p.sm.Lock()
+	defer p.sm.Unlock()
+
+	if len(p.batch) == 0 {
+		return nil
+	}
+
+	slices.SortFunc(p.batch, func(a, b entities.Flatten) int {
+		return cmp.Or(cmp.Compare(a.Date, b.Date), cmp.Compare(a.ProjectID, b.ProjectID))
+	})
+
+	out := p.out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	var err error
+
+	switch p.cfg.Format {
+	case JSONPrintFormat:
+		err = printJSON(out, p.batch)
+	case CSVPrintFormat:
+		err = writeCSV(out, p.batch)
+	default:
+		err = printTable(out, p.batch)
+	}
+
+	if err != nil {
+		return fmt.Errorf("printing rows: %w", err)
+	}
+
+	p.batch = p.batch[:0]
+
+	return nil
+}
+
+// printTable prints the rows as an aligned table with a header row.
+func printTable(w io.Writer, fs []entities.Flatten) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	if _, err := fmt.Fprintln(tw, "DATE\tPROJECT_ID\tNUM_TRANSACTIONS\tTOTAL_VOLUME_USD"); err != nil {
+		return err
+	}
+
+	for _, f := range fs {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", f.Date, f.ProjectID, f.NumTxs, entities.FormatDecimal(f.TotalVolume)); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+// printJSON prints the rows as an indented JSON array.
+func printJSON(w io.Writer, fs []entities.Flatten) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(newFileRows(fs))
+}
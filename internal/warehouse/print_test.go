@@ -3,6 +3,7 @@ package warehouse
 import (
 	"bytes"
 	"context"
+	"math/big"
 	"os"
 	"testing"
 
@@ -16,12 +17,15 @@ func TestPrint_Save(t *testing.T) {
 
 	ctx := context.Background()
 
+	totalVolume, ok := new(big.Rat).SetString("0.6136203411678249")
+	require.True(t, ok)
+
 	// Mock the flatten entity.
 	flatten := entities.Flatten{
 		Date:        "2024-04-15",
 		ProjectID:   "4974",
 		NumTxs:      5,
-		TotalVolume: 0.6136203411678249,
+		TotalVolume: totalVolume,
 	}
 
 	// Redirect os.Stdout to capture output
@@ -37,6 +41,9 @@ func TestPrint_Save(t *testing.T) {
 	err := p.Save(ctx, flatten)
 	require.NoError(t, err)
 
+	err = p.Flush(ctx)
+	require.NoError(t, err)
+
 	// Close the writer and restore os.Stdout
 	w.Close() //nolint:errcheck,gosec
 
@@ -46,5 +53,84 @@ func TestPrint_Save(t *testing.T) {
 	buf.ReadFrom(r) //nolint:errcheck,gosec
 
 	// Check the printed record.
-	require.Equal(t, "Save: {2024-04-15 4974 5 0.6136203411678249}\n", buf.String())
+	require.Equal(t, "DATE        PROJECT_ID  NUM_TRANSACTIONS  TOTAL_VOLUME_USD\n"+
+		"2024-04-15  4974        5                 0.6136203411678249\n", buf.String())
+}
+
+func TestPrint_Flush(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format: TablePrintFormat,
+			expected: "DATE        PROJECT_ID  NUM_TRANSACTIONS  TOTAL_VOLUME_USD\n" +
+				"2024-04-14  4974        1                 7.5\n" +
+				"2024-04-15  0           3                 -0.1\n" +
+				"2024-04-15  4974        2                 12.3\n",
+		},
+		{
+			format: JSONPrintFormat,
+			expected: `[
+  {
+    "date": "2024-04-14",
+    "project_id": "4974",
+    "num_transactions": 1,
+    "total_volume_usd": 7.5
+  },
+  {
+    "date": "2024-04-15",
+    "project_id": "0",
+    "num_transactions": 3,
+    "total_volume_usd": -0.1
+  },
+  {
+    "date": "2024-04-15",
+    "project_id": "4974",
+    "num_transactions": 2,
+    "total_volume_usd": 12.3
+  }
+]
+`,
+		},
+		{
+			format: CSVPrintFormat,
+			expected: "date,project_id,num_transactions,total_volume_usd\n" +
+				"2024-04-14,4974,1,7.5\n" +
+				"2024-04-15,0,3,-0.1\n" +
+				"2024-04-15,4974,2,12.3\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			var buf bytes.Buffer
+
+			p := NewPrint(PrintConfig{Format: tt.format}, WithOutput(&buf))
+
+			for _, f := range []entities.Flatten{
+				{Date: "2024-04-15", ProjectID: "4974", NumTxs: 2, TotalVolume: big.NewRat(123, 10)},
+				{Date: "2024-04-14", ProjectID: "4974", NumTxs: 1, TotalVolume: big.NewRat(15, 2)},
+				{Date: "2024-04-15", ProjectID: "0", NumTxs: 3, TotalVolume: big.NewRat(-1, 10)},
+			} {
+				require.NoError(t, p.Save(ctx, f))
+			}
+
+			// Nothing is printed until flush.
+			require.Empty(t, buf.String())
+
+			require.NoError(t, p.Flush(ctx))
+			require.Equal(t, tt.expected, buf.String())
+
+			// Nothing is printed when there are no buffered rows.
+			require.NoError(t, p.Flush(ctx))
+			require.Equal(t, tt.expected, buf.String())
+		})
+	}
 }
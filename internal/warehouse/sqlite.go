@@ -0,0 +1,214 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+
+	_ "modernc.org/sqlite" // Register the sqlite driver.
+)
+
+// SQLiteType is the type of the SQLite warehouse.
+const SQLiteType = "sqlite"
+
+// SQLiteConfig is the configuration for SQLite.
+type SQLiteConfig struct {
+	// Path is the path of the database file, created if it does not exist.
+	Path string
+
+	// Table is the table to write the rows.
+	// If it is empty, it will be set to sample_data.
+	Table string
+
+	// WriteMode is the mode to write the rows into the table, either AppendWriteMode, UpsertWriteMode or
+	// ReplacePartitionWriteMode. If it is empty, it will be set to AppendWriteMode.
+	WriteMode string
+}
+
+// SQLite is a target for a SQLite database file, to query the output of local runs without any cloud account.
+//
+// The table has the same columns as the BigQuery table, inferred from entities.Flatten, with the (date, project_id)
+// primary key. The rows are buffered by Save and written in a single transaction by Flush.
+type SQLite struct {
+	cfg SQLiteConfig
+
+	db *sql.DB
+
+	batch []entities.Flatten
+
+	sm   sync.Mutex
+	once sync.Once
+}
+
+// NewSQLite creates a new SQLite target.
+func NewSQLite(cfg SQLiteConfig) *SQLite {
+	s := &SQLite{
+		cfg: cfg,
+	}
+
+	if s.cfg.Table == "" {
+		s.cfg.Table = "sample_data"
+	}
+
+	if s.cfg.WriteMode == "" {
+		s.cfg.WriteMode = AppendWriteMode
+	}
+
+	return s
+}
+
+// open opens the database and creates the table if it does not exist the first time it is called.
+func (s *SQLite) open(ctx context.Context) error {
+	var err error
+
+	s.once.Do(func() {
+		s.db, err = sql.Open("sqlite", s.cfg.Path)
+		if err != nil {
+			err = fmt.Errorf("opening database: %w", err)
+
+			return
+		}
+
+		var schema bigquery.Schema
+
+		schema, err = flattenSchema()
+		if err != nil {
+			return
+		}
+
+		if _, err = s.db.ExecContext(ctx, sqliteCreateTableDDL(s.cfg.Table, schema)); err != nil {
+			err = fmt.Errorf("creating table: %w", err)
+		}
+	})
+
+	return err
+}
+
+// Save buffers the flatten entity to be saved into SQLite.
+func (s *SQLite) Save(ctx context.Context, f entities.Flatten) error {
+	if err := s.open(ctx); err != nil {
+		return err
+	}
+
+	s.sm.Lock()
+	defer s.sm.Unlock()
+
+	s.batch = append(s.batch, f)
+
+	return nil
+}
+
+// Flush saves the buffered flatten entities into SQLite in a single transaction.
+//
+// In ReplacePartitionWriteMode, the rows of the dates being saved are deleted before.
+func (s *SQLite) Flush(ctx context.Context) error {
+	s.sm.Lock()
+	defer s.sm.Unlock()
+
+	if len(s.batch) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+
+	defer tx.Rollback() //nolint:errcheck
+
+	table := sqliteIdentifier(s.cfg.Table)
+
+	if s.cfg.WriteMode == ReplacePartitionWriteMode {
+		deleted := make(map[string]bool)
+
+		for _, f := range s.batch {
+			if deleted[f.Date] {
+				continue
+			}
+
+			if _, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE date = ?", table), f.Date); err != nil {
+				return fmt.Errorf("deleting rows: %w", err)
+			}
+
+			deleted[f.Date] = true
+		}
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (date, project_id, num_transactions, total_volume_usd) VALUES (?, ?, ?, ?)", table)
+
+	if s.cfg.WriteMode != AppendWriteMode {
+		query += " ON CONFLICT (date, project_id) DO UPDATE SET" +
+			" num_transactions = excluded.num_transactions, total_volume_usd = excluded.total_volume_usd"
+	}
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("preparing insert: %w", err)
+	}
+
+	defer stmt.Close() //nolint:errcheck
+
+	for _, f := range s.batch {
+		if _, err = stmt.ExecContext(ctx, f.Date, f.ProjectID, f.NumTxs, entities.FormatDecimal(f.TotalVolume)); err != nil {
+			return fmt.Errorf("inserting row: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+
+	s.batch = s.batch[:0]
+
+	return nil
+}
+
+// Close closes the database.
+func (s *SQLite) Close() error {
+	if s.db == nil {
+		return nil
+	}
+
+	return s.db.Close()
+}
+
+// sqliteCreateTableDDL returns the DDL statement to create the table with the given schema and the (date, project_id)
+// primary key.
+//
+// The BIGNUMERIC columns are stored as TEXT, SQLite converts the NUMERIC values to REAL losing the precision.
+func sqliteCreateTableDDL(table string, schema bigquery.Schema) string {
+	columns := make([]string, 0, len(schema)+1)
+
+	for _, field := range schema {
+		columns = append(columns, fmt.Sprintf("    %s %s", field.Name, sqliteType(field.Type)))
+	}
+
+	columns = append(columns, "    PRIMARY KEY (date, project_id)")
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n)", sqliteIdentifier(table), strings.Join(columns, ",\n"))
+}
+
+// sqliteType returns the SQLite type of the field type.
+func sqliteType(ft bigquery.FieldType) string {
+	switch ft { //nolint:exhaustive
+	case bigquery.IntegerFieldType:
+		return "INTEGER"
+	case bigquery.FloatFieldType:
+		return "REAL"
+	case bigquery.BooleanFieldType:
+		return "BOOLEAN"
+	default:
+		return "TEXT"
+	}
+}
+
+// sqliteIdentifier returns the quoted identifier.
+func sqliteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
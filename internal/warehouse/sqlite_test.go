@@ -0,0 +1,164 @@
+package warehouse_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dohernandez/horizon-blockchain-games/internal/entities"
+	"github.com/dohernandez/horizon-blockchain-games/internal/warehouse"
+)
+
+// querySQLiteRows returns the rows of the sample_data table ordered by date and project id, formatted as
+// <date>,<project_id>,<num_transactions>,<total_volume_usd>.
+func querySQLiteRows(t *testing.T, dbPath string) []string {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+
+	defer db.Close() //nolint:errcheck
+
+	rows, err := db.Query("SELECT date, project_id, num_transactions, total_volume_usd FROM sample_data ORDER BY date, project_id")
+	require.NoError(t, err)
+
+	defer rows.Close() //nolint:errcheck
+
+	var got []string
+
+	for rows.Next() {
+		var (
+			date, projectID, volume string
+			numTxs                  int64
+		)
+
+		require.NoError(t, rows.Scan(&date, &projectID, &numTxs, &volume))
+
+		got = append(got, fmt.Sprintf("%s,%s,%d,%s", date, projectID, numTxs, volume))
+	}
+
+	require.NoError(t, rows.Err())
+
+	return got
+}
+
+func TestSQLite_Save(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		writeMode string
+		expected  []string
+		err       bool
+	}{
+		{
+			writeMode: warehouse.AppendWriteMode,
+			// The re-run fails on the primary key and the rows of the first run are kept.
+			expected: []string{
+				"2024-04-14,1660,7,7.5",
+				"2024-04-15,0,7,7.5",
+				"2024-04-15,1660,7,7.5",
+			},
+			err: true,
+		},
+		{
+			writeMode: warehouse.UpsertWriteMode,
+			// The row missing in the re-run is kept.
+			expected: []string{
+				"2024-04-14,1660,7,7.5",
+				"2024-04-15,0,7,7.5",
+				"2024-04-15,1660,2,0.2",
+				"2024-04-15,4974,2,0.2",
+			},
+		},
+		{
+			writeMode: warehouse.ReplacePartitionWriteMode,
+			// The row missing in the re-run is deleted.
+			expected: []string{
+				"2024-04-14,1660,7,7.5",
+				"2024-04-15,1660,2,0.2",
+				"2024-04-15,4974,2,0.2",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.writeMode, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			dbPath := path.Join(t.TempDir(), "sequence.db")
+
+			save := func(numTxs int, volume *big.Rat, fs ...entities.Flatten) error {
+				s := warehouse.NewSQLite(warehouse.SQLiteConfig{Path: dbPath, WriteMode: tt.writeMode})
+
+				defer s.Close() //nolint:errcheck
+
+				for _, f := range fs {
+					f.NumTxs = numTxs
+					f.TotalVolume = volume
+
+					require.NoError(t, s.Save(ctx, f))
+				}
+
+				return s.Flush(ctx)
+			}
+
+			// The first run writes the rows of both dates.
+			err := save(7, big.NewRat(15, 2),
+				entities.Flatten{Date: "2024-04-14", ProjectID: "1660"},
+				entities.Flatten{Date: "2024-04-15", ProjectID: "1660"},
+				entities.Flatten{Date: "2024-04-15", ProjectID: "0"},
+			)
+			require.NoError(t, err)
+
+			// The re-run of the second date.
+			err = save(2, big.NewRat(1, 5),
+				entities.Flatten{Date: "2024-04-15", ProjectID: "1660"},
+				entities.Flatten{Date: "2024-04-15", ProjectID: "4974"},
+			)
+
+			if tt.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, tt.expected, querySQLiteRows(t, dbPath))
+		})
+	}
+}
+
+func TestSQLite_Save_precision(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dbPath := path.Join(t.TempDir(), "sequence.db")
+
+	s := warehouse.NewSQLite(warehouse.SQLiteConfig{Path: dbPath})
+
+	defer s.Close() //nolint:errcheck
+
+	err := s.Save(ctx, entities.Flatten{
+		Date:        "2024-04-15",
+		ProjectID:   "4974",
+		NumTxs:      1,
+		TotalVolume: big.NewRat(1, 3),
+	})
+	require.NoError(t, err)
+
+	// Nothing is written until flush.
+	require.Empty(t, querySQLiteRows(t, dbPath))
+
+	require.NoError(t, s.Flush(ctx))
+
+	require.Equal(t, []string{
+		"2024-04-15,4974,1,0.33333333333333333333333333333333333333",
+	}, querySQLiteRows(t, dbPath))
+}
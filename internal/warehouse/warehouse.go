@@ -0,0 +1,10 @@
+package warehouse
+
+const (
+	// AppendWriteMode appends the rows to the table.
+	AppendWriteMode = "append"
+	// UpsertWriteMode inserts the rows, or updates them when a row with the same date and project id exists.
+	UpsertWriteMode = "upsert"
+	// ReplacePartitionWriteMode deletes the rows of the dates of the inserted rows before inserting them.
+	ReplacePartitionWriteMode = "replace-partition"
+)
@@ -0,0 +1,48 @@
+// Package version provides the build information of the application, set at build time with -ldflags.
+package version
+
+import "runtime/debug"
+
+// Build information populated at build time by pkg/makefiles/version-ldflags.sh.
+var (
+	version   string
+	branch    string
+	revision  string
+	buildUser string
+	buildDate string
+)
+
+// Info holds the build information.
+type Info struct {
+	Version   string
+	Branch    string
+	Revision  string
+	BuildUser string
+	BuildDate string
+}
+
+// Current returns the build information of the running binary.
+func Current() Info {
+	return Info{
+		Version:   Version(),
+		Branch:    branch,
+		Revision:  revision,
+		BuildUser: buildUser,
+		BuildDate: buildDate,
+	}
+}
+
+// Version returns the version of the running binary.
+//
+// When it is not set at build time, the version of the main module is used, or "dev" if it is not available.
+func Version() string {
+	if version != "" {
+		return version
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+
+	return "dev"
+}